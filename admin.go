@@ -0,0 +1,146 @@
+package badnet
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+// AdminAddr returns the address of the admin endpoint started for Config.AdminListen,
+// or an empty string when it's disabled.
+func (p *Proxy) AdminAddr() string {
+	return p.adminAddr
+}
+
+// AdminHandler serves the proxy's state as JSON so it can be inspected while tests
+// run. It's served on Config.AdminListen and can also be mounted elsewhere.
+//
+//	GET /        the effective config, open connections and stats
+//	GET /health  responds "ok" while the proxy is running
+func (p *Proxy) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(p.adminState())
+	})
+	return mux
+}
+
+type adminState struct {
+	Listen            string
+	ActiveConnections int32
+	Connections       []ConnectionInfo
+	Stats             Stats
+	Config            interface{}
+}
+
+func (p *Proxy) adminState() adminState {
+	return adminState{
+		Listen:            p.BindAddr(),
+		ActiveConnections: p.active.Load(),
+		Connections:       p.Connections(),
+		Stats:             p.Stats(),
+		Config:            jsonValue(reflect.ValueOf(p.EffectiveConfig())),
+	}
+}
+
+// startAdmin serves AdminHandler on addr until the returned function is called.
+func (p *Proxy) startAdmin(addr string) (func(), error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("admin listen: %w", err)
+	}
+	p.adminAddr = ln.Addr().String()
+
+	server := &http.Server{
+		Handler:           p.AdminHandler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go server.Serve(ln)
+
+	return func() { server.Close() }, nil
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	errorType    = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// jsonValue converts a Config into values encoding/json can marshal. Functions and
+// interfaces are described rather than encoded and durations are formatted.
+func jsonValue(v reflect.Value) interface{} {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		out := make(map[string]interface{})
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.IsExported() {
+				out[field.Name] = jsonValue(v.Field(i))
+			}
+		}
+		return out
+
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		switch elem := v.Interface().(type) {
+		case *Proxy:
+			return elem.BindAddr()
+		case *tls.Config:
+			return "<tls.Config>"
+		}
+		return jsonValue(v.Elem())
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Type().Implements(errorType) {
+			return v.Interface().(error).Error()
+		}
+		return fmt.Sprintf("%T", v.Interface())
+
+	case reflect.Func, reflect.Chan:
+		return describeFunc(v)
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			out[i] = jsonValue(v.Index(i))
+		}
+		return out
+
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		out := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			out[fmt.Sprint(iter.Key().Interface())] = jsonValue(iter.Value())
+		}
+		return out
+	}
+	return v.Interface()
+}
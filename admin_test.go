@@ -0,0 +1,81 @@
+package badnet
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdmin(t *testing.T) {
+	proxy := ForTest(t, Config{
+		Listen:      "127.0.0.1:0",
+		Target:      echoServer(t),
+		AdminListen: "127.0.0.1:0",
+		Read: Direction{
+			Latency: time.Millisecond,
+			Error:   errors.New("injected"),
+			ErrorFactory: func() error {
+				return nil
+			},
+		},
+	})
+	require.NotEmpty(t, proxy.AdminAddr())
+
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadFull(conn, make([]byte, 5))
+	require.NoError(t, err)
+
+	resp, err := http.Get("http://" + proxy.AdminAddr() + "/health")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Get("http://" + proxy.AdminAddr() + "/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	var state struct {
+		Listen            string
+		ActiveConnections int
+		Connections       []ConnectionInfo
+		Stats             struct {
+			Connections   int
+			ReadBytes     int
+			InjectedDelay struct {
+				Count int
+				P50   string
+			}
+		}
+		Config struct {
+			Read struct {
+				Latency      string
+				Error        string
+				ErrorFactory string
+			}
+		}
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&state))
+
+	require.Equal(t, proxy.BindAddr(), state.Listen)
+	require.Equal(t, 1, state.ActiveConnections)
+	require.Len(t, state.Connections, 1)
+	require.Equal(t, 1, state.Stats.Connections)
+	require.Equal(t, 5, state.Stats.ReadBytes)
+	require.Equal(t, 1, state.Stats.InjectedDelay.Count)
+	require.Equal(t, "1ms", state.Stats.InjectedDelay.P50)
+	require.Equal(t, "1ms", state.Config.Read.Latency)
+	require.Equal(t, "injected", state.Config.Read.Error)
+	require.Equal(t, "<func>", state.Config.Read.ErrorFactory)
+}
@@ -0,0 +1,205 @@
+package badnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sync"
+)
+
+// AMQPDropHeartbeats drops ratio percent of the heartbeat frames sent either way on
+// AMQP 0-9-1 connections, such as to RabbitMQ, so clients have to notice a peer
+// which has gone quiet and reconnect. Other frames are forwarded unchanged. Use it
+// with Config.Toxics or a Direction's Toxics to drop heartbeats one way.
+func AMQPDropHeartbeats(ratio int) Toxic {
+	return unrecordedToxic{func(c net.Conn, _ ToxicInfo) net.Conn {
+		return &amqpHeartbeatConn{
+			Conn:  c,
+			read:  &amqpFrames{ratio: ratio},
+			write: &amqpFrames{ratio: ratio},
+		}
+	}}
+}
+
+const (
+	amqpFrameHeartbeat = 8
+	amqpFrameEnd       = 0xCE
+)
+
+// amqpFrames splits a stream into AMQP frames, dropping some heartbeats. Only
+// heartbeats are held until they're whole. Other frames are forwarded as they
+// arrive, however large they are.
+type amqpFrames struct {
+	ratio int
+
+	// buf holds data which isn't a whole heartbeat or frame header yet
+	buf []byte
+
+	// streaming is how much of the current frame, up to and including its end
+	// byte, is still to be forwarded.
+	streaming int64
+
+	// started is set once the protocol header clients begin with has been
+	// checked for, and raw once data which isn't AMQP is seen.
+	started, raw bool
+}
+
+// filter returns data without the dropped heartbeats, holding back the start of
+// a heartbeat or frame header until the rest arrives.
+func (f *amqpFrames) filter(data []byte) []byte {
+	if f.raw {
+		return data
+	}
+	f.buf = append(f.buf, data...)
+
+	var out []byte
+	if !f.started {
+		if len(f.buf) < 4 {
+			return nil
+		}
+		if bytes.HasPrefix(f.buf, []byte("AMQP")) {
+			if len(f.buf) < 8 {
+				return nil
+			}
+			out = append(out, f.buf[:8]...)
+			f.buf = f.buf[8:]
+		}
+		f.started = true
+	}
+
+	for len(f.buf) > 0 {
+		if f.streaming > 0 {
+			n := int(min(f.streaming, int64(len(f.buf))))
+			out = append(out, f.buf[:n]...)
+			f.streaming -= int64(n)
+			last := f.buf[n-1]
+			f.buf = f.buf[n:]
+			if f.streaming == 0 && last != amqpFrameEnd {
+				out = append(out, f.goRaw()...)
+			}
+			continue
+		}
+		if !amqpFrameType(f.buf[0]) {
+			out = append(out, f.goRaw()...)
+			break
+		}
+		if len(f.buf) < 7 {
+			break
+		}
+		payload := binary.BigEndian.Uint32(f.buf[3:7])
+		if f.buf[0] != amqpFrameHeartbeat {
+			f.streaming = 7 + int64(payload) + 1
+			continue
+		}
+
+		// Heartbeats have no payload
+		if payload != 0 {
+			out = append(out, f.goRaw()...)
+			break
+		}
+		if len(f.buf) < 8 {
+			break
+		}
+		if f.buf[7] != amqpFrameEnd {
+			out = append(out, f.goRaw()...)
+			break
+		}
+		if !shouldFail(f.ratio) {
+			out = append(out, f.buf[:8]...)
+		}
+		f.buf = f.buf[8:]
+	}
+	return out
+}
+
+// goRaw stops parsing frames, returning the data held so far.
+func (f *amqpFrames) goRaw() []byte {
+	f.raw = true
+	return f.flush()
+}
+
+// flush returns the data held waiting for the rest of a frame.
+func (f *amqpFrames) flush() []byte {
+	out := f.buf
+	f.buf = nil
+	return out
+}
+
+// amqpFrameType reports if b is a method, header, body or heartbeat frame.
+func amqpFrameType(b byte) bool {
+	return b == 1 || b == 2 || b == 3 || b == amqpFrameHeartbeat
+}
+
+// amqpHeartbeatConn filters the frames read and written.
+type amqpHeartbeatConn struct {
+	net.Conn
+
+	read, write *amqpFrames
+
+	// pending holds filtered data which didn't fit in the caller's buffer.
+	pending []byte
+
+	// writeMu is held while writing so Close doesn't race a Write for held data
+	writeMu sync.Mutex
+}
+
+// NetConn returns the underlying connection.
+func (c *amqpHeartbeatConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *amqpHeartbeatConn) Read(b []byte) (int, error) {
+	for len(c.pending) == 0 {
+		n, err := c.Conn.Read(b)
+		c.pending = c.read.filter(b[:n])
+		if err != nil {
+			// Nothing more is coming to complete a partial frame
+			c.pending = append(c.pending, c.read.flush()...)
+			if len(c.pending) == 0 {
+				return 0, err
+			}
+			break
+		}
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *amqpHeartbeatConn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if out := c.write.filter(b); len(out) > 0 {
+		if _, err := c.Conn.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// flushWrites sends data held waiting for the rest of a frame.
+func (c *amqpHeartbeatConn) flushWrites() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if out := c.write.flush(); len(out) > 0 {
+		_, err := c.Conn.Write(out)
+		return err
+	}
+	return nil
+}
+
+// Close sends any partial frame held from writes before closing.
+func (c *amqpHeartbeatConn) Close() error {
+	c.flushWrites()
+	return c.Conn.Close()
+}
+
+// CloseWrite sends any partial frame held from writes before closing the write side.
+func (c *amqpHeartbeatConn) CloseWrite() error {
+	if err := c.flushWrites(); err != nil {
+		return err
+	}
+	return closeWrite(c.Conn)
+}
@@ -0,0 +1,66 @@
+package badnet
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAMQPFrames(t *testing.T) {
+	header := []byte("AMQP\x00\x00\x09\x01")
+	heartbeat := []byte{8, 0, 0, 0, 0, 0, 0, 0xCE}
+	method := []byte{1, 0, 1, 0, 0, 0, 4, 0, 10, 0, 11, 0xCE}
+
+	f := &amqpFrames{ratio: 100}
+	require.Empty(t, f.filter(header[:3]))
+	require.Equal(t, header, f.filter(header[3:]))
+
+	// Heartbeats are dropped and frames split across writes are put back together
+	require.Empty(t, f.filter(heartbeat))
+	require.Empty(t, f.filter(method[:5]))
+	require.Equal(t, method, f.filter(append(method[5:], heartbeat...)))
+
+	// while data which isn't AMQP is forwarded as-is
+	f = &amqpFrames{ratio: 100}
+	require.Equal(t, []byte("GET / HTTP/1.1\r\n"), f.filter([]byte("GET / HTTP/1.1\r\n")))
+	require.Equal(t, heartbeat, f.filter(heartbeat))
+
+	// Frames other than heartbeats are streamed through as they arrive, however
+	// large, and heartbeats after them are still dropped
+	f = &amqpFrames{ratio: 100, started: true}
+	body := make([]byte, 256*1024)
+	frame := append([]byte{3, 0, 1}, binary.BigEndian.AppendUint32(nil, uint32(len(body)))...)
+	frame = append(append(frame, body...), 0xCE)
+	var streamed []byte
+	for i := 0; i < len(frame); i += 32 * 1024 {
+		chunk := frame[i:min(i+32*1024, len(frame))]
+		out := f.filter(chunk)
+		require.Len(t, out, len(chunk))
+		streamed = append(streamed, out...)
+	}
+	require.Equal(t, frame, streamed)
+	require.Empty(t, f.filter(heartbeat))
+
+	// Frames without an end byte mean the data isn't AMQP
+	f = &amqpFrames{ratio: 100, started: true}
+	bad := []byte{1, 0, 1, 0, 0, 0, 1, 0, 0}
+	require.Equal(t, bad, f.filter(bad))
+	require.Equal(t, heartbeat, f.filter(heartbeat))
+}
+
+func TestAMQPClose(t *testing.T) {
+	client, _ := net.Pipe()
+	dst := &recordingConn{Conn: client}
+	conn := AMQPDropHeartbeats(100).Wrap(dst, ToxicInfo{})
+
+	// A partial frame is held until the rest arrives, or the connection closes
+	method := []byte{1, 0, 1, 0, 0, 0, 4, 0, 10, 0, 11, 0xCE}
+	_, err := conn.Write(method[:5])
+	require.NoError(t, err)
+	require.Zero(t, dst.buf.Len())
+
+	require.NoError(t, conn.Close())
+	require.Equal(t, method[:5], dst.buf.Bytes())
+}
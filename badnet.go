@@ -1,41 +1,340 @@
 package badnet
 
 import (
-	"bufio"
-	"bytes"
 	"context"
 	"crypto/rand"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"math/big"
 	"net"
-	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
-
-	"go4.org/net/throttle"
 )
 
 type Config struct {
+	// Listen is the address the proxy accepts connections on and Target is where they're
+	// forwarded to. Target can be a host:port, a URL or a unix socket (unix:///path/to.sock).
+	// IPv6 addresses are written in brackets, e.g. [::1]:0
 	Listen, Target string
 
+	// ValidateTarget checks the targets can be reached when the proxy is created,
+	// failing the test straight away with a clear error instead of on the first
+	// proxied connection. Targets are dialed without any faults unless TargetProbe
+	// is set, which is called with each target's address instead, such as to make
+	// a health check request.
+	ValidateTarget bool
+	TargetProbe    func(ctx context.Context, address string) error
+
+	// Context, when canceled, stops the proxy accepting connections and closes
+	// those which are open, as the test finishing does. ConnContext optionally
+	// replaces the context of each new connection, which is derived from Context,
+	// and canceling it closes just that connection, like http.Server.ConnContext.
+	Context     context.Context
+	ConnContext func(ctx context.Context, c net.Conn) context.Context
+
+	// Each connection is given an ID, returned by ConnectionID and included in
+	// ToxicInfo and Proxy.Connections, so application logs can be matched with the
+	// connection and the faults it saw. ConnectionIDHeader adds the ID to HTTP
+	// requests as a header with that name, e.g. "X-Badnet-Connection", and
+	// LogConnections logs each connection's ID with the test.
+	ConnectionIDHeader string
+	LogConnections     bool
+
+	// TargetProxy chains this proxy in front of another, which becomes the Target.
+	// Each stage applies only its own faults, so layered conditions like a slow WAN
+	// in front of a flaky LAN add up, and the Host header is left for the last stage
+	// to rewrite. See Proxy.Chain, and Proxy.ChainStats for the stats of every stage.
+	TargetProxy *Proxy
+
+	// ListenNetwork is the network Listen is bound on: "tcp" (the default) listens on
+	// both IPv4 and IPv6 where available, while "tcp4" and "tcp6" restrict it to one.
+	ListenNetwork string
+
+	// ListenAddrs are more addresses the proxy accepts connections on alongside
+	// Listen, sharing its target and faults, such as an IPv4 and an IPv6 address
+	// or several ports, so clients resolving multiple records can be tested
+	// through one proxy. See Proxy.BindAddrs.
+	ListenAddrs []string
+
+	// BindRetry retries, or falls back to another port, when a fixed Listen port
+	// is in use instead of failing the test straight away.
+	BindRetry BindRetry
+
 	Read  Direction
 	Write Direction
+
+	// Network sets Read and Write from conditions described by where the client is,
+	// instead of which way the proxy reads and writes. See Network.
+	Network Network
+
+	// ListenTLS makes the proxy terminate TLS from clients using a certificate signed by a
+	// throwaway CA. Faults are applied to the decrypted stream. Clients can trust the
+	// certificate with Proxy.ClientTLSConfig().
+	ListenTLS bool
+
+	// BadCertificates presents invalid certificates on some connections when ListenTLS is enabled.
+	BadCertificates BadCertificates
+
+	// ClientCertificates requires clients to present certificates when ListenTLS is enabled.
+	ClientCertificates ClientCertificates
+
+	// Bursts schedule periods of faults tied to the proxy's connection count.
+	Bursts []Burst
+
+	// OfflineFallback records the responses the target gives to each request, and
+	// serves the most recent one for a matching request whenever the target can't
+	// be reached, like a cache covering for an outage. HTTP requests are matched by
+	// their request line and other protocols by their exact bytes. Connections with
+	// a request that wasn't recorded are closed.
+	OfflineFallback bool
+
+	// HijackRatio is the percentage of connections sent to HijackTarget instead of
+	// their target, such as a stub returning errors or stale data, to reproduce
+	// misrouted traffic or a split brain behind a load balancer. HijackTarget uses
+	// the same TLS settings as the target it replaces. See Proxy.Connections for
+	// which connections were hijacked.
+	HijackRatio  int
+	HijackTarget string
+
+	// CloseAfterAcceptRatio is the percentage of connections closed immediately after
+	// they're accepted, before anything is forwarded, so clients read EOF without a
+	// response rather than having their connection refused.
+	CloseAfterAcceptRatio int
+
+	// Tarpit holds some connections open, answering them too slowly to ever finish.
+	Tarpit Tarpit
+
+	// ConnectDelay, plus up to ConnectJitter, passes between accepting a connection
+	// and dialing the target to simulate slow connection setup, such as an overloaded
+	// accept queue. Nothing is forwarded in either direction until it's over.
+	ConnectDelay  time.Duration
+	ConnectJitter time.Duration
+
+	// IdleTimeout closes connections which haven't sent or received any data for
+	// the duration, like a NAT or firewall dropping idle flows.
+	IdleTimeout time.Duration
+
+	// MaxConnections caps how many connections are proxied at once, with
+	// ConnectionLimit deciding if more are refused (the default) or queued.
+	MaxConnections  int
+	ConnectionLimit LimitPolicy
+
+	// MaxNewConnectionsPerSecond limits how quickly the proxy accepts connections,
+	// like SYN throttling or a full listen backlog. NewConnectionLimit decides if
+	// connections arriving too quickly are refused (the default) or delayed.
+	MaxNewConnectionsPerSecond float64
+	NewConnectionLimit         LimitPolicy
+
+	// MaxConnectionAge closes connections after they've been open for the duration,
+	// plus up to MaxConnectionAgeJitter, regardless of activity. This models load
+	// balancers which recycle long-lived connections.
+	MaxConnectionAge       time.Duration
+	MaxConnectionAgeJitter time.Duration
+
+	// MaxBytesPerConnection closes connections, according to CloseMode, once this
+	// many bytes have been forwarded in both directions combined, like captive
+	// portals and middleboxes which cut off large transfers. Exactly this many
+	// bytes are delivered first, so resumable transfers can be tested reliably.
+	MaxBytesPerConnection int64
+
+	// FailFirstConnections closes the first connections the proxy accepts and
+	// FailConnectionsAfter closes every connection once that many have been accepted,
+	// e.g. FailFirstConnections: 3 lets a client's fourth attempt succeed. A Dialer
+	// refuses those dials instead. Zero disables either option.
+	FailFirstConnections uint32
+	FailConnectionsAfter uint32
+
+	// SNIRoutes sends TLS connections to other targets, with their own faults, based on
+	// the server name the client requests. Connections for other server names use Target.
+	// The server name is read from the TLS handshake when ListenTLS is enabled and
+	// otherwise peeked from the ClientHello as it passes through, in which case protocols
+	// where the server speaks first are not supported.
+	SNIRoutes map[string]SNIRoute
+
+	// TLSHandshake injects faults into TLS handshakes made with the proxy.
+	TLSHandshake TLSHandshakeFaults
+
+	// Targets spreads connections across several backends according to Balance.
+	// Target is ignored when Targets is set.
+	Targets       []string
+	Balance       BalanceStrategy
+	TargetOptions map[string]TargetOptions
+
+	// Clients replaces the faults for connections from particular clients, keyed by
+	// CIDR (e.g. 127.0.0.2/32) or IP address, so several clients of one proxy can see
+	// different conditions. The most specific network containing the client is used
+	// and its faults take precedence over TargetOptions and SNIRoutes.
+	Clients map[string]ClientOptions
+
+	// AllowedClients, when set, restricts the proxy to clients in these networks and
+	// DeniedClients refuses clients in these networks. Both accept CIDRs or IP addresses.
+	AllowedClients []string
+	DeniedClients  []string
+
+	// Backups are dialed in order when the target can't be reached. Once failed over
+	// the proxy keeps using the backup and, every FailbackInterval, tries the target
+	// again and fails back if it's reachable. Proxy.Connections() records which
+	// address served each connection.
+	Backups          []string
+	FailbackInterval time.Duration
+
+	// DNSLatency, plus up to DNSJitter, is added before the proxy dials a target given
+	// as a hostname to simulate a slow resolver. IP addresses are dialed without delay.
+	DNSLatency time.Duration
+	DNSJitter  time.Duration
+
+	// DialLatency, plus up to DialJitter, is added before each dial to the target and
+	// DialFailureRatio is the percentage of dials which fail with ECONNREFUSED even
+	// though the target is healthy. Failed dials close the client's connection.
+	DialLatency      time.Duration
+	DialJitter       time.Duration
+	DialFailureRatio int
+
+	// TargetResolution controls whether target hostnames are resolved for every
+	// connection or once for the lifetime of the proxy. TargetResolver performs the
+	// lookups, defaulting to net.DefaultResolver, and OnTargetResolved is called with
+	// the IP address each connection to a target was made to.
+	TargetResolution Resolution
+	TargetResolver   HostResolver
+	OnTargetResolved func(target string, ip net.IP)
+
+	// TargetTLS, when set, makes the proxy connect to Target over TLS while clients
+	// continue to speak plaintext to the proxy. ServerName defaults to the Target's host.
+	TargetTLS *tls.Config
+
+	// CloseMode decides how the proxy ends connections because of a fault, outage or
+	// their lifetime, with either a graceful FIN (the default) or a TCP reset.
+	CloseMode CloseMode
+
+	// PreserveHost forwards the Host header of HTTP requests unchanged instead of
+	// replacing it with the target's address. Rewriting the header means reading
+	// every request, so connections are only spliced between sockets by the kernel,
+	// skipping badnet's copying, when PreserveHost is set and nothing else inspects
+	// or throttles the data.
+	PreserveHost bool
+
+	// SOCKS5 makes the proxy a SOCKS5 server. Each connection is forwarded to the
+	// destination the client requests, with faults applied, and Target is ignored.
+	SOCKS5 bool
+
+	// Transparent forwards each connection to the destination it was originally
+	// headed for before an iptables REDIRECT or TPROXY rule sent it to the proxy,
+	// so whole processes or containers can be degraded without changing their
+	// configuration. Target is ignored. It requires linux, and ForTest fails on
+	// other platforms. TPROXY rules also need CAP_NET_ADMIN to set IP_TRANSPARENT
+	// on the listeners. Without it only REDIRECTed connections are accepted and a
+	// warning is logged.
+	Transparent bool
+
+	// QUIC makes UDPForTest follow QUIC connections by the connection IDs the target
+	// chooses as well as by the client's address, so a client whose address changes,
+	// such as after a NAT rebinding, keeps its session and its connection to the
+	// target. ForTest ignores it.
+	QUIC bool
+
+	// ConnectionStorm opens extra connections to the target for some clients.
+	ConnectionStorm ConnectionStorm
+
+	// ReorderResponses sends some consecutive responses to clients out of order.
+	ReorderResponses ResponseReorder
+
+	// TargetPool reuses connections to targets between client connections. See
+	// TargetPool for how HTTP requests are forwarded over them.
+	TargetPool TargetPool
+
+	// BufferSize is the size of the buffer used to forward data in each direction,
+	// defaulting to 32KB. Small buffers split data into many reads and writes, which
+	// exaggerates partial reads, while large buffers speed up bulk transfers.
+	BufferSize int
+
+	// Toxics are custom faults applied to every connection after badnet's own.
+	Toxics []Toxic
+
+	// AdminListen optionally starts an HTTP server at this address which serves
+	// the proxy's config, connections and stats as JSON. See Proxy.AdminHandler.
+	AdminListen string
+
+	// RecordTrace writes the faults the proxy injects to this file as JSON lines,
+	// see TraceEvent and ReplayTrace for which are recorded. Attach the file to
+	// failing CI runs to reproduce them.
+	RecordTrace string
+
+	// ReplayTrace injects exactly the faults recorded by RecordTrace into the same
+	// connections and operations, instead of choosing them at random. Runs are only
+	// reproduced when the client connects and sends data in the same order.
+	//
+	// Only the failures, garbage, duplication and truncation of Read and Write,
+	// and CloseAfterAcceptRatio, are recorded. ForTest fails when other random
+	// faults are enabled alongside ReplayTrace, such as DialFailureRatio,
+	// HijackRatio, TLSHandshake, BadCertificates, ClientCertificates.RejectRatio,
+	// Tarpit, ConnectionStorm, ReorderResponses, Spikes.Ratio, Datagrams, a
+	// TargetResolver with faults, RedisError or AMQPDropHeartbeats. Jitter and
+	// load balancing across Targets stay random.
+	ReplayTrace string
+
+	// StatsReport writes a summary of the proxy's connections, faults, bytes
+	// forwarded, latency percentiles and Bursts to this file when it closes, so
+	// CI can archive and compare runs. Files ending in .csv are written as
+	// name,value rows and others as JSON.
+	StatsReport string
+
+	// ClientKeepAlive and TargetKeepAlive control TCP keep-alives on the connections
+	// accepted from clients and those made to targets, including by a Dialer.
+	// Disabling them lets half-open connections and blackholes go unnoticed, as
+	// they would behind many NATs.
+	ClientKeepAlive KeepAlive
+	TargetKeepAlive KeepAlive
+
+	// ClientNagle and TargetNagle turn on Nagle's algorithm for connections from
+	// clients and to targets by clearing TCP_NODELAY, which Go sets by default.
+	ClientNagle bool
+	TargetNagle bool
+
+	// Clock is used for latency, jitter, bandwidth limits, trickling, bursts,
+	// IdleTimeout, MaxConnectionAge, FailbackInterval and the waits of toxics,
+	// defaulting to the system clock. See FakeClock.
+	Clock Clock
+
+	// IgnoreEnv stops BADNET_ environment variables from overriding Read and Write.
+	// See ApplyEnv.
+	IgnoreEnv bool
+
+	// VerifyNoLeaks fails the test when any of the proxy's goroutines are still
+	// running shortly after it's closed. Open connections are always closed and
+	// waited on, this reports when that doesn't finish.
+	VerifyNoLeaks bool
+
+	// Metrics optionally receives counters and latency observations as
+	// connections are proxied. See MetricsSink for the available adapters.
+	Metrics MetricsSink
 }
 
 func (c Config) targetAddress() string {
-	host := c.Target
+	return targetAddress(c.Target, c.TargetTLS != nil)
+}
+
+// targetAddress returns the host:port to dial for a target, which can be a URL.
+// Unix socket targets (unix:///path/to.sock) are returned unchanged.
+func targetAddress(target string, useTLS bool) string {
+	if _, ok := unixSocketPath(target); ok {
+		return target
+	}
+
+	host := target
 
 	u, _ := url.Parse(host)
 	if u != nil && u.Host != "" {
 		host = u.Host
 	} else {
-		host = c.Target
+		host = target
 	}
 
 	host, port, _ := net.SplitHostPort(host)
@@ -43,103 +342,732 @@ func (c Config) targetAddress() string {
 		if u != nil && u.Host != "" {
 			host = u.Host
 		} else {
-			host = c.Target
+			host = target
 		}
+		// Bracketed IPv6 literals without a port, e.g. [::1]
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
 	}
 	if port == "" {
 		port = "80"
+		if useTLS {
+			port = "443"
+		}
 	}
-	return host + ":" + port
+	return net.JoinHostPort(host, port)
 }
 
 type Direction struct {
 	MaxKBps      int // set 0 for unlimited
 	Latency      time.Duration
 	FailureRatio int
+
+	// LatencyPerKB adds latency in proportion to the size of each read or write,
+	// on top of Latency, so large transfers take longer than small ones.
+	LatencyPerKB time.Duration
+
+	// Spikes add occasional large delays on top of Latency.
+	Spikes Spikes
+
+	// Bandwidth limits the direction in bits per second, such as 2*badnet.Mbit, and
+	// is used instead of MaxKBps when set. See ParseRate for reading "512kbit".
+	Bandwidth Rate
+
+	// Coalesce holds back small reads or writes to send them together.
+	Coalesce Coalesce
+
+	// Ramp gradually changes the bandwidth over time, replacing MaxKBps and Bandwidth
+	// while it's running.
+	Ramp Ramp
+
+	// ShareBandwidth makes the bandwidth a budget shared by every connection through
+	// the proxy or Dialer, like a constrained uplink, instead of a limit on each one.
+	ShareBandwidth bool
+
+	// BandwidthBurst is how many bytes can be sent at once before the bandwidth limit
+	// applies, defaulting to 100ms worth of data.
+	BandwidthBurst int
+
+	// FailureProbability is the chance, between 0 and 1, of each read or write failing
+	// and allows finer ratios than FailureRatio, e.g. 0.005 for 0.5%. The higher of
+	// the two is used.
+	FailureProbability float64
+
+	// FailureBasisPoints is the chance of each read or write failing in hundredths
+	// of a percent, for the very low loss rates of soak and load tests, e.g. 5 for
+	// 0.05%. The highest of FailureRatio, FailureProbability and FailureBasisPoints
+	// is used.
+	FailureBasisPoints int
+
+	// FailAfterBytes holds off failures until this many bytes have been
+	// transferred in this direction, so each connection starts out healthy
+	// and only fails mid-transfer.
+	FailAfterBytes int64
+
+	// FailEveryNth deterministically fails every Nth read or write on each
+	// connection, in addition to any failures from FailureRatio.
+	FailEveryNth int
+
+	// FailureMode controls whether FailureRatio, FailureProbability and
+	// FailureBasisPoints are applied to each read or write, or once to decide
+	// if a connection fails entirely.
+	FailureMode FailureMode
+
+	// Error is returned from reads and writes which fail, such as syscall.ECONNRESET
+	// or os.ErrDeadlineExceeded, and ErrorFactory, when set, creates a new error for
+	// each failure instead. By default reads fail with io.ErrUnexpectedEOF and
+	// writes with io.ErrShortWrite or io.ErrUnexpectedEOF.
+	Error        error
+	ErrorFactory func() error
+
+	// TruncateRatio is the percentage of reads and writes which silently lose the
+	// second half of their data while reporting success, like a buggy middlebox.
+	TruncateRatio int
+
+	// Garbage injects extra bytes into the stream.
+	Garbage Garbage
+
+	// DuplicateRatio is the percentage of reads and writes which are followed by a
+	// repeat of their last DuplicateBytes (default 16), like a retransmission leaking
+	// through a broken middlebox.
+	DuplicateRatio int
+	DuplicateBytes int
+
+	// Trickle forwards data a few bytes at a time with a pause between each.
+	Trickle Trickle
+
+	// Squeeze reads or writes slower and slower over each connection's life to
+	// build up backpressure on the sender.
+	Squeeze Squeeze
+
+	// MTU limits how many bytes are moved by each read or write of the underlying
+	// connection, like a path with a small maximum segment size. For UDP it's only
+	// used by Datagrams.DropOversized.
+	MTU int
+
+	// Datagrams are faults which only apply to UDP.
+	Datagrams Datagrams
+
+	// Toxics are custom faults applied to only this direction, after badnet's own
+	// and before Config.Toxics. Reads or writes in the other direction bypass them.
+	Toxics []Toxic
+}
+
+// defaultDuplicateBytes is how much of each write DuplicateRatio repeats by default.
+const defaultDuplicateBytes = 16
+
+// duplicate returns the tail of data which is repeated.
+func (d Direction) duplicate(data []byte) []byte {
+	size := d.DuplicateBytes
+	if size <= 0 {
+		size = defaultDuplicateBytes
+	}
+	if size > len(data) {
+		size = len(data)
+	}
+	return data[len(data)-size:]
+}
+
+// injectedError returns the error for a failed operation, or fallback by default.
+func (d Direction) injectedError(fallback error) error {
+	switch {
+	case d.ErrorFactory != nil:
+		return d.ErrorFactory()
+	case d.Error != nil:
+		return d.Error
+	}
+	return fallback
+}
+
+// FailureMode controls how a Direction's failure ratio is applied.
+type FailureMode int
+
+const (
+	// FailPerOperation decides if each read or write fails independently.
+	FailPerOperation FailureMode = iota
+
+	// FailPerConnection decides once, when the connection is accepted or dialed,
+	// and then every read or write on a failing connection fails.
+	FailPerConnection
+)
+
+func (m FailureMode) String() string {
+	switch m {
+	case FailPerOperation:
+		return "per-operation"
+	case FailPerConnection:
+		return "per-connection"
+	}
+	return "unknown"
+}
+
+func (d Direction) failureProbability() float64 {
+	return max(float64(d.FailureRatio)/100, float64(d.FailureBasisPoints)/10_000, d.FailureProbability)
 }
 
 type Proxy struct {
 	conf Config
 
-	bindAddr string
+	// original is the Config given to ForTest, before the environment and
+	// defaults were applied.
+	original Config
+
+	bindAddrs []string
+	started   time.Time
+	certs     *testCertificates
+	tlsConfig *tls.Config
+	bursts    *bursts
+	balancer  *balancer
+	dialer    *targetDialer
+	failover  *failover
+	limit     *connectionLimit
+	rate      *acceptRate
+	buffers   *sync.Pool
+
+	// throttles apply to connections using the Config's Read and Write, while
+	// routeThrottles hold the links for TargetOptions and SNIRoutes.
+	throttles
+	routeThrottles map[string]throttles
+
+	clients []clientPolicy
+	filter  *clientFilter
+
+	connsMu sync.Mutex
+	conns   []ConnectionInfo
+
+	// active counts connections which have been accepted and not yet closed
+	active atomic.Int32
+
+	teardown   *teardown
+	throughput *throughput
+
+	adminAddr string
+
+	trace *tracer
+
+	// offline holds recorded responses for OfflineFallback
+	offline *offlineResponses
+
+	// toggles holds the toxics switched off with DisableToxic
+	toggles *toxicToggles
+
+	// requests holds how many requests FailNextRequests has left to fail
+	requests *requestBudget
+
+	// pool holds connections to targets for TargetPool
+	pool *targetPool
+
+	// swapped is the target new connections are sent to after SwapTarget
+	swapped atomic.Pointer[string]
+
+	// id prefixes each connection's ID, and logf logs connections for LogConnections
+	id   string
+	logf func(format string, args ...interface{})
+
+	stats
+}
+
+// stats holds the counters shared by each end of badnet's connections.
+type stats struct {
+	metrics MetricsSink
 
 	// various statistics
-	connectionCount atomic.Uint32
-	readFailures    atomic.Uint32
-	writeFailures   atomic.Uint32
-	targetFailures  atomic.Uint32
+	connectionCount    atomic.Uint32
+	readFailures       atomic.Uint32
+	writeFailures      atomic.Uint32
+	targetFailures     atomic.Uint32
+	outages            atomic.Uint32
+	handshakeFails     atomic.Uint32
+	badCertificates    atomic.Uint32
+	clientCertFails    atomic.Uint32
+	failovers          atomic.Uint32
+	refused            atomic.Uint32
+	truncations        atomic.Uint32
+	garbage            atomic.Uint32
+	duplications       atomic.Uint32
+	idleTimeouts       atomic.Uint32
+	expirations        atomic.Uint32
+	byteLimits         atomic.Uint32
+	failedRequests     atomic.Uint32
+	reorderedResponses atomic.Uint32
+	earlyCloses        atomic.Uint32
+	tarpits            atomic.Uint32
+
+	// connections pooled by TargetPool which were dialed, and reused for a request
+	poolDials  atomic.Uint32
+	poolReuses atomic.Uint32
+
+	// extra connections opened to targets by ConnectionStorm
+	stormConnections atomic.Uint32
+
+	// bytes forwarded from clients to targets, and from targets back to clients
+	readBytes  atomic.Uint64
+	writeBytes atomic.Uint64
+
+	// datagrams dropped, duplicated and reordered by Direction.Datagrams
+	droppedDatagrams    atomic.Uint32
+	duplicatedDatagrams atomic.Uint32
+	reorderedDatagrams  atomic.Uint32
+
+	// hijacks counts connections sent to the HijackTarget
+	hijacks atomic.Uint32
+
+	// offlineResponses counts responses served by OfflineFallback
+	offlineResponses atomic.Uint32
+
+	// injectedDelay holds how long operations were held up by latency, bandwidth
+	// limits and trickling, while targetDial holds how long targets took to connect.
+	injectedDelay histogram
+	targetDial    histogram
 }
 
-func ForTest(t *testing.T, conf Config) *Proxy {
+// ForTest starts a Proxy which is closed once t completes. t can be the *testing.B
+// or *testing.F of a benchmark or fuzz test.
+func ForTest(t testing.TB, conf Config) *Proxy {
 	t.Helper()
 
+	original := conf
+	if conf.TargetProxy != nil {
+		conf.Target = conf.TargetProxy.BindAddr()
+		conf.PreserveHost = true
+	}
+	conf = conf.Network.apply(conf, false)
+	if !conf.IgnoreEnv {
+		var err error
+		conf, err = ApplyEnv(conf)
+		if err != nil {
+			t.Fatalf("badnet: %v", err)
+		}
+	}
+
+	if conf.Transparent {
+		if err := transparentSupported(); err != nil {
+			t.Fatalf("badnet: %v", err)
+		}
+	}
+	if conf.ValidateTarget {
+		if err := validateTargets(conf); err != nil {
+			t.Fatalf("badnet: %v", err)
+		}
+	}
+
 	p := &Proxy{
-		conf: conf,
+		conf:       conf,
+		original:   original,
+		started:    conf.clock().Now(),
+		id:         newProxyID(),
+		toggles:    &toxicToggles{},
+		requests:   &requestBudget{},
+		bursts:     newBursts(conf.Bursts, conf.clock()),
+		teardown:   newTeardown(),
+		throughput: newThroughput(),
+	}
+	p.balancer = newBalancer(conf)
+	p.dialer = newTargetDialer(conf)
+	p.failover = newFailover(conf, p.dialer)
+	p.limit = newConnectionLimit(conf)
+	p.rate = newAcceptRate(conf)
+	p.throttles = newThrottles(conf)
+	p.buffers = newBufferPool(conf.BufferSize)
+	p.routeThrottles = newRouteThrottles(conf)
+	p.metrics = conf.Metrics
+	p.offline = newOfflineResponses(conf)
+	if conf.LogConnections {
+		p.logf = t.Logf
 	}
 	var err error
 
-	// Setup listener
-	ln, err := newListener(p.conf)
+	p.clients, err = parseClients(conf.Clients)
+	if err == nil {
+		p.filter, err = newClientFilter(conf)
+	}
+	if err == nil {
+		p.trace, err = newTracer(conf)
+	}
+	if err == nil {
+		p.pool, err = newTargetPool(conf)
+	}
+	if err != nil {
+		t.Fatalf("badnet: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := p.trace.close(); err != nil {
+			t.Errorf("badnet: %v", err)
+		}
+	})
+
+	// Setup listeners
+	listeners, err := newListeners(p.conf, t.Logf)
 	if err != nil {
 		t.Fatalf("badnet listen failed: %v", err)
 	}
-	p.bindAddr = ln.Addr().String()
-
-	// Cycle through connections to proxy traffic
-	ctx, cancelFunc := context.WithCancel(context.Background())
-
-	t.Cleanup(func() { ln.Close() })
-	t.Cleanup(func() { cancelFunc() })
-
-	go func(ctx context.Context, ln net.Listener) { //nolint:staticcheck
-		for {
-			// Block while waiting for a connection
-			connCh := make(chan net.Conn)
-			go func() { //nolint:staticcheck
-				conn, err := ln.Accept()
-				if err != nil {
-					if !errors.Is(err, net.ErrClosed) {
-						t.Fatalf("badnet listener accept error: %v", err) //nolint:govet,staticcheck
-					}
-					return
-				}
-				p.connectionCount.Add(1)
-				connCh <- conn
-			}()
-
-			select {
-			case <-ctx.Done():
-				close(connCh)
-				return
-
-			case conn := <-connCh:
-				// Connect to the target
-				target, err := net.Dial("tcp", p.conf.targetAddress())
-				if err != nil {
-					p.targetFailures.Add(1)
-					t.Fatalf("connecting to %s failed: %v", p.conf.targetAddress(), err) //nolint:govet,staticcheck
-					return
+	closeListeners := func() {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+	}
+	for _, ln := range listeners {
+		p.bindAddrs = append(p.bindAddrs, ln.Addr().String())
+	}
+
+	if conf.ListenTLS {
+		var hosts []string
+		for _, addr := range append([]string{conf.Listen}, conf.ListenAddrs...) {
+			for _, host := range listenHosts(addr) {
+				if !slices.Contains(hosts, host) {
+					hosts = append(hosts, host)
 				}
+			}
+		}
+		for serverName := range conf.SNIRoutes {
+			hosts = append(hosts, serverName)
+		}
+		p.certs, err = newTestCertificates(hosts...)
+		if err == nil && conf.BadCertificates.enabled() {
+			err = p.certs.addBadCertificates(hosts)
+		}
+		if err != nil {
+			closeListeners()
+			t.Fatalf("badnet generating certificates failed: %v", err)
+		}
+		p.tlsConfig = p.certs.serverConfig(conf, &p.stats)
+	}
+
+	if conf.AdminListen != "" {
+		stopAdmin, err := p.startAdmin(conf.AdminListen)
+		if err != nil {
+			closeListeners()
+			t.Fatalf("badnet: %v", err)
+		}
+		t.Cleanup(stopAdmin)
+	}
+
+	// Cycle through connections to proxy traffic. Canceling the proxy's context
+	// stops it accepting connections and closes those which are open.
+	parent := conf.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancelFunc := context.WithCancel(parent)
+	context.AfterFunc(ctx, closeListeners)
 
-				// pipe between the listener and target in both directions
-				errCh := make(chan error, 1)
-				go pipe(errCh, conn, target, &p.readFailures)
-				go pipe(errCh, target, conn, &p.writeFailures)
-				<-errCh
+	t.Cleanup(func() {
+		cancelFunc()
+		closeListeners()
 
-				// Cleanup after ourselves
-				target.Close()
-				conn.Close()
-				close(connCh)
+		running := p.teardown.close(teardownTimeout)
+		p.pool.close()
+		if running > 0 && conf.VerifyNoLeaks {
+			t.Errorf("badnet: %d goroutines still running %v after the proxy closed", running, teardownTimeout)
+		}
+		if conf.StatsReport != "" {
+			if err := p.writeReport(conf.StatsReport); err != nil {
+				t.Errorf("badnet: %v", err)
 			}
 		}
-	}(ctx, ln)
+	})
+
+	for _, ln := range listeners {
+		ln := ln
+		p.teardown.goroutine(func() { p.serve(ctx, t, ln) })
+	}
 
 	return p
 }
 
+func (p *Proxy) serve(ctx context.Context, t testing.TB, ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+				t.Errorf("badnet listener accept error: %v", err)
+			}
+			return
+		}
+		p.active.Add(1)
+		count := p.connectionCount.Add(1)
+		p.incCounter(MetricConnections)
+
+		if !p.teardown.track(conn) {
+			p.active.Add(-1)
+			return
+		}
+		if !p.admit(ctx, conn, count) {
+			p.terminate(conn)
+			p.teardown.untrack(conn)
+			p.active.Add(-1)
+			continue
+		}
+
+		p.teardown.goroutine(func() {
+			defer p.active.Add(-1)
+			defer p.teardown.untrack(conn)
+			defer p.limit.release()
+			p.handle(ctx, conn, count)
+		})
+	}
+}
+
+// admit decides if an accepted connection is proxied, recording why it isn't.
+func (p *Proxy) admit(ctx context.Context, conn net.Conn, count uint32) bool {
+	if p.bursts.connectionAccepted(count) {
+		p.terminate(p.teardown.open()...)
+	}
+	if p.bursts.outage() {
+		p.outages.Add(1)
+		p.incCounter(MetricOutages)
+		return false
+	}
+	if !p.filter.allow(conn.RemoteAddr()) || p.conf.refuseConnection(count) || !p.rate.allow(ctx) {
+		p.connectionRefused()
+		return false
+	}
+	if p.closeAfterAccept(count) {
+		p.closedEarly()
+		return false
+	}
+	if !p.limit.acquire(ctx) {
+		p.connectionRefused()
+		return false
+	}
+	return true
+}
+
+// closeAfterAccept decides if connection number count is closed right away.
+func (p *Proxy) closeAfterAccept(count uint32) bool {
+	event := TraceEvent{Connection: count, Fault: faultClose}
+	if p.trace.replaying() {
+		_, ok := p.trace.replayed(event)
+		return ok
+	}
+	if !shouldFail(p.conf.CloseAfterAcceptRatio) {
+		return false
+	}
+	p.trace.record(event)
+	return true
+}
+
+func (p *Proxy) handle(ctx context.Context, raw net.Conn, number uint32) {
+	defer raw.Close()
+
+	// Canceling the connection's context closes it wherever it's up to
+	ctx = context.WithValue(ctx, connectionIDKey{}, p.connectionID(number))
+	if p.conf.ConnContext != nil {
+		ctx = p.conf.ConnContext(ctx, raw)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { p.terminate(raw) })
+	defer stop()
+
+	p.conf.ClientKeepAlive.apply(raw)
+	setNagle(raw, p.conf.ClientNagle)
+
+	if p.conf.ConnectDelay > 0 || p.conf.ConnectJitter > 0 {
+		wait := withJitter(p.conf.ConnectDelay, p.conf.ConnectJitter)
+		select {
+		case <-p.conf.clock().After(wait):
+			p.injectedDelay.observe(wait)
+		case <-ctx.Done():
+			return
+		case <-p.teardown.closing():
+			return
+		}
+	}
+
+	if p.conf.Tarpit.chosen() {
+		p.tarpit(ctx, raw)
+		return
+	}
+
+	// SOCKS5 clients choose their own destination, while transparent connections
+	// keep the one they were redirected from
+	var destination string
+	switch {
+	case p.conf.SOCKS5:
+		var err error
+		destination, err = readSOCKS5Request(raw)
+		if err != nil {
+			return
+		}
+
+	case p.conf.Transparent:
+		var err error
+		destination, err = p.originalDestination(raw)
+		if err != nil {
+			p.targetFailures.Add(1)
+			p.incCounter(MetricTargetFailures)
+			return
+		}
+	}
+
+	conn, rt, err := p.accept(ctx, raw, number, destination)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	if p.pool != nil && destination == "" {
+		p.pooled(ctx, conn, rt, number)
+		return
+	}
+
+	// Connect to the target
+	start := time.Now()
+	var target net.Conn
+	var servedBy string
+	if destination != "" || rt.hijacked {
+		target, err = p.dialer.dial(ctx, rt.target, rt.targetTLS)
+		servedBy = rt.target
+	} else {
+		target, servedBy, err = p.failover.dial(ctx, rt, p.failedOver)
+	}
+	if err != nil {
+		servedBy = ""
+	}
+	p.recordConnection(ctx, raw, ConnectionInfo{Number: number, Target: servedBy, Hijacked: rt.hijacked})
+
+	if p.conf.SOCKS5 {
+		if p.offline != nil {
+			// Recorded responses stand in for the target
+			writeSOCKS5Dial(raw, nil)
+		} else {
+			writeSOCKS5Dial(raw, err)
+		}
+	}
+	if err != nil {
+		p.targetFailures.Add(1)
+		p.incCounter(MetricTargetFailures)
+		if p.offline != nil {
+			buf := p.buffers.Get().(*[]byte)
+			p.offline.serve(conn, *buf, p.servedOffline)
+			p.buffers.Put(buf)
+		}
+		return
+	}
+	if !p.teardown.track(target) {
+		return
+	}
+	defer p.teardown.untrack(target)
+	defer target.Close()
+	p.targetDialed(time.Since(start))
+
+	p.conf.TargetKeepAlive.apply(target)
+	setNagle(target, p.conf.TargetNagle)
+
+	if p.conf.ConnectionStorm.Ratio > 0 {
+		target = p.storm(ctx, target, rt)
+		defer target.Close()
+	}
+
+	// Callbacks close the connections as they are now, as conn and target are
+	// wrapped below while they may be running
+	client, server := conn, target
+	stopForwarding := context.AfterFunc(ctx, func() { p.terminate(client, server) })
+	defer stopForwarding()
+
+	conn, target, stopLimits := p.limitLifetime(conn, target)
+	defer stopLimits()
+
+	// pipe between the listener and target in both directions
+	errCh := make(chan error, 2)
+	if conn == raw {
+		p.teardown.goroutine(func() { splice(errCh, conn, target, p.forwardedWrite) })
+		p.teardown.goroutine(func() { splice(errCh, target, conn, p.forwardedRead) })
+	} else {
+		p.teardown.goroutine(func() { pipe(errCh, conn, target, p.buffers, p.forwardedWrite) })
+		p.teardown.goroutine(func() { pipe(errCh, target, conn, p.buffers, p.forwardedRead) })
+	}
+
+	// Connections are closed once both directions finish, or either one fails
+	for i := 0; i < 2; i++ {
+		if err := <-errCh; err != nil {
+			p.terminate(conn, target)
+			break
+		}
+	}
+
+	p.observeLatency(MetricConnectionDuration, time.Since(start))
+}
+
+// accept prepares a client connection for proxying by completing any TLS handshake,
+// choosing where it's routed and applying that route's throttling and faults.
+//
+// A non-empty destination replaces the configured target.
+func (p *Proxy) accept(ctx context.Context, raw net.Conn, number uint32, destination string) (net.Conn, route, error) {
+	var c net.Conn = &handshakeConn{
+		Conn:     raw,
+		ctx:      ctx,
+		clock:    p.conf.clock(),
+		faults:   p.conf.TLSHandshake,
+		onFailed: p.handshakeFailed,
+	}
+
+	var serverName string
+	switch {
+	case p.tlsConfig != nil:
+		tc := tls.Server(c, p.tlsConfig)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			return nil, route{}, fmt.Errorf("TLS handshake: %w", err)
+		}
+		serverName = tc.ConnectionState().ServerName
+		c = tc
+
+	case len(p.conf.SNIRoutes) > 0:
+		serverName, c = peekServerName(c)
+	}
+	rt := p.route(serverName, raw.RemoteAddr())
+	if destination != "" {
+		rt.target = destination
+		rt.rewriteHost = false
+	}
+	if p.conf.HijackTarget != "" && shouldFail(p.conf.HijackRatio) {
+		rt.target = targetAddress(p.conf.HijackTarget, rt.targetTLS != nil)
+		rt.hijacked = true
+		p.hijacked()
+	}
+	if p.fastPath(rt) {
+		return raw, rt, nil
+	}
+
+	info := ToxicInfo{
+		Context:    ctx,
+		ID:         ConnectionID(ctx),
+		Number:     number,
+		RemoteAddr: raw.RemoteAddr(),
+		Target:     rt.target,
+		Clock:      p.conf.clock(),
+	}
+	toxics := append([]Toxic{
+		hostHeaderToxic(rt.hostHeader()),
+		p.requestFaultToxic(),
+		connectionIDToxic(p.conf.ConnectionIDHeader),
+		p.toggles.toggle(ToxicMTU, mtuToxic(rt.read, rt.write)),
+		p.toggles.toggle(ToxicCoalesce, coalesceToxic(rt.read, rt.write)),
+		p.toggles.toggle(ToxicThrottle, p.bandwidthToxic(rt.readLink, rt.writeLink, p.conf.clock(), p.teardown.closing())),
+		p.toggles.toggle(ToxicTrickle, p.trickleToxic(rt.read, rt.write, p.conf.clock())),
+		p.toggles.toggle(ToxicSqueeze, p.squeezeToxic(rt.read, rt.write, p.conf.clock())),
+		p.faultToxic(rt.read, rt.write, p.bursts.failureRatio, p.trace, p.toggles),
+		p.toggles.toggle(ToxicReorder, p.reorderToxic(p.conf.ReorderResponses)),
+	}, directionToxics(rt.read, rt.write, p.toggles)...)
+	for _, toxic := range p.conf.Toxics {
+		toxics = append(toxics, p.toggles.toggle("", toxic))
+	}
+	c = applyToxics(c, info, toxics...)
+
+	if p.offline != nil {
+		c = &responseRecorder{Conn: c, responses: p.offline}
+	}
+	return c, rt, nil
+}
+
 func (p *Proxy) BindAddr() string {
-	return p.bindAddr
+	return p.bindAddrs[0]
+}
+
+// BindAddrs returns every address the proxy is listening on, starting with
+// BindAddr and followed by those bound for Config.ListenAddrs.
+func (p *Proxy) BindAddrs() []string {
+	return slices.Clone(p.bindAddrs)
 }
 
 func (p *Proxy) Port() int {
@@ -156,157 +1084,476 @@ func (p *Proxy) Port() int {
 
 // FailureRatio is a ratio of the injected failures and failures to connect with the target
 // against the overall number of connections made to the proxy.
-func (p *Proxy) FailureRatio() float64 {
-	connections := float64(p.connectionCount.Load())
-	failures := float64(p.readFailures.Load() + p.writeFailures.Load() + p.targetFailures.Load())
-	return failures / connections
+func (s *stats) FailureRatio() float64 {
+	snapshot := s.Stats()
+	return float64(snapshot.Failures()) / float64(snapshot.Connections)
+}
+
+func (s *stats) targetDialed(d time.Duration) {
+	s.targetDial.observe(d)
+	s.observeLatency(MetricTargetDial, d)
+}
+
+func (s *stats) readFailed() {
+	s.readFailures.Add(1)
+	s.incCounter(MetricReadFailures)
+}
+
+func (s *stats) writeFailed() {
+	s.writeFailures.Add(1)
+	s.incCounter(MetricWriteFailures)
+}
+
+func (s *stats) handshakeFailed() {
+	s.handshakeFails.Add(1)
+	s.incCounter(MetricHandshakeFailures)
+}
+
+func (s *stats) badCertificatePresented() {
+	s.badCertificates.Add(1)
+	s.incCounter(MetricBadCertificates)
+}
+
+func (s *stats) failedOver() {
+	s.failovers.Add(1)
+	s.incCounter(MetricFailovers)
+}
+
+func (s *stats) connectionRefused() {
+	s.refused.Add(1)
+	s.incCounter(MetricRefusedConnections)
+}
+
+func (s *stats) truncated() {
+	s.truncations.Add(1)
+	s.incCounter(MetricTruncations)
+}
+
+func (s *stats) garbageInjected() {
+	s.garbage.Add(1)
+	s.incCounter(MetricGarbageInjections)
+}
+
+func (s *stats) duplicated() {
+	s.duplications.Add(1)
+	s.incCounter(MetricDuplications)
+}
+
+func (s *stats) idleTimedOut() {
+	s.idleTimeouts.Add(1)
+	s.incCounter(MetricIdleTimeouts)
+}
+
+func (s *stats) connectionExpired() {
+	s.expirations.Add(1)
+	s.incCounter(MetricConnectionExpirations)
+}
+
+func (s *stats) byteLimitReached() {
+	s.byteLimits.Add(1)
+	s.incCounter(MetricByteLimits)
+}
+
+func (s *stats) requestFailed() {
+	s.failedRequests.Add(1)
+	s.incCounter(MetricFailedRequests)
+}
+
+func (s *stats) poolDialed() {
+	s.poolDials.Add(1)
+	s.incCounter(MetricPoolDials)
+}
+
+func (s *stats) poolReused() {
+	s.poolReuses.Add(1)
+	s.incCounter(MetricPoolReuses)
+}
+
+func (s *stats) tarpitted() {
+	s.tarpits.Add(1)
+	s.incCounter(MetricTarpits)
+}
+
+func (s *stats) responsesReordered() {
+	s.reorderedResponses.Add(1)
+	s.incCounter(MetricReorderedResponses)
+}
+
+func (s *stats) stormConnected() {
+	s.stormConnections.Add(1)
+	s.incCounter(MetricStormConnections)
+}
+
+func (s *stats) closedEarly() {
+	s.earlyCloses.Add(1)
+	s.incCounter(MetricEarlyCloses)
+}
+
+func (s *stats) datagramDropped() {
+	s.droppedDatagrams.Add(1)
+	s.incCounter(MetricDroppedDatagrams)
+}
+
+func (s *stats) datagramDuplicated() {
+	s.duplicatedDatagrams.Add(1)
+	s.incCounter(MetricDuplicatedDatagrams)
+}
+
+func (s *stats) datagramReordered() {
+	s.reorderedDatagrams.Add(1)
+	s.incCounter(MetricReorderedDatagrams)
+}
+
+func (s *stats) hijacked() {
+	s.hijacks.Add(1)
+	s.incCounter(MetricHijacks)
+}
+
+func (s *stats) servedOffline() {
+	s.offlineResponses.Add(1)
+	s.incCounter(MetricOfflineResponses)
+}
+
+func (s *stats) clientCertificateRejected() {
+	s.clientCertFails.Add(1)
+	s.incCounter(MetricClientCertificateRejections)
 }
 
 type conn struct {
 	net.Conn
 
-	targetAddress string
+	read, write Direction
+
+	// readBytes and writeBytes count the data transferred so far, used to
+	// hold off faults until FailAfterBytes has passed.
+	readBytes, writeBytes int64
+
+	// readOps and writeOps count the operations which were eligible for faults.
+	readOps, writeOps int
+
+	// pending holds read data, including injected garbage, which didn't fit in
+	// the caller's buffer.
+	pending []byte
 
-	readFailureRatio  int // 1-100%
-	writeFailureRatio int // 1-100%
+	// readFailing and writeFailing are chosen once for FailPerConnection.
+	readFailing, writeFailing bool
+
+	// failureCounted counts a connection's failures once, in the direction which
+	// failed first, as the connection ends with it. FailureRatio stays a share of
+	// the connections made.
+	failureCounted sync.Once
+
+	onReadFailure  func()
+	onWriteFailure func()
+	onTruncate     func()
+	onGarbage      func()
+	onDuplicate    func()
+
+	burstFailureRatio func() int
+
+	// number and trace record the faults injected, or replay them from a trace.
+	number uint32
+	trace  *tracer
+
+	// toggles switches off faults disabled with Proxy.DisableToxic
+	toggles *toxicToggles
+}
+
+// NetConn returns the underlying connection.
+func (c *conn) NetConn() net.Conn {
+	return c.Conn
 }
 
+// failureGranularity is how finely failure probabilities are sampled, allowing
+// ratios well below 1%.
+const failureGranularity = 1_000_000
+
 var (
-	maxChoice = big.NewInt(int64(100))
+	maxChoice = big.NewInt(failureGranularity)
 )
 
+// shouldFail reports true for ratio percent of calls.
 func shouldFail(ratio int) bool {
+	return shouldFailProbability(float64(ratio) / 100)
+}
+
+// shouldFailProbability reports true with probability p, which is between 0 and 1.
+func shouldFailProbability(p float64) bool {
+	if p <= 0 {
+		return false
+	}
 	n, _ := rand.Int(rand.Reader, maxChoice)
-	return n.Int64() <= int64(ratio)
+	return float64(n.Int64()) < p*failureGranularity
+}
+
+// randomInt returns a number in [0, max)
+func randomInt(max int) int {
+	n, _ := rand.Int(rand.Reader, big.NewInt(int64(max)))
+	return int(n.Int64())
 }
 
-func (c *conn) Read(b []byte) (n int, err error) {
-	if c.targetAddress != "" {
-		// Our target is accessed with a hostname, so if the request looks like HTTP
-		// we need to make sure that the 'Host' header has the hostname.
-		//
-		// If we send the request with an IP the server won't understand our request.
-		//
-		// TODO(adam): Implement a more generic replacement procedure.
+// withJitter returns d plus a random duration up to jitter.
+func withJitter(d, jitter time.Duration) time.Duration {
+	if jitter > 0 {
+		d += time.Duration(randomInt(int(jitter)))
+	}
+	return d
+}
 
-		// Read the HTTP request and replace the header
-		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(b)))
-		if err != nil {
-			goto read
+// failureProbability returns the direction's probability, raised by any active bursts.
+func (c *conn) failureProbability(d Direction) float64 {
+	p := d.failureProbability()
+	if c.burstFailureRatio != nil {
+		if burst := float64(c.burstFailureRatio()) / 100; burst > p {
+			return burst
 		}
+	}
+	return p
+}
+
+// shouldFail decides if an operation in the given direction fails, where op is
+// the operation's number on this connection.
+func (c *conn) shouldFail(d Direction, dir string, op int, failing bool) bool {
+	if !c.toggles.enabled(ToxicFailures) {
+		return false
+	}
+	if c.trace.replaying() {
+		_, ok := c.trace.replayed(c.event(dir, op, faultFailure))
+		return ok
+	}
+	if d.FailEveryNth > 0 && op%d.FailEveryNth == 0 {
+		return true
+	}
+	if d.FailureMode == FailPerConnection {
+		return failing
+	}
+	return shouldFailProbability(c.failureProbability(d))
+}
 
-		var beforeBuf bytes.Buffer
-		req.Write(&beforeBuf)
+// roll decides if fault happens to ratio percent of operations, or when replaying
+// a trace, if it happened to op.
+func (c *conn) roll(dir string, op int, fault string, ratio int) bool {
+	// Garbage, duplicate and truncate faults share their names with their toxics
+	if !c.toggles.enabled(fault) {
+		return false
+	}
+	if c.trace.replaying() {
+		_, ok := c.trace.replayed(c.event(dir, op, fault))
+		return ok
+	}
+	return shouldFail(ratio)
+}
 
-		// Replace the Host header with our target
-		host, port, _ := net.SplitHostPort(c.targetAddress)
-		if host != "" {
-			req.Host = host
-		}
-		if port != "" && port != "80" {
-			req.Host += fmt.Sprintf(":%s", port)
-		}
+// event describes a fault on op in the direction named dir.
+func (c *conn) event(dir string, op int, fault string) TraceEvent {
+	return TraceEvent{Connection: c.number, Direction: dir, Op: op, Fault: fault}
+}
+
+// faulted records a fault injected on op.
+func (c *conn) faulted(dir string, op int, fault string) {
+	c.trace.record(c.event(dir, op, fault))
+}
+
+// garbage returns data with g's garbage injected on op. The bytes and where they
+// go are recorded so replays can inject the same ones.
+func (c *conn) garbage(dir string, op int, g Garbage, data []byte) []byte {
+	event := c.event(dir, op, faultGarbage)
+	if recorded, ok := c.trace.replayed(event); ok && len(recorded.Garbage) > 0 {
+		return insertGarbage(data, recorded.Garbage, recorded.Offset)
+	}
+	event.Garbage, event.Offset = g.bytes(), g.offset(len(data))
+	c.trace.record(event)
+	return insertGarbage(data, event.Garbage, event.Offset)
+}
 
-		var afterBuf bytes.Buffer
-		req.Write(&afterBuf)
+// chooseFailures picks whether each direction fails for its whole lifetime
+// when FailPerConnection is used.
+func (c *conn) chooseFailures() *conn {
+	if c.read.FailureMode == FailPerConnection {
+		c.readFailing = shouldFailProbability(c.failureProbability(c.read))
+	}
+	if c.write.FailureMode == FailPerConnection {
+		c.writeFailing = shouldFailProbability(c.failureProbability(c.write))
+	}
+	return c
+}
 
-		// Replace request bytes with updated
-		// TODO(adam): We need a more performant solution...
-		b = bytes.Replace(b, beforeBuf.Bytes(), afterBuf.Bytes(), 1)
+// Read reads from the connection with the read Direction's faults applied.
+func (c *conn) Read(b []byte) (int, error) {
+	if len(c.pending) > 0 {
+		n := copy(b, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+
+	if remaining := c.read.FailAfterBytes - c.readBytes; remaining > 0 {
+		// Stop short of the threshold so the first FailAfterBytes are always clean.
+		if int64(len(b)) > remaining {
+			b = b[:remaining]
+		}
+		n, err := c.Conn.Read(b)
+		c.readBytes += int64(n)
+		return n, err
 	}
 
-read:
-	if shouldFail(c.readFailureRatio) {
+	c.readOps++
+	if c.shouldFail(c.read, "read", c.readOps, c.readFailing) {
 		partial := len(b) / 2
-		_, err := c.Conn.Read(b[:partial])
+		n, err := c.Conn.Read(b[:partial])
 		if err != nil {
-			return partial, io.ErrShortWrite
+			// The connection is already finished (e.g. EOF) so there's nothing to fail.
+			return n, err
+		}
+		c.failureCounted.Do(c.onReadFailure)
+		c.faulted("read", c.readOps, faultFailure)
+		return n, c.read.injectedError(io.ErrUnexpectedEOF)
+	}
+
+	if c.roll("read", c.readOps, faultGarbage, c.read.Garbage.Ratio) {
+		n, err := c.Conn.Read(b)
+		if n > 0 {
+			// Anything which doesn't fit is held until the next read
+			c.pending = c.garbage("read", c.readOps, c.read.Garbage, b[:n])
+			n = copy(b, c.pending)
+			c.pending = c.pending[n:]
+			c.onGarbage()
+		}
+		return n, err
+	}
+
+	if c.roll("read", c.readOps, faultDuplicate, c.read.DuplicateRatio) {
+		n, err := c.Conn.Read(b)
+		if n > 0 {
+			c.pending = append([]byte(nil), c.read.duplicate(b[:n])...)
+			c.onDuplicate()
+			c.faulted("read", c.readOps, faultDuplicate)
 		}
-		return partial, io.ErrUnexpectedEOF
+		return n, err
+	}
+
+	if c.roll("read", c.readOps, faultTruncate, c.read.TruncateRatio) {
+		// Drop the end of what was read without reporting it
+		n, err := c.Conn.Read(b)
+		if n > 1 {
+			c.onTruncate()
+			c.faulted("read", c.readOps, faultTruncate)
+			n /= 2
+		}
+		return n, err
 	}
 
 	return c.Conn.Read(b)
 }
 
 func (c *conn) Write(b []byte) (n int, err error) {
-	if shouldFail(c.writeFailureRatio) {
+	if remaining := c.write.FailAfterBytes - c.writeBytes; remaining > 0 {
+		if int64(len(b)) > remaining {
+			// Write the clean prefix, then let the rest face any faults.
+			n, err := c.Conn.Write(b[:remaining])
+			c.writeBytes += int64(n)
+			if err != nil {
+				return n, err
+			}
+			m, err := c.Write(b[n:])
+			return n + m, err
+		}
+		n, err := c.Conn.Write(b)
+		c.writeBytes += int64(n)
+		return n, err
+	}
+
+	c.writeOps++
+	if c.shouldFail(c.write, "write", c.writeOps, c.writeFailing) {
+		c.failureCounted.Do(c.onWriteFailure)
+		c.faulted("write", c.writeOps, faultFailure)
+
 		partial := len(b) / 2
-		_, err := c.Conn.Write(b[:partial])
+		n, err := c.Conn.Write(b[:partial])
 		if err != nil {
-			return partial, io.ErrShortWrite
+			return n, c.write.injectedError(io.ErrShortWrite)
 		}
-		return partial, io.ErrUnexpectedEOF
+		return n, c.write.injectedError(io.ErrUnexpectedEOF)
 	}
 
-	return c.Conn.Write(b)
-}
-
-type listener struct {
-	throttled     *throttle.Listener
-	targetAddress string
+	if c.roll("write", c.writeOps, faultGarbage, c.write.Garbage.Ratio) {
+		c.onGarbage()
+		if _, err := c.Conn.Write(c.garbage("write", c.writeOps, c.write.Garbage, b)); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
 
-	readFailureRatio  int // 1-100%
-	writeFailureRatio int // 1-100%
-}
+	if len(b) > 0 && c.roll("write", c.writeOps, faultDuplicate, c.write.DuplicateRatio) {
+		c.onDuplicate()
+		c.faulted("write", c.writeOps, faultDuplicate)
+		out := append(append([]byte(nil), b...), c.write.duplicate(b)...)
+		if _, err := c.Conn.Write(out); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
 
-func (l *listener) Accept() (net.Conn, error) {
-	c, err := l.throttled.Accept()
-	if err != nil {
-		return nil, fmt.Errorf("listener.Accept: %w", err)
+	if len(b) > 1 && c.roll("write", c.writeOps, faultTruncate, c.write.TruncateRatio) {
+		// Forward part of the data but report all of it as written
+		c.onTruncate()
+		c.faulted("write", c.writeOps, faultTruncate)
+		if _, err := c.Conn.Write(b[:len(b)/2]); err != nil {
+			return 0, err
+		}
+		return len(b), nil
 	}
-	return &conn{
-		Conn:              c,
-		targetAddress:     l.targetAddress,
-		readFailureRatio:  l.readFailureRatio,
-		writeFailureRatio: l.writeFailureRatio,
-	}, nil
-}
 
-func (l *listener) Close() error {
-	return l.throttled.Close()
+	return c.Conn.Write(b)
 }
 
-func (l *listener) Addr() net.Addr {
-	return l.throttled.Addr()
-}
+const defaultListenNetwork = "tcp"
 
-func newListener(conf Config) (net.Listener, error) {
-	ln, err := net.Listen("tcp", conf.Listen)
-	if err != nil {
-		return nil, fmt.Errorf("newListener: %w", err)
+func newListeners(conf Config, logf func(format string, args ...interface{})) ([]net.Listener, error) {
+	network := conf.ListenNetwork
+	if network == "" {
+		network = defaultListenNetwork
+	}
+	var listeners []net.Listener
+	for _, addr := range append([]string{conf.Listen}, conf.ListenAddrs...) {
+		ln, err := listen(network, addr, conf.BindRetry, conf.Transparent, logf)
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return nil, fmt.Errorf("newListener: %w", err)
+		}
+		listeners = append(listeners, ln)
 	}
+	return listeners, nil
+}
 
-	throttled := &throttle.Listener{
-		Listener: ln,
-		Down: throttle.Rate{
-			KBps:    conf.Read.MaxKBps,
-			Latency: conf.Read.Latency,
-		},
-		Up: throttle.Rate{
-			KBps:    conf.Write.MaxKBps,
-			Latency: conf.Write.Latency,
+// defaultBufferSize matches io.Copy
+const defaultBufferSize = 32 * 1024
+
+// newBufferPool returns a pool of copy buffers which are reused between connections
+// so busy proxies don't allocate a new buffer for every pipe.
+func newBufferPool(size int) *sync.Pool {
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+	return &sync.Pool{
+		New: func() any {
+			buf := make([]byte, size)
+			return &buf
 		},
 	}
-
-	return &listener{
-		throttled:         throttled,
-		targetAddress:     conf.targetAddress(),
-		readFailureRatio:  conf.Read.FailureRatio,
-		writeFailureRatio: conf.Write.FailureRatio,
-	}, nil
 }
 
-func pipe(errCh chan error, dst, src io.ReadWriter, counter *atomic.Uint32) {
-	var count sync.Once
-	for {
-		_, err := io.Copy(dst, src)
-		if err != nil {
-			if !errors.Is(err, net.ErrClosed) {
-				count.Do(func() {
-					counter.Add(1)
-				})
-			}
-		}
-		errCh <- err
+// pipe copies from src to dst and, once src is finished, half-closes dst so the
+// other direction can continue.
+func pipe(errCh chan error, dst net.Conn, src io.Reader, buffers *sync.Pool, count func(n int)) {
+	buf := buffers.Get().(*[]byte)
+	defer buffers.Put(buf)
+
+	// Hide any ReadFrom or WriteTo methods so the pooled buffer is always used
+	_, err := io.CopyBuffer(countingWriter{w: dst, count: count}, struct{ io.Reader }{src}, *buf)
+	if err == nil {
+		closeWrite(dst)
 	}
+	errCh <- err
 }
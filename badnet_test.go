@@ -1,8 +1,15 @@
 package badnet
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
 	"testing"
 	"time"
 
@@ -30,6 +37,18 @@ func TestConfig(t *testing.T) {
 
 		conf.Target = "http://example.com"
 		require.Equal(t, "example.com:80", conf.targetAddress())
+
+		conf.TargetTLS = &tls.Config{}
+		require.Equal(t, "example.com:443", conf.targetAddress())
+
+		conf.Target = "[::1]"
+		require.Equal(t, "[::1]:443", conf.targetAddress())
+
+		conf.Target = "http://[::1]:8080"
+		require.Equal(t, "[::1]:8080", conf.targetAddress())
+
+		conf.Target = "unix:///var/run/app.sock"
+		require.Equal(t, "unix:///var/run/app.sock", conf.targetAddress())
 	})
 }
 
@@ -72,8 +91,12 @@ func TestProxy(t *testing.T) {
 		address := "http://" + proxy.BindAddr()
 		t.Logf("badnet proxy address: %v", address)
 
+		// Use a new connection for each request so the ratio is measured per connection.
+		client := &http.Client{
+			Transport: &http.Transport{DisableKeepAlives: true},
+		}
 		for i := 0; i < 100; i++ {
-			resp, _ := http.DefaultClient.Get(address)
+			resp, _ := client.Get(address)
 			if resp != nil && resp.Body != nil {
 				resp.Body.Close()
 			}
@@ -83,3 +106,539 @@ func TestProxy(t *testing.T) {
 		require.InDelta(t, failureRatio, 0.5, 0.3)
 	})
 }
+
+// echoServer starts a TCP server which writes back everything it reads.
+func echoServer(t testing.TB) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func TestTargetTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("PONG"))
+	}))
+	t.Cleanup(server.Close)
+
+	transport, ok := server.Client().Transport.(*http.Transport)
+	require.True(t, ok)
+
+	proxy := ForTest(t, Config{
+		Listen:    "127.0.0.1:0",
+		Target:    server.URL,
+		TargetTLS: transport.TLSClientConfig,
+	})
+
+	resp, err := http.Get("http://" + proxy.BindAddr())
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	bs, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "PONG", string(bs))
+}
+
+func TestIPv6(t *testing.T) {
+	ln, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 is unavailable: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	for _, network := range []string{"", "tcp6"} {
+		proxy := ForTest(t, Config{
+			Listen:        "[::1]:0",
+			ListenNetwork: network,
+			Target:        ln.Addr().String(),
+		})
+
+		host, _, err := net.SplitHostPort(proxy.BindAddr())
+		require.NoError(t, err)
+		require.Equal(t, "::1", host)
+		require.Greater(t, proxy.Port(), 0)
+
+		conn, err := net.Dial("tcp6", proxy.BindAddr())
+		require.NoError(t, err)
+
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		require.Equal(t, "ping", string(buf))
+		require.NoError(t, conn.Close())
+	}
+}
+
+func TestListenAddrs(t *testing.T) {
+	addrs := []string{"127.0.0.1:0"}
+	if ln, err := net.Listen("tcp6", "[::1]:0"); err == nil {
+		ln.Close()
+		addrs = append(addrs, "[::1]:0")
+	}
+	proxy := ForTest(t, Config{
+		Listen:      "127.0.0.1:0",
+		ListenAddrs: addrs,
+		Target:      echoServer(t),
+	})
+
+	bound := proxy.BindAddrs()
+	require.Len(t, bound, len(addrs)+1)
+	require.Equal(t, proxy.BindAddr(), bound[0])
+	require.Equal(t, bound[1:], proxy.EffectiveConfig().ListenAddrs)
+
+	for _, addr := range bound {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		require.Equal(t, "ping", string(buf))
+		require.NoError(t, conn.Close())
+	}
+	require.Equal(t, uint32(len(bound)), proxy.Stats().Connections)
+}
+
+func TestFailAfterBytes(t *testing.T) {
+	proxy := ForTest(t, Config{
+		Listen: "127.0.0.1:0",
+		Target: echoServer(t),
+		Write:  Direction{FailureRatio: 100, FailAfterBytes: 1000},
+	})
+
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	payload := bytes.Repeat([]byte("a"), 4000)
+	go conn.Write(payload)
+
+	// Everything up to FailAfterBytes arrives, then the connection fails.
+	bs, _ := io.ReadAll(conn)
+	require.GreaterOrEqual(t, len(bs), 1000)
+	require.Less(t, len(bs), len(payload))
+	require.Equal(t, payload[:len(bs)], bs)
+	require.Equal(t, uint32(0), proxy.readFailures.Load())
+	require.Greater(t, proxy.writeFailures.Load(), uint32(0))
+}
+
+func TestFailEveryNth(t *testing.T) {
+	proxy := ForTest(t, Config{
+		Listen: "127.0.0.1:0",
+		Target: echoServer(t),
+		Write:  Direction{FailEveryNth: 3},
+	})
+
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	buf := make([]byte, 4)
+	for i := 1; i <= 2; i++ {
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		require.Equal(t, "ping", string(buf))
+	}
+
+	// The third write back to the client fails
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, buf)
+	require.Error(t, err)
+	require.Equal(t, uint32(1), proxy.writeFailures.Load())
+}
+
+func TestShouldFailProbability(t *testing.T) {
+	require.False(t, shouldFailProbability(0))
+	require.True(t, shouldFailProbability(1))
+
+	var failures int
+	for i := 0; i < 200_000; i++ {
+		if shouldFailProbability(0.005) {
+			failures++
+		}
+	}
+	require.InDelta(t, 1000, failures, 250)
+
+	require.InDelta(t, 0.25, Direction{FailureRatio: 25, FailureProbability: 0.005}.failureProbability(), 0.0001)
+	require.InDelta(t, 0.005, Direction{FailureProbability: 0.005}.failureProbability(), 0.0001)
+	require.InDelta(t, 0.0005, Direction{FailureBasisPoints: 5}.failureProbability(), 0.00001)
+	require.InDelta(t, 0.01, Direction{FailureRatio: 1, FailureBasisPoints: 5}.failureProbability(), 0.00001)
+}
+
+func TestFailPerConnection(t *testing.T) {
+	dialer := DialerForTest(t, Config{
+		Target: echoServer(t),
+		Write:  Direction{FailureRatio: 50, FailureMode: FailPerConnection},
+	})
+
+	var failed int
+	for i := 0; i < 40; i++ {
+		conn, err := dialer.Dial(context.Background())
+		require.NoError(t, err)
+
+		// Every write on a connection shares the same fate
+		_, first := conn.Write([]byte("ping"))
+		for j := 0; j < 5; j++ {
+			_, err := conn.Write([]byte("ping"))
+			require.Equal(t, first == nil, err == nil)
+		}
+		if first != nil {
+			failed++
+		}
+		conn.Close()
+	}
+	require.Greater(t, failed, 0)
+	require.Less(t, failed, 40)
+	require.Equal(t, "per-connection", FailPerConnection.String())
+}
+
+func TestFailureRatioPerConnection(t *testing.T) {
+	dialer := DialerForTest(t, Config{
+		Target: echoServer(t),
+		Read:   Direction{FailureRatio: 100},
+		Write:  Direction{FailureRatio: 100},
+	})
+
+	for i := 0; i < 5; i++ {
+		conn, err := dialer.Dial(context.Background())
+		require.NoError(t, err)
+
+		// Failing repeatedly, and both ways, counts the connection once
+		for j := 0; j < 3; j++ {
+			_, err := conn.Write([]byte("ping"))
+			require.Error(t, err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		conn.Read(make([]byte, 4))
+		conn.Close()
+	}
+	require.Equal(t, uint32(5), dialer.Stats().WriteFailures)
+	require.Zero(t, dialer.Stats().ReadFailures)
+	require.Equal(t, 1.0, dialer.FailureRatio())
+}
+
+func TestInjectedErrors(t *testing.T) {
+	target := echoServer(t)
+
+	t.Run("Error", func(t *testing.T) {
+		dialer := DialerForTest(t, Config{
+			Target: target,
+			Write:  Direction{FailureRatio: 100, Error: syscall.ECONNRESET},
+		})
+		conn, err := dialer.Dial(context.Background())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("ping"))
+		require.ErrorIs(t, err, syscall.ECONNRESET)
+	})
+
+	t.Run("ErrorFactory", func(t *testing.T) {
+		var calls int
+		dialer := DialerForTest(t, Config{
+			Target: target,
+			Write: Direction{
+				FailureRatio: 100,
+				Error:        syscall.ECONNRESET,
+				ErrorFactory: func() error {
+					calls++
+					return os.ErrDeadlineExceeded
+				},
+			},
+		})
+		conn, err := dialer.Dial(context.Background())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("ping"))
+		require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("default", func(t *testing.T) {
+		dialer := DialerForTest(t, Config{
+			Target: target,
+			Write:  Direction{FailureRatio: 100},
+		})
+		conn, err := dialer.Dial(context.Background())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("ping"))
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+}
+
+func TestTruncation(t *testing.T) {
+	dialer := DialerForTest(t, Config{
+		Target: echoServer(t),
+		Write:  Direction{TruncateRatio: 100},
+	})
+	conn, err := dialer.Dial(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	n, err := conn.Write([]byte("pingpong"))
+	require.NoError(t, err)
+	require.Equal(t, 8, n)
+
+	// Only the first half reaches the server
+	conn.SetReadDeadline(time.Now().Add(250 * time.Millisecond))
+	bs, err := io.ReadAll(conn)
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	require.Equal(t, "ping", string(bs))
+	require.Equal(t, uint32(1), dialer.truncations.Load())
+}
+
+func TestDuplication(t *testing.T) {
+	dialer := DialerForTest(t, Config{
+		Target: echoServer(t),
+		Write:  Direction{DuplicateRatio: 100, DuplicateBytes: 2},
+	})
+	conn, err := dialer.Dial(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	n, err := conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	require.Equal(t, 4, n)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 6)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "pingng", string(buf))
+	require.Equal(t, uint32(1), dialer.duplications.Load())
+}
+
+func TestHostRewrite(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	t.Cleanup(server.Close)
+
+	proxy := ForTest(t, Config{
+		Listen: "127.0.0.1:0",
+		Target: server.URL,
+	})
+
+	resp, err := http.Get("http://" + proxy.BindAddr())
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	bs, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, server.Listener.Addr().String(), string(bs))
+
+	// Headers split across many reads are still rewritten
+	proxy = ForTest(t, Config{
+		Listen:     "127.0.0.1:0",
+		Target:     server.URL,
+		BufferSize: 8,
+		Read:       Direction{MTU: 5},
+	})
+	resp, err = http.Get("http://" + proxy.BindAddr())
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	bs, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, server.Listener.Addr().String(), string(bs))
+
+	t.Run("split", func(t *testing.T) {
+		client, server := net.Pipe()
+		defer client.Close()
+		c := hostHeaderToxic("example.com").Wrap(server, ToxicInfo{})
+
+		go func() {
+			for _, b := range []byte("GET / HTTP/1.1\r\nHost: a\r\n\r\nbody") {
+				client.Write([]byte{b})
+			}
+			client.Close()
+		}()
+		out, err := io.ReadAll(c)
+		require.NoError(t, err)
+		require.Equal(t, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\nbody", string(out))
+	})
+
+	t.Run("not HTTP", func(t *testing.T) {
+		client, server := net.Pipe()
+		defer client.Close()
+		c := hostHeaderToxic("example.com").Wrap(server, ToxicInfo{})
+
+		go func() {
+			client.Write([]byte("GET"))
+			client.Write([]byte(" key\r\nHost: a\r\n\r\n"))
+			client.Close()
+		}()
+		out, err := io.ReadAll(c)
+		require.NoError(t, err)
+		require.Equal(t, "GET key\r\nHost: a\r\n\r\n", string(out))
+	})
+
+	t.Run("default ports", func(t *testing.T) {
+		cases := []struct {
+			rt       route
+			expected string
+		}{
+			{route{target: "example.com:80", rewriteHost: true}, "example.com"},
+			{route{target: "example.com:443", rewriteHost: true, targetTLS: &tls.Config{}}, "example.com"},
+			{route{target: "example.com:443", rewriteHost: true}, "example.com:443"},
+			{route{target: "example.com:80", rewriteHost: true, targetTLS: &tls.Config{}}, "example.com:80"},
+			{route{target: "[::1]:80", rewriteHost: true}, "[::1]"},
+			{route{target: "example.com:80"}, ""},
+		}
+		for _, tc := range cases {
+			require.Equal(t, tc.expected, tc.rt.hostHeader(), tc.rt.target)
+		}
+	})
+}
+
+// recordingConn remembers the size of each write.
+type recordingConn struct {
+	net.Conn
+
+	buf    bytes.Buffer
+	writes []int
+	closed bool
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	c.writes = append(c.writes, len(b))
+	return c.buf.Write(b)
+}
+
+func (c *recordingConn) CloseWrite() error {
+	c.closed = true
+	return nil
+}
+
+func TestBufferSize(t *testing.T) {
+	dst := &recordingConn{}
+	src := bytes.NewBufferString("pingpong")
+
+	errCh := make(chan error, 1)
+	pipe(errCh, dst, src, newBufferPool(2), func(int) {})
+	require.NoError(t, <-errCh)
+
+	// Data is forwarded two bytes at a time
+	require.Equal(t, "pingpong", dst.buf.String())
+	require.Equal(t, []int{2, 2, 2, 2}, dst.writes)
+	require.True(t, dst.closed)
+
+	buf := newBufferPool(0).Get().(*[]byte)
+	require.Len(t, *buf, defaultBufferSize)
+}
+
+func TestHijack(t *testing.T) {
+	// Each server greets clients with its name
+	greeter := func(name string) string {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() { ln.Close() })
+
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				conn.Write([]byte(name))
+				conn.Close()
+			}
+		}()
+		return ln.Addr().String()
+	}
+	primary, stub := greeter("primary"), greeter("stub")
+
+	proxy := ForTest(t, Config{
+		Target:       primary,
+		HijackRatio:  50,
+		HijackTarget: stub,
+	})
+
+	greetings := make(map[string]int)
+	for i := 0; i < 40; i++ {
+		conn, err := net.Dial("tcp", proxy.BindAddr())
+		require.NoError(t, err)
+
+		greeting, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		conn.Close()
+		greetings[string(greeting)]++
+	}
+	require.Len(t, greetings, 2)
+	require.Equal(t, uint32(greetings["stub"]), proxy.Stats().Hijacks)
+
+	for _, info := range proxy.Connections() {
+		if info.Hijacked {
+			require.Equal(t, stub, info.Target)
+		} else {
+			require.Equal(t, primary, info.Target)
+		}
+	}
+}
+
+func BenchmarkProxy(b *testing.B) {
+	proxy := ForTest(b, Config{
+		Listen:       "127.0.0.1:0",
+		Target:       echoServer(b),
+		PreserveHost: true,
+	})
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(b, err)
+	defer conn.Close()
+
+	payload := make([]byte, 32*1024)
+	buf := make([]byte, len(payload))
+
+	b.SetBytes(int64(len(payload)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := conn.Write(payload); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
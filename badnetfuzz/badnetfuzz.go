@@ -0,0 +1,278 @@
+// Package badnetfuzz lets go test -fuzz choose which faults a badnet proxy injects.
+//
+// Rather than rolling against ratios, each read and write on a connection takes the
+// next of its direction's steps from a Schedule decoded from the fuzzer's input, so
+// the fuzzer can explore failures, truncations and delays at every point in a
+// client's conversation and reproduce any it finds from testdata/fuzz.
+//
+//	func FuzzClient(f *testing.F) {
+//	    f.Add([]byte{})
+//	    f.Fuzz(func(t *testing.T, schedule []byte) {
+//	        proxy := badnetfuzz.ForTest(t, schedule, badnet.Config{Target: server})
+//	        client := NewClient(proxy.BindAddr())
+//	        if _, err := client.Get(ctx, "key"); err != nil && !isRetryable(err) {
+//	            t.Fatal(err)
+//	        }
+//	    })
+//	}
+package badnetfuzz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adamdecaf/badnet"
+)
+
+// Fault is what happens to a single read or write.
+type Fault int
+
+const (
+	// Pass leaves the operation alone.
+	Pass Fault = iota
+
+	// Fail moves some of the data and then returns an error.
+	Fail
+
+	// Truncate moves some of the data but reports all of it as moved.
+	Truncate
+
+	// Delay holds the operation up before it runs.
+	Delay
+
+	faults
+)
+
+func (f Fault) String() string {
+	switch f {
+	case Pass:
+		return "pass"
+	case Fail:
+		return "fail"
+	case Truncate:
+		return "truncate"
+	case Delay:
+		return "delay"
+	}
+	return "unknown"
+}
+
+// Step is the fault applied to one operation. Arg picks how much data Fail and
+// Truncate move, as a fraction of 255, and how long Delay waits, up to MaxDelay.
+type Step struct {
+	Fault Fault
+	Arg   uint8
+}
+
+func (s Step) String() string {
+	return fmt.Sprintf("%v(%d)", s.Fault, s.Arg)
+}
+
+// Schedule is the series of steps applied to each connection's operations. Steps
+// alternate between directions: the first, third and so on are taken by reads in
+// the order they happen, and the others by writes, so reads and writes racing each
+// other can't change which step each gets. Operations past the end of their steps
+// pass.
+type Schedule []Step
+
+// split returns the steps taken by reads and by writes.
+func (s Schedule) split() (reads, writes Schedule) {
+	for i, step := range s {
+		if i%2 == 0 {
+			reads = append(reads, step)
+		} else {
+			writes = append(writes, step)
+		}
+	}
+	return reads, writes
+}
+
+// MaxDelay is the longest a Delay step waits, kept short so each fuzz run is quick.
+// Delays wait on the proxy's Config.Clock and end when the connection closes.
+var MaxDelay = 20 * time.Millisecond
+
+// ErrInjected is returned by operations which a Fail step failed.
+var ErrInjected = errors.New("badnetfuzz: injected failure")
+
+// Parse decodes a Schedule from fuzzer input, using two bytes per step. Every input
+// is valid, and a trailing odd byte is ignored.
+func Parse(data []byte) Schedule {
+	schedule := make(Schedule, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		schedule = append(schedule, Step{
+			Fault: Fault(data[i] % byte(faults)),
+			Arg:   data[i+1],
+		})
+	}
+	return schedule
+}
+
+// ForTest starts a badnet proxy which applies the schedule decoded from data to each
+// connection, after any faults in conf.
+func ForTest(t testing.TB, data []byte, conf badnet.Config) *badnet.Proxy {
+	t.Helper()
+
+	schedule := Parse(data)
+	if len(schedule) > 0 {
+		t.Logf("badnetfuzz: schedule %v", schedule)
+	}
+	conf.Toxics = append(conf.Toxics, schedule.Toxic())
+	return badnet.ForTest(t, conf)
+}
+
+// Toxic applies the schedule to each connection it wraps.
+func (s Schedule) Toxic() badnet.Toxic {
+	return badnet.ToxicFunc(func(c net.Conn, info badnet.ToxicInfo) net.Conn {
+		reads, writes := s.split()
+		ctx := info.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		return &scheduledConn{
+			Conn:   c,
+			reads:  &steps{schedule: reads},
+			writes: &steps{schedule: writes},
+			ctx:    ctx,
+			clock:  info.Clock,
+			closed: make(chan struct{}),
+		}
+	})
+}
+
+type scheduledConn struct {
+	net.Conn
+
+	// reads and writes happen concurrently, so each takes its own steps
+	reads, writes *steps
+
+	// Delay steps wait on clock, or the system clock when it's nil, and end early
+	// once ctx is done or the connection is closed.
+	ctx       context.Context
+	clock     badnet.Clock
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// steps are taken by one direction's operations in the order they start.
+type steps struct {
+	mu       sync.Mutex
+	schedule Schedule
+	next     int
+}
+
+// NetConn returns the underlying connection.
+func (c *scheduledConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// take returns the next step.
+func (s *steps) take() Step {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var step Step
+	if s.next < len(s.schedule) {
+		step = s.schedule[s.next]
+		s.next++
+	}
+	return step
+}
+
+// take returns the next of steps, waiting out any delay. It returns net.ErrClosed
+// if the connection closes first.
+func (c *scheduledConn) take(s *steps) (Step, error) {
+	step := s.take()
+	if step.Fault != Delay {
+		return step, nil
+	}
+	d := MaxDelay * time.Duration(step.Arg) / 255
+	if d <= 0 {
+		return step, nil
+	}
+
+	var after <-chan time.Time
+	if c.clock != nil {
+		after = c.clock.After(d)
+	} else {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		after = timer.C
+	}
+	select {
+	case <-after:
+		return step, nil
+	case <-c.ctx.Done():
+		return step, net.ErrClosed
+	case <-c.closed:
+		return step, net.ErrClosed
+	}
+}
+
+func (c *scheduledConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+// portion returns how much of n bytes a step moves, leaving out at least one.
+func (s Step) portion(n int) int {
+	return (n - 1) * int(s.Arg) / 255
+}
+
+func (c *scheduledConn) Read(b []byte) (int, error) {
+	step, err := c.take(c.reads)
+	if err != nil {
+		return 0, err
+	}
+	if len(b) == 0 {
+		return c.Conn.Read(b)
+	}
+
+	switch step.Fault {
+	case Fail:
+		n, err := c.Conn.Read(b[:max(step.portion(len(b)), 1)])
+		if err != nil {
+			return n, err
+		}
+		return n, ErrInjected
+
+	case Truncate:
+		// Drop the end of what was read
+		n, err := c.Conn.Read(b)
+		if n > 1 {
+			n = max(step.portion(n), 1)
+		}
+		return n, err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *scheduledConn) Write(b []byte) (int, error) {
+	step, err := c.take(c.writes)
+	if err != nil {
+		return 0, err
+	}
+	if len(b) == 0 {
+		return c.Conn.Write(b)
+	}
+
+	switch step.Fault {
+	case Fail:
+		n, err := c.Conn.Write(b[:step.portion(len(b))])
+		if err != nil {
+			return n, err
+		}
+		return n, ErrInjected
+
+	case Truncate:
+		if _, err := c.Conn.Write(b[:step.portion(len(b))]); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
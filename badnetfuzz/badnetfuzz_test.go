@@ -0,0 +1,138 @@
+package badnetfuzz
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/adamdecaf/badnet"
+	"github.com/stretchr/testify/require"
+)
+
+func echoServer(t testing.TB) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func TestParse(t *testing.T) {
+	require.Empty(t, Parse(nil))
+	require.Empty(t, Parse([]byte{1}))
+
+	schedule := Parse([]byte{0, 9, 1, 128, 6, 255, 7, 0, 42})
+	require.Equal(t, Schedule{
+		{Fault: Pass, Arg: 9},
+		{Fault: Fail, Arg: 128},
+		{Fault: Truncate, Arg: 255},
+		{Fault: Delay, Arg: 0},
+	}, schedule)
+	require.Equal(t, "[pass(9) fail(128) truncate(255) delay(0)]", fmt.Sprint(schedule))
+
+	// Reads and writes take alternate steps
+	reads, writes := schedule.split()
+	require.Equal(t, Schedule{{Fault: Pass, Arg: 9}, {Fault: Truncate, Arg: 255}}, reads)
+	require.Equal(t, Schedule{{Fault: Fail, Arg: 128}, {Fault: Delay, Arg: 0}}, writes)
+}
+
+func TestForTest(t *testing.T) {
+	target := echoServer(t)
+	cases := map[string][]byte{
+		// The proxy's first read from the client drops the end of the message
+		"read": {byte(Truncate), 128},
+
+		// or its first write back to the client does
+		"write": {byte(Pass), 0, byte(Truncate), 128},
+	}
+	for name, schedule := range cases {
+		schedule := schedule
+		t.Run(name, func(t *testing.T) {
+			proxy := ForTest(t, schedule, badnet.Config{Target: target})
+
+			conn, err := net.Dial("tcp", proxy.BindAddr())
+			require.NoError(t, err)
+			defer conn.Close()
+
+			_, err = conn.Write([]byte("0123456789"))
+			require.NoError(t, err)
+
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			buf := make([]byte, 10)
+			n, err := conn.Read(buf)
+			require.NoError(t, err)
+			require.Equal(t, "0123", string(buf[:n]))
+		})
+	}
+}
+
+func FuzzEcho(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{byte(Fail), 0})
+	f.Add([]byte{byte(Pass), 0, byte(Fail), 200})
+	f.Add([]byte{byte(Delay), 255, byte(Truncate), 10})
+
+	target := echoServer(f)
+	f.Fuzz(func(t *testing.T, schedule []byte) {
+		proxy := ForTest(t, schedule, badnet.Config{Target: target})
+
+		conn, err := net.Dial("tcp", proxy.BindAddr())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		// Whatever the schedule, the echo is a prefix of what was sent and the
+		// client never hangs
+		message := []byte("hello, badnet")
+		conn.Write(message)
+		conn.(*net.TCPConn).CloseWrite()
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		echoed, err := io.ReadAll(conn)
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			t.Fatal("client hung")
+		}
+		require.True(t, bytes.HasPrefix(message, echoed), "echoed %q", echoed)
+	})
+}
+
+func TestDelayEndsOnClose(t *testing.T) {
+	clock := badnet.NewFakeClock(time.Now())
+	client, server := net.Pipe()
+	defer server.Close()
+
+	conn := Schedule{{Fault: Delay, Arg: 255}}.Toxic().Wrap(client, badnet.ToxicInfo{Clock: clock})
+	errs := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 10))
+		errs <- err
+	}()
+	require.Eventually(t, func() bool {
+		return clock.Waiters() == 1
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, conn.Close())
+	select {
+	case err := <-errs:
+		require.ErrorIs(t, err, net.ErrClosed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Read didn't return after Close")
+	}
+}
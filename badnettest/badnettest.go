@@ -0,0 +1,128 @@
+// Package badnettest provides assertions for tests using badnet proxies and dialers.
+//
+// Each assertion reports a failure with t.Errorf, including a summary of the
+// proxy's counters, and returns whether it passed.
+package badnettest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/adamdecaf/badnet"
+)
+
+// Source is anything which keeps badnet's counters, such as a *badnet.Proxy or *badnet.Dialer.
+type Source interface {
+	Stats() badnet.Stats
+}
+
+// AssertSawConnections checks that at least min connections were made.
+func AssertSawConnections(t testing.TB, s Source, min uint32) bool {
+	t.Helper()
+
+	stats := s.Stats()
+	if stats.Connections < min {
+		t.Errorf("badnet: expected at least %d connections, saw %d\n%s", min, stats.Connections, Summary(stats))
+		return false
+	}
+	return true
+}
+
+// AssertFaultsInjected checks that at least min failures were injected or caused.
+// See badnet.Stats.Failures for what's included.
+func AssertFaultsInjected(t testing.TB, s Source, min uint32) bool {
+	t.Helper()
+
+	stats := s.Stats()
+	if failures := stats.Failures(); failures < min {
+		t.Errorf("badnet: expected at least %d faults, saw %d\n%s", min, failures, Summary(stats))
+		return false
+	}
+	return true
+}
+
+// Direction picks which way data was forwarded through a proxy.
+type Direction int
+
+const (
+	// Read is data forwarded from clients to targets.
+	Read Direction = iota
+
+	// Write is data forwarded from targets back to clients.
+	Write
+)
+
+func (d Direction) String() string {
+	if d == Write {
+		return "target to client"
+	}
+	return "client to target"
+}
+
+// AssertBytesForwarded checks that at least min bytes were forwarded in the given direction.
+func AssertBytesForwarded(t testing.TB, s Source, dir Direction, min uint64) bool {
+	t.Helper()
+
+	stats := s.Stats()
+	forwarded := stats.ReadBytes
+	if dir == Write {
+		forwarded = stats.WriteBytes
+	}
+	if forwarded < min {
+		t.Errorf("badnet: expected at least %d bytes forwarded %v, saw %d\n%s", min, dir, forwarded, Summary(stats))
+		return false
+	}
+	return true
+}
+
+// Summary describes every non-zero counter in stats, one per line.
+func Summary(stats badnet.Stats) string {
+	counters := []struct {
+		name  string
+		value uint32
+	}{
+		{"connections", stats.Connections},
+		{"read failures", stats.ReadFailures},
+		{"write failures", stats.WriteFailures},
+		{"target failures", stats.TargetFailures},
+		{"outages", stats.Outages},
+		{"handshake failures", stats.HandshakeFailures},
+		{"bad certificates", stats.BadCertificates},
+		{"client certificate failures", stats.ClientCertificateFailures},
+		{"failovers", stats.Failovers},
+		{"refused", stats.Refused},
+		{"truncations", stats.Truncations},
+		{"garbage", stats.Garbage},
+		{"duplications", stats.Duplications},
+		{"idle timeouts", stats.IdleTimeouts},
+		{"expirations", stats.Expirations},
+		{"early closes", stats.EarlyCloses},
+		{"tarpits", stats.Tarpits},
+		{"byte limits", stats.ByteLimits},
+		{"failed requests", stats.FailedRequests},
+		{"reordered responses", stats.ReorderedResponses},
+		{"pool dials", stats.PoolDials},
+		{"pool reuses", stats.PoolReuses},
+		{"storm connections", stats.StormConnections},
+		{"dropped datagrams", stats.DroppedDatagrams},
+		{"duplicated datagrams", stats.DuplicatedDatagrams},
+		{"reordered datagrams", stats.ReorderedDatagrams},
+		{"hijacks", stats.Hijacks},
+		{"offline responses", stats.OfflineResponses},
+	}
+
+	var buf strings.Builder
+	if stats.ReadBytes > 0 || stats.WriteBytes > 0 {
+		fmt.Fprintf(&buf, "  bytes forwarded: %d client to target, %d target to client\n", stats.ReadBytes, stats.WriteBytes)
+	}
+	for _, c := range counters {
+		if c.value > 0 {
+			fmt.Fprintf(&buf, "  %s: %d\n", c.name, c.value)
+		}
+	}
+	if buf.Len() == 0 {
+		return "  no connections or faults recorded"
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
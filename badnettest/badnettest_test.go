@@ -0,0 +1,95 @@
+package badnettest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/adamdecaf/badnet"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recordingT captures failures instead of failing the test
+type recordingT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *recordingT) Helper() {}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertions(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	target := ln.Addr().String()
+	ln.Close() // nothing is listening, so every connection fails
+
+	proxy := badnet.ForTest(t, badnet.Config{
+		Listen: "127.0.0.1:0",
+		Target: target,
+	})
+
+	rec := &recordingT{TB: t}
+	require.False(t, AssertSawConnections(rec, proxy, 1))
+	require.False(t, AssertFaultsInjected(rec, proxy, 1))
+	require.Len(t, rec.errors, 2)
+	require.Contains(t, rec.errors[0], "expected at least 1 connections, saw 0")
+	require.Contains(t, rec.errors[0], "no connections or faults recorded")
+
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	conn.Read(make([]byte, 1)) // wait for the proxy to give up on the target
+	conn.Close()
+
+	require.True(t, AssertSawConnections(t, proxy, 1))
+	require.True(t, AssertFaultsInjected(t, proxy, 1))
+
+	rec = &recordingT{TB: t}
+	require.False(t, AssertFaultsInjected(rec, proxy, 2))
+	require.Len(t, rec.errors, 1)
+	require.Contains(t, rec.errors[0], "expected at least 2 faults, saw 1")
+	require.Contains(t, rec.errors[0], "target failures: 1")
+}
+
+func TestAssertBytesForwarded(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(io.Discard, conn)
+	}()
+
+	proxy := badnet.ForTest(t, badnet.Config{
+		Listen: "127.0.0.1:0",
+		Target: ln.Addr().String(),
+	})
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	_, err = conn.Write(make([]byte, 100))
+	require.NoError(t, err)
+	conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, proxy.WaitIdle(ctx))
+
+	require.True(t, AssertBytesForwarded(t, proxy, Read, 100))
+
+	rec := &recordingT{TB: t}
+	require.False(t, AssertBytesForwarded(rec, proxy, Write, 1))
+	require.Len(t, rec.errors, 1)
+	require.Contains(t, rec.errors[0], "expected at least 1 bytes forwarded target to client, saw 0")
+	require.Contains(t, rec.errors[0], "bytes forwarded: 100 client to target, 0 target to client")
+}
@@ -0,0 +1,115 @@
+package badnet
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// BalanceStrategy chooses which of Config.Targets receives each connection.
+type BalanceStrategy int
+
+const (
+	// BalanceRoundRobin sends connections to each target in turn.
+	BalanceRoundRobin BalanceStrategy = iota
+
+	// BalanceRandom picks a target at random for each connection.
+	BalanceRandom
+
+	// BalanceWeighted picks a target at random in proportion to its TargetOptions.Weight.
+	BalanceWeighted
+)
+
+func (s BalanceStrategy) String() string {
+	switch s {
+	case BalanceRoundRobin:
+		return "round-robin"
+	case BalanceRandom:
+		return "random"
+	case BalanceWeighted:
+		return "weighted"
+	}
+	return "unknown"
+}
+
+// TargetOptions adjusts how one of Config.Targets is used.
+type TargetOptions struct {
+	// Weight is used by BalanceWeighted. Targets default to a weight of 1.
+	Weight int
+
+	// Read and Write replace the Config's faults for connections to this target.
+	Read, Write *Direction
+}
+
+type balancer struct {
+	strategy BalanceStrategy
+	targets  []string
+	weights  []int
+	total    int
+
+	next atomic.Uint64
+
+	mu          sync.Mutex
+	connections map[string]uint32
+}
+
+func newBalancer(conf Config) *balancer {
+	b := &balancer{
+		strategy:    conf.Balance,
+		targets:     conf.Targets,
+		connections: make(map[string]uint32),
+	}
+	for _, target := range conf.Targets {
+		weight := 1
+		if opts, ok := conf.TargetOptions[target]; ok && opts.Weight > 0 {
+			weight = opts.Weight
+		}
+		b.weights = append(b.weights, weight)
+		b.total += weight
+	}
+	return b
+}
+
+// pick returns the next target, or an empty string when there are no Targets.
+func (b *balancer) pick() string {
+	if len(b.targets) == 0 {
+		return ""
+	}
+
+	var target string
+	switch b.strategy {
+	case BalanceRandom:
+		target = b.targets[randomInt(len(b.targets))]
+
+	case BalanceWeighted:
+		n := randomInt(b.total)
+		for i, w := range b.weights {
+			if n < w {
+				target = b.targets[i]
+				break
+			}
+			n -= w
+		}
+
+	default:
+		n := b.next.Add(1) - 1
+		target = b.targets[n%uint64(len(b.targets))]
+	}
+
+	b.mu.Lock()
+	b.connections[target]++
+	b.mu.Unlock()
+
+	return target
+}
+
+// TargetConnections returns how many connections were sent to each of Config.Targets.
+func (p *Proxy) TargetConnections() map[string]uint32 {
+	p.balancer.mu.Lock()
+	defer p.balancer.mu.Unlock()
+
+	out := make(map[string]uint32, len(p.balancer.connections))
+	for target, n := range p.balancer.connections {
+		out[target] = n
+	}
+	return out
+}
@@ -0,0 +1,87 @@
+package badnet
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBalance(t *testing.T) {
+	servers := make([]string, 3)
+	for i := range servers {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Write([]byte("PONG"))
+		}))
+		t.Cleanup(server.Close)
+		servers[i] = server.URL
+	}
+
+	get := func(t *testing.T, proxy *Proxy) error {
+		t.Helper()
+
+		client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+		resp, err := client.Get("http://" + proxy.BindAddr())
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		_, err = io.ReadAll(resp.Body)
+		return err
+	}
+
+	t.Run("round robin", func(t *testing.T) {
+		proxy := ForTest(t, Config{
+			Listen:  "127.0.0.1:0",
+			Targets: servers,
+		})
+		for i := 0; i < 6; i++ {
+			require.NoError(t, get(t, proxy))
+		}
+
+		counts := proxy.TargetConnections()
+		for _, server := range servers {
+			require.Equal(t, uint32(2), counts[server])
+		}
+	})
+
+	t.Run("weighted", func(t *testing.T) {
+		proxy := ForTest(t, Config{
+			Listen:  "127.0.0.1:0",
+			Targets: servers[:2],
+			Balance: BalanceWeighted,
+			TargetOptions: map[string]TargetOptions{
+				servers[0]: {Weight: 1},
+				servers[1]: {Weight: 0}, // defaults to 1
+			},
+		})
+		for i := 0; i < 20; i++ {
+			require.NoError(t, get(t, proxy))
+		}
+
+		counts := proxy.TargetConnections()
+		require.Equal(t, uint32(20), counts[servers[0]]+counts[servers[1]])
+	})
+
+	t.Run("per-target faults", func(t *testing.T) {
+		proxy := ForTest(t, Config{
+			Listen:  "127.0.0.1:0",
+			Targets: servers[:2],
+			TargetOptions: map[string]TargetOptions{
+				servers[1]: {Write: &Direction{FailureRatio: 100}},
+			},
+		})
+
+		require.NoError(t, get(t, proxy))
+		require.Error(t, get(t, proxy))
+		require.NoError(t, get(t, proxy))
+	})
+}
+
+func TestTargetAddress(t *testing.T) {
+	require.Equal(t, "127.0.0.1:8080", targetAddress("http://127.0.0.1:8080", false))
+	require.Equal(t, "example.com:443", targetAddress("example.com", true))
+}
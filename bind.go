@@ -0,0 +1,75 @@
+package badnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// BindRetry decides what happens when the proxy can't listen on an address
+// because another process already holds its port.
+type BindRetry struct {
+	// For is how long a busy address is retried before giving up, which covers
+	// ports still held by a previous test or one shutting down.
+	For time.Duration
+
+	// Fallback listens on any free port of the same host once For has passed,
+	// logging a warning, instead of failing the test. BindAddr reports the port
+	// which was chosen.
+	Fallback bool
+}
+
+// bindRetryInterval is how often a busy address is tried again.
+const bindRetryInterval = 50 * time.Millisecond
+
+// listen binds addr, retrying and falling back according to retry. Errors for
+// busy ports name the process holding them where it can be found. Transparent
+// listeners accept connections sent by TPROXY rules where that's permitted.
+func listen(network, addr string, retry BindRetry, transparent bool, logf func(format string, args ...interface{})) (net.Listener, error) {
+	var lc net.ListenConfig
+	if transparent {
+		lc.Control = func(network, _ string, c syscall.RawConn) error {
+			return setTransparent(network, c, logf)
+		}
+	}
+	deadline := time.Now().Add(retry.For)
+	for {
+		ln, err := lc.Listen(context.Background(), network, addr)
+		if err == nil || !errors.Is(err, syscall.EADDRINUSE) {
+			return ln, err
+		}
+		if time.Now().Before(deadline) {
+			time.Sleep(bindRetryInterval)
+			continue
+		}
+
+		err = fmt.Errorf("%w (%s)", err, portOwnerHint(addr))
+		if !retry.Fallback {
+			return nil, err
+		}
+		host, _, _ := net.SplitHostPort(addr)
+		ln, fallbackErr := lc.Listen(context.Background(), network, net.JoinHostPort(host, "0"))
+		if fallbackErr != nil {
+			return nil, err
+		}
+		logf("badnet: %v, listening on %s instead", err, ln.Addr())
+		return ln, nil
+	}
+}
+
+// portOwnerHint describes which process holds addr's port, or how to find out.
+func portOwnerHint(addr string) string {
+	_, p, _ := net.SplitHostPort(addr)
+	port, err := strconv.Atoi(p)
+	if err != nil {
+		return "port is in use"
+	}
+	if owner := portOwner(port); owner != "" {
+		return "held by " + owner
+	}
+	return fmt.Sprintf("find the process holding it with: lsof -i :%d", port)
+}
@@ -0,0 +1,19 @@
+package badnet
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPortOwner(t *testing.T) {
+	held, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer held.Close()
+
+	_, err = listen("tcp", held.Addr().String(), BindRetry{}, false, t.Logf)
+	require.ErrorContains(t, err, fmt.Sprintf("held by pid %d", os.Getpid()))
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package badnet
+
+func portOwner(port int) string {
+	return ""
+}
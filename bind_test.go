@@ -0,0 +1,58 @@
+package badnet
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBindRetry(t *testing.T) {
+	held, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := held.Addr().String()
+
+	var logs []string
+	logf := func(format string, args ...interface{}) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}
+
+	// Busy ports fail straight away by default
+	_, err = listen("tcp", addr, BindRetry{}, false, logf)
+	require.ErrorIs(t, err, syscall.EADDRINUSE)
+
+	// or fall back to another port
+	ln, err := listen("tcp", addr, BindRetry{For: 100 * time.Millisecond, Fallback: true}, false, logf)
+	require.NoError(t, err)
+	require.NotEqual(t, addr, ln.Addr().String())
+	require.NoError(t, ln.Close())
+	require.Len(t, logs, 1)
+	require.Contains(t, logs[0], ln.Addr().String())
+
+	// Retries pick up the port once it's released
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		held.Close()
+	}()
+	ln, err = listen("tcp", addr, BindRetry{For: 5 * time.Second}, false, logf)
+	require.NoError(t, err)
+	require.Equal(t, addr, ln.Addr().String())
+	require.NoError(t, ln.Close())
+}
+
+func TestBindRetryForTest(t *testing.T) {
+	held, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer held.Close()
+
+	proxy := ForTest(t, Config{
+		Listen:    held.Addr().String(),
+		Target:    echoServer(t),
+		BindRetry: BindRetry{Fallback: true},
+	})
+	require.NotEqual(t, held.Addr().String(), proxy.BindAddr())
+	require.Greater(t, proxy.Port(), 0)
+}
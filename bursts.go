@@ -0,0 +1,109 @@
+package badnet
+
+import (
+	"sync"
+	"time"
+)
+
+// Burst describes a period of faults which begins once the proxy has accepted a
+// number of connections, such as "after 100 connections, inject a 10-second outage".
+//
+// Each Burst triggers once.
+type Burst struct {
+	// AfterConnections starts the burst when this many connections have been accepted.
+	AfterConnections uint32
+
+	// Duration is how long the burst lasts once triggered.
+	Duration time.Duration
+
+	// Outage closes every open connection when the burst starts and every
+	// connection accepted while it's active.
+	Outage bool
+
+	// FailureRatio is applied to every read and write while the burst is active,
+	// including on connections which were established before it started.
+	FailureRatio int
+}
+
+type bursts struct {
+	mu     sync.Mutex
+	bursts []Burst
+	until  []time.Time // zero until triggered
+	clock  Clock
+}
+
+func newBursts(bs []Burst, clock Clock) *bursts {
+	return &bursts{
+		bursts: bs,
+		until:  make([]time.Time, len(bs)),
+		clock:  clock,
+	}
+}
+
+// connectionAccepted triggers any bursts waiting on the connection count. It
+// reports if an outage was triggered.
+func (b *bursts) connectionAccepted(count uint32) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var outage bool
+	for i := range b.bursts {
+		if b.until[i].IsZero() && count >= b.bursts[i].AfterConnections {
+			b.until[i] = b.clock.Now().Add(b.bursts[i].Duration)
+			outage = outage || b.bursts[i].Outage
+		}
+	}
+	return outage
+}
+
+// started returns when each burst began, which is zero for bursts not yet triggered.
+func (b *bursts) started() []time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	started := make([]time.Time, len(b.bursts))
+	for i := range b.bursts {
+		if !b.until[i].IsZero() {
+			started[i] = b.until[i].Add(-b.bursts[i].Duration)
+		}
+	}
+	return started
+}
+
+// outage reports if any active burst is a full outage.
+func (b *bursts) outage() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+	for i := range b.bursts {
+		if b.bursts[i].Outage && now.Before(b.until[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// failureRatio returns the highest FailureRatio of the active bursts.
+func (b *bursts) failureRatio() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var ratio int
+	now := b.clock.Now()
+	for i := range b.bursts {
+		if now.Before(b.until[i]) && b.bursts[i].FailureRatio > ratio {
+			ratio = b.bursts[i].FailureRatio
+		}
+	}
+	return ratio
+}
+
+// refuseConnection reports if the numbered connection falls outside the window
+// set by FailFirstConnections and FailConnectionsAfter.
+func (c Config) refuseConnection(number uint32) bool {
+	if number <= c.FailFirstConnections {
+		return true
+	}
+	return c.FailConnectionsAfter > 0 && number > c.FailConnectionsAfter
+}
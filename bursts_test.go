@@ -0,0 +1,123 @@
+package badnet
+
+import (
+	"context"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBursts(t *testing.T) {
+	proxy := ForTest(t, Config{
+		Listen: "127.0.0.1:0",
+		Target: echoServer(t),
+		Bursts: []Burst{
+			{AfterConnections: 2, Duration: 250 * time.Millisecond, Outage: true},
+		},
+	})
+
+	ping := func() error {
+		conn, err := net.Dial("tcp", proxy.BindAddr())
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			return err
+		}
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		return err
+	}
+
+	require.NoError(t, ping())
+	require.Error(t, ping())
+	require.Error(t, ping())
+
+	time.Sleep(300 * time.Millisecond)
+	require.NoError(t, ping())
+
+	require.Equal(t, uint32(2), proxy.outages.Load())
+}
+
+func TestBurstsOutageSevers(t *testing.T) {
+	proxy := ForTest(t, Config{
+		Listen: "127.0.0.1:0",
+		Target: echoServer(t),
+		Bursts: []Burst{
+			{AfterConnections: 2, Duration: time.Minute, Outage: true},
+		},
+	})
+
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+
+	// The second connection starts the outage, which closes the first.
+	other, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer other.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(buf)
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestFailConnections(t *testing.T) {
+	proxy := ForTest(t, Config{
+		Listen:               "127.0.0.1:0",
+		Target:               echoServer(t),
+		FailFirstConnections: 3,
+		FailConnectionsAfter: 5,
+	})
+
+	ping := func() error {
+		conn, err := net.Dial("tcp", proxy.BindAddr())
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			return err
+		}
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		return err
+	}
+
+	for i := 1; i <= 6; i++ {
+		err := ping()
+		if i <= 3 || i > 5 {
+			require.Error(t, err, "connection %d", i)
+		} else {
+			require.NoError(t, err, "connection %d", i)
+		}
+	}
+	require.Equal(t, uint32(4), proxy.refused.Load())
+
+	t.Run("Dialer", func(t *testing.T) {
+		dialer := DialerForTest(t, Config{
+			Target:               echoServer(t),
+			FailFirstConnections: 1,
+		})
+
+		_, err := dialer.Dial(context.Background())
+		require.ErrorIs(t, err, syscall.ECONNREFUSED)
+
+		conn, err := dialer.Dial(context.Background())
+		require.NoError(t, err)
+		require.NoError(t, conn.Close())
+	})
+}
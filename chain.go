@@ -0,0 +1,48 @@
+package badnet
+
+import (
+	"testing"
+)
+
+// Chain starts a proxy in front of p with its own conditions, so connections pass
+// through both. Listen defaults to 127.0.0.1:0.
+//
+//	lan := badnet.ForTest(t, badnet.Config{Target: db, Read: flaky, Write: flaky})
+//	wan := lan.Chain(t, badnet.Config{Read: slow, Write: slow})
+func (p *Proxy) Chain(t testing.TB, conf Config) *Proxy {
+	t.Helper()
+
+	if conf.Listen == "" {
+		conf.Listen = "127.0.0.1:0"
+	}
+	conf.TargetProxy = p
+	return ForTest(t, conf)
+}
+
+// Hops returns p followed by each proxy it's chained in front of, in the order
+// connections pass through them, so the latency and faults of each stage can be
+// read on their own.
+func (p *Proxy) Hops() []*Proxy {
+	var hops []*Proxy
+	for hop := p; hop != nil; hop = hop.conf.TargetProxy {
+		hops = append(hops, hop)
+	}
+	return hops
+}
+
+// ChainStats returns the stats of the whole chain starting at p as if it were one
+// proxy. Failures and InjectedDelay are summed over every hop, while Connections,
+// ReadBytes and WriteBytes are taken from p alone since each hop carries the same
+// traffic and counting it at every stage would multiply it.
+func (p *Proxy) ChainStats() Stats {
+	var total Stats
+	for _, hop := range p.Hops() {
+		total = total.add(hop.Stats())
+	}
+
+	first := p.Stats()
+	total.Connections = first.Connections
+	total.ReadBytes = first.ReadBytes
+	total.WriteBytes = first.WriteBytes
+	return total
+}
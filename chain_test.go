@@ -0,0 +1,56 @@
+package badnet
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChain(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	t.Cleanup(server.Close)
+
+	lan := ForTest(t, Config{
+		Listen: "127.0.0.1:0",
+		Target: server.URL,
+		Write:  Direction{Latency: 25 * time.Millisecond},
+	})
+	wan := lan.Chain(t, Config{
+		Write: Direction{Latency: 50 * time.Millisecond},
+	})
+	require.Equal(t, lan.BindAddr(), wan.EffectiveConfig().Target)
+
+	client := &http.Client{
+		Transport: &http.Transport{DisableKeepAlives: true},
+	}
+	start := time.Now()
+	resp, err := client.Get("http://" + wan.BindAddr())
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// The last stage rewrites the Host header for the real target
+	bs, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, server.Listener.Addr().String(), string(bs))
+
+	// Latency from each stage adds up
+	require.GreaterOrEqual(t, time.Since(start), 75*time.Millisecond)
+	require.Equal(t, uint32(1), lan.connectionCount.Load())
+	require.Equal(t, uint32(1), wan.connectionCount.Load())
+
+	// Each hop reports its own latency and the chain counts the connection once
+	require.Equal(t, []*Proxy{wan, lan}, wan.Hops())
+	require.GreaterOrEqual(t, lan.Stats().InjectedDelay.Max, 25*time.Millisecond)
+	require.GreaterOrEqual(t, wan.Stats().InjectedDelay.Max, 50*time.Millisecond)
+
+	stats := wan.ChainStats()
+	require.Equal(t, uint32(1), stats.Connections)
+	require.Equal(t, wan.Stats().ReadBytes, stats.ReadBytes)
+	require.Equal(t, lan.Stats().InjectedDelay.Count+wan.Stats().InjectedDelay.Count, stats.InjectedDelay.Count)
+}
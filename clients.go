@@ -0,0 +1,131 @@
+package badnet
+
+import (
+	"fmt"
+	"net"
+	"sort"
+)
+
+// ClientOptions adjusts the faults for connections from clients in a network.
+type ClientOptions struct {
+	// Read and Write replace the faults otherwise chosen for the connection.
+	Read, Write *Direction
+}
+
+// clientPolicy is a parsed entry of Config.Clients.
+type clientPolicy struct {
+	key     string
+	network *net.IPNet
+	opts    ClientOptions
+}
+
+// parseClients returns the Config.Clients policies ordered from the most to the
+// least specific network.
+func parseClients(clients map[string]ClientOptions) ([]clientPolicy, error) {
+	out := make([]clientPolicy, 0, len(clients))
+	for key, opts := range clients {
+		network, err := parseClientNetwork(key)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, clientPolicy{key: key, network: network, opts: opts})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, _ := out[i].network.Mask.Size()
+		b, _ := out[j].network.Mask.Size()
+		return a > b
+	})
+	return out, nil
+}
+
+// parseClientNetwork reads a CIDR, or a single IP address.
+func parseClientNetwork(value string) (*net.IPNet, error) {
+	if ip := net.ParseIP(value); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client network %q: %w", value, err)
+	}
+	return network, nil
+}
+
+// clientPolicy returns the most specific policy covering the client, if any.
+func (p *Proxy) clientPolicy(addr net.Addr) (clientPolicy, bool) {
+	ip := addrIP(addr)
+	if ip == nil {
+		return clientPolicy{}, false
+	}
+	for _, policy := range p.clients {
+		if policy.network.Contains(ip) {
+			return policy, true
+		}
+	}
+	return clientPolicy{}, false
+}
+
+// addrIP returns the IP address of a TCP or UDP address.
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+func clientOptionsKey(network string) string {
+	return "client:" + network
+}
+
+// clientFilter refuses connections based on Config.AllowedClients and DeniedClients.
+type clientFilter struct {
+	allowed, denied []*net.IPNet
+}
+
+func newClientFilter(conf Config) (*clientFilter, error) {
+	f := &clientFilter{}
+	for _, value := range conf.AllowedClients {
+		network, err := parseClientNetwork(value)
+		if err != nil {
+			return nil, err
+		}
+		f.allowed = append(f.allowed, network)
+	}
+	for _, value := range conf.DeniedClients {
+		network, err := parseClientNetwork(value)
+		if err != nil {
+			return nil, err
+		}
+		f.denied = append(f.denied, network)
+	}
+	return f, nil
+}
+
+// allow reports if a connection from addr is accepted. Clients without an IP
+// address, such as over unix sockets, are always allowed.
+func (f *clientFilter) allow(addr net.Addr) bool {
+	ip := addrIP(addr)
+	if ip == nil {
+		return true
+	}
+	for _, network := range f.denied {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	if len(f.allowed) == 0 {
+		return true
+	}
+	for _, network := range f.allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
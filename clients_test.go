@@ -0,0 +1,106 @@
+package badnet
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseClients(t *testing.T) {
+	policies, err := parseClients(map[string]ClientOptions{
+		"10.0.0.0/8":  {},
+		"10.1.2.3":    {},
+		"10.1.0.0/16": {},
+		"::1":         {},
+	})
+	require.NoError(t, err)
+	require.Len(t, policies, 4)
+
+	// Most specific first
+	require.Equal(t, "::1", policies[0].key)
+	require.Equal(t, "10.1.2.3", policies[1].key)
+	require.Equal(t, "10.1.0.0/16", policies[2].key)
+	require.Equal(t, "10.0.0.0/8", policies[3].key)
+
+	_, err = parseClients(map[string]ClientOptions{"nope": {}})
+	require.ErrorContains(t, err, "invalid client network")
+}
+
+func TestClients(t *testing.T) {
+	degraded := &Direction{FailureRatio: 100}
+	proxy := ForTest(t, Config{
+		Listen: "127.0.0.1:0",
+		Target: echoServer(t),
+		Clients: map[string]ClientOptions{
+			"127.0.0.2/32": {Write: degraded},
+		},
+	})
+
+	ping := func(source string) error {
+		dialer := net.Dialer{
+			LocalAddr: &net.TCPAddr{IP: net.ParseIP(source)},
+			Timeout:   time.Second,
+		}
+		conn, err := dialer.Dial("tcp", proxy.BindAddr())
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			return err
+		}
+		_, err = io.ReadFull(conn, make([]byte, 4))
+		return err
+	}
+
+	require.NoError(t, ping("127.0.0.1"))
+
+	// Loopback addresses other than 127.0.0.1 aren't available everywhere
+	if err := ping("127.0.0.2"); err == nil {
+		t.Fatal("expected the degraded client to fail")
+	} else if proxy.writeFailures.Load() == 0 {
+		t.Skipf("127.0.0.2 is unavailable: %v", err)
+	}
+	require.NoError(t, ping("127.0.0.1"))
+}
+
+func TestClientFilter(t *testing.T) {
+	filter, err := newClientFilter(Config{
+		AllowedClients: []string{"10.0.0.0/8", "::1"},
+		DeniedClients:  []string{"10.0.0.5"},
+	})
+	require.NoError(t, err)
+
+	addr := func(ip string) net.Addr {
+		return &net.TCPAddr{IP: net.ParseIP(ip)}
+	}
+	require.True(t, filter.allow(addr("10.1.2.3")))
+	require.True(t, filter.allow(addr("::1")))
+	require.False(t, filter.allow(addr("10.0.0.5")))
+	require.False(t, filter.allow(addr("192.168.1.1")))
+	require.True(t, filter.allow(&net.UnixAddr{Name: "/tmp/sock"}))
+
+	_, err = newClientFilter(Config{DeniedClients: []string{"nope"}})
+	require.Error(t, err)
+
+	// Loopback clients are refused by the proxy
+	proxy := ForTest(t, Config{
+		Listen:        "127.0.0.1:0",
+		Target:        echoServer(t),
+		DeniedClients: []string{"127.0.0.0/8"},
+	})
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	conn.Write([]byte("ping"))
+	_, err = io.ReadFull(conn, make([]byte, 4))
+	require.Error(t, err)
+	require.Equal(t, uint32(1), proxy.refused.Load())
+}
@@ -0,0 +1,240 @@
+package badnet
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for latency, jitter, bandwidth limits, trickling and
+// bursts. Tests of timeout behavior can set Config.Clock to a FakeClock and advance
+// it instead of sleeping for real.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+
+	// After returns a channel which receives the time once d has passed.
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the system clock.
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// clock returns the Config's Clock, defaulting to the system clock.
+func (c Config) clock() Clock {
+	if c.Clock != nil {
+		return c.Clock
+	}
+	return realClock{}
+}
+
+// sleep waits for d on clock. It returns ctx's error if ctx is done first, or
+// net.ErrClosed if closing is.
+func sleep(ctx context.Context, clock Clock, d time.Duration, closing <-chan struct{}) error {
+	if d <= 0 {
+		return nil
+	}
+	fire, stop := after(clock, d)
+	defer stop()
+
+	select {
+	case <-fire:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-closing:
+		return net.ErrClosed
+	}
+}
+
+// after is clock.After along with a function to call once the channel is no longer
+// waited on, which stops the system clock's timer and removes a FakeClock's waiter.
+func after(clock Clock, d time.Duration) (<-chan time.Time, func()) {
+	switch c := clock.(type) {
+	case realClock:
+		timer := time.NewTimer(d)
+		return timer.C, func() { timer.Stop() }
+	case *FakeClock:
+		ch := c.After(d)
+		return ch, func() { c.stop(ch) }
+	}
+	return clock.After(d), func() {}
+}
+
+// clockTimer calls f in its own goroutine once d has passed on a Clock, like
+// time.AfterFunc. The system clock uses a time.Timer.
+type clockTimer struct {
+	clock Clock
+	f     func()
+	real  *time.Timer
+
+	// cancel stops the goroutine waiting for the current deadline, and is nil
+	// once it fired or was stopped. stop releases the clock's wait for it.
+	mu     sync.Mutex
+	cancel chan struct{}
+	stop   func()
+}
+
+func afterFunc(clock Clock, d time.Duration, f func()) *clockTimer {
+	t := &clockTimer{clock: clock, f: f}
+	if _, ok := clock.(realClock); ok {
+		t.real = time.AfterFunc(d, f)
+		return t
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.start(d)
+	return t
+}
+
+// start waits for d in a new goroutine. t.mu must be held.
+func (t *clockTimer) start(d time.Duration) {
+	cancel := make(chan struct{})
+	t.cancel = cancel
+
+	// Wait on the clock now so FakeClock.Waiters counts it
+	fire, stop := after(t.clock, d)
+	t.stop = stop
+	go func() {
+		select {
+		case <-fire:
+			t.mu.Lock()
+			current := t.cancel == cancel
+			if current {
+				t.cancel = nil
+			}
+			t.mu.Unlock()
+
+			if current {
+				t.f()
+			}
+		case <-cancel:
+		}
+	}()
+}
+
+// Stop prevents f being called, reporting false if it already was.
+func (t *clockTimer) Stop() bool {
+	if t.real != nil {
+		return t.real.Stop()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.cancel == nil {
+		return false
+	}
+	close(t.cancel)
+	t.cancel = nil
+	t.stop()
+	return true
+}
+
+// Reset calls f once d has passed from now instead.
+func (t *clockTimer) Reset(d time.Duration) {
+	if t.real != nil {
+		t.real.Reset(d)
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.cancel != nil {
+		close(t.cancel)
+		t.stop()
+	}
+	t.start(d)
+}
+
+// FakeClock is a Clock which only moves when Advance is called. Sleeps and timers
+// wait until the clock has been advanced past their deadline.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	until time.Time
+	ch    chan time.Time
+}
+
+// NewFakeClock returns a FakeClock which starts at now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *FakeClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeWaiter{until: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves the clock forward by d, waking every sleep and timer which is due.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+
+	// Wake waiters in deadline order so they observe time moving forward
+	sort.SliceStable(c.waiters, func(i, j int) bool {
+		return c.waiters[i].until.Before(c.waiters[j].until)
+	})
+	remaining := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.until.After(c.now) {
+			remaining = append(remaining, w)
+			continue
+		}
+		w.ch <- w.until
+	}
+	c.waiters = remaining
+}
+
+// stop forgets the waiter for ch.
+func (c *FakeClock) stop(ch <-chan time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, w := range c.waiters {
+		if (<-chan time.Time)(w.ch) == ch {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// Waiters returns how many sleeps and timers are waiting on the clock, letting tests
+// check that badnet is blocked before advancing it.
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.waiters)
+}
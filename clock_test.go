@@ -0,0 +1,151 @@
+package badnet
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+
+	late := clock.After(2 * time.Minute)
+	early := clock.After(time.Minute)
+	require.Equal(t, 2, clock.Waiters())
+
+	select {
+	case now := <-clock.After(0):
+		require.Equal(t, start, now)
+	default:
+		t.Fatal("expected an immediate tick")
+	}
+
+	clock.Advance(90 * time.Second)
+	require.Equal(t, start.Add(time.Minute), <-early)
+	require.Equal(t, 1, clock.Waiters())
+	require.Equal(t, start.Add(90*time.Second), clock.Now())
+
+	select {
+	case <-late:
+		t.Fatal("woke too early")
+	default:
+	}
+
+	clock.Advance(time.Hour)
+	require.Equal(t, start.Add(2*time.Minute), <-late)
+	require.Zero(t, clock.Waiters())
+}
+
+func TestClockTimer(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	fired := make(chan struct{}, 2)
+	timer := afterFunc(clock, time.Minute, func() { fired <- struct{}{} })
+
+	// Resetting moves the deadline
+	clock.Advance(30 * time.Second)
+	timer.Reset(time.Minute)
+	clock.Advance(45 * time.Second)
+	require.Empty(t, fired)
+
+	clock.Advance(15 * time.Second)
+	select {
+	case <-fired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timer never fired")
+	}
+	require.False(t, timer.Stop())
+
+	// Stopped timers don't fire
+	timer.Reset(time.Minute)
+	require.True(t, timer.Stop())
+	clock.Advance(time.Hour)
+	time.Sleep(10 * time.Millisecond)
+	require.Empty(t, fired)
+}
+
+func TestFakeClockWaiters(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+
+	// Stopped and reset timers stop waiting on the clock
+	timer := afterFunc(clock, time.Minute, func() {})
+	timer.Reset(time.Minute)
+	timer.Reset(time.Minute)
+	require.Equal(t, 1, clock.Waiters())
+	require.True(t, timer.Stop())
+	require.Zero(t, clock.Waiters())
+
+	// So do sleeps which end early
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- sleep(ctx, clock, time.Minute, nil) }()
+	require.Eventually(t, func() bool {
+		return clock.Waiters() == 1
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+	require.Zero(t, clock.Waiters())
+
+	closing := make(chan struct{})
+	close(closing)
+	require.ErrorIs(t, sleep(context.Background(), clock, time.Minute, closing), net.ErrClosed)
+	require.Zero(t, clock.Waiters())
+}
+
+func TestFakeClockLatency(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	proxy := ForTest(t, Config{
+		Target: echoServer(t),
+		Write:  Direction{Latency: time.Hour},
+		Clock:  clock,
+	})
+
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+
+	// The echo is held until the hour passes
+	require.Eventually(t, func() bool { return clock.Waiters() == 1 }, 5*time.Second, 5*time.Millisecond)
+	clock.Advance(time.Hour)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+}
+
+func TestFakeClockBursts(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	proxy := ForTest(t, Config{
+		Target: echoServer(t),
+		Bursts: []Burst{
+			{AfterConnections: 1, Duration: time.Hour, Outage: true},
+		},
+		Clock: clock,
+	})
+
+	ping := func() error {
+		conn, err := net.Dial("tcp", proxy.BindAddr())
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		conn.Write([]byte("ping"))
+		_, err = io.ReadFull(conn, make([]byte, 4))
+		return err
+	}
+
+	require.Error(t, ping())
+	clock.Advance(time.Hour)
+	require.NoError(t, ping())
+}
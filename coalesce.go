@@ -0,0 +1,218 @@
+package badnet
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Coalesce holds back small reads or writes so they're sent together, like Nagle's
+// algorithm batching a connection's packets. Request/response protocols which send
+// many small messages see the latency batching adds.
+type Coalesce struct {
+	// Delay is the longest data is held back. Coalescing is off when it's zero.
+	Delay time.Duration
+
+	// Bytes sends what's been held early once this much has been collected.
+	Bytes int
+}
+
+func (c Coalesce) enabled() bool {
+	return c.Delay > 0
+}
+
+// full reports if n bytes of held data should be sent.
+func (c Coalesce) full(n int) bool {
+	return c.Bytes > 0 && n >= c.Bytes
+}
+
+// coalesceConn batches reads and writes according to a Coalesce in each direction.
+// Held writes are sent by a timer, and any error they hit is returned from the
+// next Write or Close.
+type coalesceConn struct {
+	net.Conn
+
+	read, write Coalesce
+	clock       Clock
+
+	// readDeadline is the caller's, which is restored after waiting for more data
+	deadlineMu   sync.Mutex
+	readDeadline time.Time
+
+	mu      sync.Mutex
+	pending []byte
+	timer   *clockTimer
+	err     error
+}
+
+// NetConn returns the underlying connection.
+func (c *coalesceConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func coalesce(c net.Conn, read, write Direction, clock Clock) net.Conn {
+	if !read.Coalesce.enabled() && !write.Coalesce.enabled() {
+		return c
+	}
+	return &coalesceConn{
+		Conn:  c,
+		read:  read.Coalesce,
+		write: write.Coalesce,
+		clock: clock,
+	}
+}
+
+// coalesceToxic batches reads and writes with each Direction's Coalesce.
+func coalesceToxic(read, write Direction) Toxic {
+	return ToxicFunc(func(c net.Conn, info ToxicInfo) net.Conn {
+		return coalesce(c, read, write, info.clock())
+	})
+}
+
+func (c *coalesceConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err != nil || !c.read.enabled() || n == len(b) || c.read.full(n) {
+		return n, err
+	}
+
+	// Keep reading until Delay passes, when the timer interrupts the read, unless
+	// the caller's deadline comes first
+	var mu sync.Mutex
+	var held, finished bool
+	timer := afterFunc(c.clock, c.read.Delay, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if !finished {
+			held = true
+			c.Conn.SetReadDeadline(aLongTimeAgo)
+		}
+	})
+	defer func() {
+		mu.Lock()
+		finished = true
+		mu.Unlock()
+		timer.Stop()
+
+		c.deadlineMu.Lock()
+		c.Conn.SetReadDeadline(c.readDeadline)
+		c.deadlineMu.Unlock()
+	}()
+
+	for n < len(b) && !c.read.full(n) {
+		m, err := c.Conn.Read(b[n:])
+		n += m
+		if err != nil {
+			mu.Lock()
+			if errors.Is(err, os.ErrDeadlineExceeded) && held {
+				err = nil
+			}
+			mu.Unlock()
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// aLongTimeAgo is a deadline in the past, which interrupts blocked reads.
+var aLongTimeAgo = time.Unix(1, 0)
+
+func (c *coalesceConn) SetDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	c.readDeadline = t
+	c.deadlineMu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *coalesceConn) SetReadDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	c.readDeadline = t
+	c.deadlineMu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *coalesceConn) Write(b []byte) (int, error) {
+	if !c.write.enabled() {
+		return c.Conn.Write(b)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return 0, c.err
+	}
+	c.pending = append(c.pending, b...)
+	if c.write.full(len(c.pending)) {
+		if c.timer != nil {
+			c.timer.Stop()
+			c.timer = nil
+		}
+		if err := c.flushLocked(); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if c.timer == nil {
+		c.timer = afterFunc(c.clock, c.write.Delay, c.flush)
+	}
+	return len(b), nil
+}
+
+// flush sends held writes once Delay has passed.
+func (c *coalesceConn) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.timer = nil
+	c.flushLocked()
+}
+
+func (c *coalesceConn) flushLocked() error {
+	if len(c.pending) == 0 || c.err != nil {
+		return c.err
+	}
+	_, c.err = c.Conn.Write(c.pending)
+	c.pending = c.pending[:0]
+	return c.err
+}
+
+// Close sends any held writes before closing.
+func (c *coalesceConn) Close() error {
+	c.flushNow()
+	return c.Conn.Close()
+}
+
+// CloseWrite sends any held writes before closing the write side.
+func (c *coalesceConn) CloseWrite() error {
+	if err := c.flushNow(); err != nil {
+		return err
+	}
+	return closeWrite(c.Conn)
+}
+
+// flushNow sends held writes without waiting for the timer.
+func (c *coalesceConn) flushNow() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	return c.flushLocked()
+}
+
+// setNagle turns Nagle's algorithm on for the TCP connection underneath c. Go
+// disables it by default by setting TCP_NODELAY.
+func setNagle(c net.Conn, enabled bool) error {
+	if !enabled {
+		return nil
+	}
+	tcp, ok := tcpConn(c)
+	if !ok {
+		return nil
+	}
+	return tcp.SetNoDelay(false)
+}
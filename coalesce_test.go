@@ -0,0 +1,96 @@
+package badnet
+
+import (
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoalesceWrites(t *testing.T) {
+	proxy := ForTest(t, Config{
+		Target: echoServer(t),
+		Write:  Direction{Coalesce: Coalesce{Delay: 100 * time.Millisecond}},
+	})
+
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	start := time.Now()
+	for _, msg := range []string{"a", "b", "c"} {
+		_, err := conn.Write([]byte(msg))
+		require.NoError(t, err)
+	}
+
+	// The echoes are held back and arrive together
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "abc", string(buf))
+	require.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+}
+
+func TestCoalesceBytes(t *testing.T) {
+	proxy := ForTest(t, Config{
+		Target: echoServer(t),
+		Read:   Direction{Coalesce: Coalesce{Delay: time.Hour, Bytes: 4}},
+	})
+
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("ab"))
+	require.NoError(t, err)
+
+	// Nothing is forwarded until enough has been collected
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err = conn.Read(make([]byte, 4))
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	_, err = conn.Write([]byte("cd"))
+	require.NoError(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "abcd", string(buf))
+}
+
+func TestCoalesceReadDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	c := coalesce(client, Direction{Coalesce: Coalesce{Delay: time.Hour}}, Direction{}, realClock{})
+	go server.Write([]byte("ab"))
+
+	// The caller's deadline still applies while data is held back
+	require.NoError(t, c.SetReadDeadline(time.Now().Add(50*time.Millisecond)))
+	buf := make([]byte, 4)
+	n, err := c.Read(buf)
+	require.Equal(t, "ab", string(buf[:n]))
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+func TestCoalesceClose(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	c := coalesce(client, Direction{}, Direction{Coalesce: Coalesce{Delay: time.Hour}}, realClock{})
+	n, err := c.Write([]byte("held"))
+	require.NoError(t, err)
+	require.Equal(t, 4, n)
+
+	// Closing sends what was held
+	go c.Close()
+	got, err := io.ReadAll(server)
+	require.NoError(t, err)
+	require.Equal(t, "held", string(got))
+}
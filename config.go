@@ -0,0 +1,212 @@
+package badnet
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// EffectiveConfig returns the configuration the proxy is actually running with.
+//
+// Defaults are filled in (e.g. the target port and BufferSize, and those of each
+// enabled fault), the listen address reflects the port that was bound, and any
+// runtime changes made to the Proxy are applied.
+func (p *Proxy) EffectiveConfig() Config {
+	conf := p.conf
+
+	conf.Listen = p.BindAddr()
+	if len(conf.ListenAddrs) > 0 {
+		conf.ListenAddrs = p.BindAddrs()[1:]
+	}
+	if conf.Target != "" {
+		conf.Target = p.conf.targetAddress()
+	}
+	if len(conf.Targets) > 0 {
+		conf.Targets = make([]string, len(p.conf.Targets))
+		for i, target := range p.conf.Targets {
+			conf.Targets[i] = targetAddress(target, p.conf.TargetTLS != nil)
+		}
+	}
+	if swapped := p.swappedTarget(); swapped != "" {
+		conf.Target, conf.Targets = swapped, nil
+	}
+
+	// SetBandwidth, SetLatency and SetRate change the links directly
+	conf.Read = p.throttles.read.current(conf.Read)
+	conf.Write = p.throttles.write.current(conf.Write)
+
+	return conf.withDefaults()
+}
+
+// ConfigDiff returns the differences between the Config given to ForTest and
+// the proxy's EffectiveConfig, including any BADNET_ environment overrides.
+func (p *Proxy) ConfigDiff() []string {
+	return DiffConfig(p.original.withDefaults(), p.EffectiveConfig())
+}
+
+// withDefaults returns c with the defaults the proxy runs with filled in for
+// settings left zero. Defaults of faults which aren't enabled are left zero.
+func (c Config) withDefaults() Config {
+	if c.ListenNetwork == "" {
+		c.ListenNetwork = defaultListenNetwork
+	}
+	if c.BufferSize <= 0 {
+		c.BufferSize = defaultBufferSize
+	}
+	c.Read, c.Write = c.Read.withDefaults(), c.Write.withDefaults()
+
+	if len(c.SNIRoutes) > 0 {
+		routes := make(map[string]SNIRoute, len(c.SNIRoutes))
+		for name, route := range c.SNIRoutes {
+			route.Read, route.Write = route.Read.withDefaults(), route.Write.withDefaults()
+			routes[name] = route
+		}
+		c.SNIRoutes = routes
+	}
+	if len(c.Clients) > 0 {
+		clients := make(map[string]ClientOptions, len(c.Clients))
+		for name, opts := range c.Clients {
+			opts.Read, opts.Write = withDefaults(opts.Read), withDefaults(opts.Write)
+			clients[name] = opts
+		}
+		c.Clients = clients
+	}
+	if len(c.TargetOptions) > 0 {
+		targets := make(map[string]TargetOptions, len(c.TargetOptions))
+		for name, opts := range c.TargetOptions {
+			opts.Read, opts.Write = withDefaults(opts.Read), withDefaults(opts.Write)
+			targets[name] = opts
+		}
+		c.TargetOptions = targets
+	}
+
+	if c.TargetPool.enabled() && c.TargetPool.IdleTimeout <= 0 {
+		c.TargetPool.IdleTimeout = defaultPoolIdleTimeout
+	}
+	if c.Tarpit.Ratio > 0 {
+		c.Tarpit.Bytes = max(c.Tarpit.Bytes, defaultTarpitBytes)
+		if c.Tarpit.Interval <= 0 {
+			c.Tarpit.Interval = defaultTarpitInterval
+		}
+		if len(c.Tarpit.Data) == 0 {
+			c.Tarpit.Data = []byte(defaultTarpitData)
+		}
+	}
+	if c.ConnectionStorm.Ratio > 0 {
+		c.ConnectionStorm.Count = max(c.ConnectionStorm.Count, defaultStormCount)
+	}
+	if c.ReorderResponses.enabled() {
+		c.ReorderResponses.Window = c.ReorderResponses.window()
+		c.ReorderResponses.Wait = c.ReorderResponses.wait()
+	}
+	return c
+}
+
+// withDefaults returns d with the defaults of its enabled faults filled in.
+func (d Direction) withDefaults() Direction {
+	if rate := d.rate(); rate > 0 && d.BandwidthBurst <= 0 {
+		d.BandwidthBurst = max(int(defaultBurst(rate)), 1)
+	}
+	if d.DuplicateRatio > 0 && d.DuplicateBytes <= 0 {
+		d.DuplicateBytes = defaultDuplicateBytes
+	}
+	if d.Garbage.Ratio > 0 && len(d.Garbage.Bytes) == 0 && d.Garbage.Length <= 0 {
+		d.Garbage.Length = defaultGarbageLength
+	}
+	if d.Datagrams.ReorderRatio > 0 {
+		d.Datagrams.ReorderWindow = d.Datagrams.reorderWindow()
+	}
+	return d
+}
+
+// withDefaults returns a copy of d with its defaults filled in, or nil.
+func withDefaults(d *Direction) *Direction {
+	if d == nil {
+		return nil
+	}
+	out := d.withDefaults()
+	return &out
+}
+
+// DiffConfig compares two configs and returns a sorted, human-readable line for
+// each field which differs, such as "Read.Latency: 0s -> 10ms".
+func DiffConfig(a, b Config) []string {
+	var out []string
+	diffValues("", reflect.ValueOf(a), reflect.ValueOf(b), &out)
+	sort.Strings(out)
+	return out
+}
+
+func diffValues(path string, a, b reflect.Value, out *[]string) {
+	switch a.Kind() {
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			field := a.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name := field.Name
+			if path != "" {
+				name = path + "." + name
+			}
+			diffValues(name, a.Field(i), b.Field(i), out)
+		}
+		return
+
+	case reflect.Func:
+		// Functions can only be compared against nil
+		if a.IsNil() != b.IsNil() {
+			*out = append(*out, fmt.Sprintf("%s: %s -> %s", path, describeFunc(a), describeFunc(b)))
+		}
+		return
+
+	case reflect.Interface:
+		// Values are compared by type, then by their fields, as they may hold
+		// functions such as a MetricsSink or Toxic
+		switch {
+		case a.IsNil() && b.IsNil():
+		case a.IsNil() || b.IsNil() || a.Elem().Type() != b.Elem().Type():
+			*out = append(*out, fmt.Sprintf("%s: %s -> %s", path, describeType(a), describeType(b)))
+		default:
+			diffValues(path, a.Elem(), b.Elem(), out)
+		}
+		return
+
+	case reflect.Slice:
+		if a.Len() == b.Len() && a.IsNil() == b.IsNil() {
+			for i := 0; i < a.Len(); i++ {
+				diffValues(fmt.Sprintf("%s[%d]", path, i), a.Index(i), b.Index(i), out)
+			}
+			return
+		}
+		if k := a.Type().Elem().Kind(); k == reflect.Interface || k == reflect.Func {
+			// Only the addresses of functions could be printed
+			*out = append(*out, fmt.Sprintf("%s: %d values -> %d values", path, a.Len(), b.Len()))
+			return
+		}
+
+	case reflect.Pointer:
+		// The same pointer is always equal, even if what it points to holds functions
+		if a.Pointer() == b.Pointer() {
+			return
+		}
+	}
+
+	if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+		*out = append(*out, fmt.Sprintf("%s: %v -> %v", path, a.Interface(), b.Interface()))
+	}
+}
+
+func describeType(v reflect.Value) string {
+	if v.IsNil() {
+		return "<nil>"
+	}
+	return v.Elem().Type().String()
+}
+
+func describeFunc(v reflect.Value) string {
+	if v.IsNil() {
+		return "<nil>"
+	}
+	return "<func>"
+}
@@ -0,0 +1,120 @@
+package badnet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEffectiveConfig(t *testing.T) {
+	proxy := ForTest(t, Config{
+		Listen: "127.0.0.1:0",
+		Target: "http://example.com",
+		Read:   Direction{Latency: 10 * time.Millisecond},
+	})
+
+	conf := proxy.EffectiveConfig()
+	require.Equal(t, proxy.BindAddr(), conf.Listen)
+	require.Equal(t, "example.com:80", conf.Target)
+	require.Equal(t, 10*time.Millisecond, conf.Read.Latency)
+
+	diff := proxy.ConfigDiff()
+	require.Len(t, diff, 2)
+	require.Equal(t, "Listen: 127.0.0.1:0 -> "+proxy.BindAddr(), diff[0])
+	require.Equal(t, "Target: http://example.com -> example.com:80", diff[1])
+}
+
+func TestEffectiveConfigDefaults(t *testing.T) {
+	proxy := ForTest(t, Config{
+		Listen:           "127.0.0.1:0",
+		Target:           "127.0.0.1:80",
+		IgnoreEnv:        true,
+		Read:             Direction{MaxKBps: 10, DuplicateRatio: 1},
+		Write:            Direction{Garbage: Garbage{Ratio: 1}, Datagrams: Datagrams{ReorderRatio: 1}},
+		TargetPool:       TargetPool{Size: 2},
+		ReorderResponses: ResponseReorder{Ratio: 1},
+	})
+
+	conf := proxy.EffectiveConfig()
+	require.Equal(t, "tcp", conf.ListenNetwork)
+	require.Equal(t, defaultBufferSize, conf.BufferSize)
+	require.Equal(t, 1024, conf.Read.BandwidthBurst)
+	require.Equal(t, defaultDuplicateBytes, conf.Read.DuplicateBytes)
+	require.Equal(t, defaultGarbageLength, conf.Write.Garbage.Length)
+	require.Equal(t, defaultReorderWindow, conf.Write.Datagrams.ReorderWindow)
+	require.Equal(t, defaultPoolIdleTimeout, conf.TargetPool.IdleTimeout)
+	require.Equal(t, defaultReorderResponseWindow, conf.ReorderResponses.Window)
+	require.Equal(t, defaultReorderResponseWait, conf.ReorderResponses.Wait)
+
+	// Faults which aren't enabled keep their zero values
+	require.Zero(t, conf.Write.BandwidthBurst)
+	require.Zero(t, conf.Tarpit.Interval)
+
+	// and the defaults aren't reported as changes
+	require.Equal(t, []string{"Listen: 127.0.0.1:0 -> " + proxy.BindAddr()}, proxy.ConfigDiff())
+}
+
+func TestEffectiveConfigRuntimeChanges(t *testing.T) {
+	proxy := ForTest(t, Config{
+		Listen:    "127.0.0.1:0",
+		Target:    "127.0.0.1:80",
+		IgnoreEnv: true,
+		Read:      Direction{MaxKBps: 10, Latency: time.Millisecond},
+		Write:     Direction{Ramp: Ramp{From: Kbit, To: Mbit, Duration: time.Minute}},
+	})
+
+	proxy.SetLatency(50*time.Millisecond, 0)
+	proxy.SetRate(Mbit, 0)
+	conf := proxy.EffectiveConfig()
+	require.Equal(t, 50*time.Millisecond, conf.Read.Latency)
+	require.Equal(t, Mbit, conf.Read.Bandwidth)
+	require.Zero(t, conf.Read.MaxKBps)
+	require.Zero(t, conf.Write.Bandwidth)
+	require.False(t, conf.Write.Ramp.enabled())
+
+	proxy.SetBandwidth(5, 0)
+	conf = proxy.EffectiveConfig()
+	require.Equal(t, 5, conf.Read.MaxKBps)
+	require.Zero(t, conf.Read.Bandwidth)
+	require.Contains(t, proxy.ConfigDiff(), "Read.MaxKBps: 10 -> 5")
+}
+
+func TestConfigDiffEnv(t *testing.T) {
+	t.Setenv("BADNET_READ_LATENCY", "10ms")
+
+	proxy := ForTest(t, Config{
+		Listen: "127.0.0.1:0",
+		Target: "127.0.0.1:80",
+	})
+	require.Equal(t, []string{
+		"Listen: 127.0.0.1:0 -> " + proxy.BindAddr(),
+		"Read.Latency: 0s -> 10ms",
+	}, proxy.ConfigDiff())
+}
+
+func TestDiffConfig(t *testing.T) {
+	a := Config{Read: Direction{FailureRatio: 10}}
+	b := Config{Read: Direction{FailureRatio: 20}, Write: Direction{MaxKBps: 5}}
+
+	require.Equal(t, []string{
+		"Read.FailureRatio: 10 -> 20",
+		"Write.MaxKBps: 0 -> 5",
+	}, DiffConfig(a, b))
+
+	require.Empty(t, DiffConfig(a, a))
+
+	// Functions held in interfaces don't make a config differ from itself
+	sinks := Config{
+		Metrics: PrometheusSink(func(string) {}, nil),
+		Toxics:  []Toxic{RedisError(10, RedisBusy)},
+		Clock:   NewFakeClock(time.Now()),
+	}
+	require.Empty(t, DiffConfig(sinks, sinks))
+
+	require.Equal(t, []string{
+		"Clock: *badnet.FakeClock -> <nil>",
+		"Metrics: badnet.SinkFuncs -> <nil>",
+		"Toxics: 1 values -> 0 values",
+	}, DiffConfig(sinks, Config{Toxics: []Toxic{}}))
+}
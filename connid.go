@@ -0,0 +1,91 @@
+package badnet
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+)
+
+// ConnectionID returns the ID of the proxied connection ctx belongs to, such as the
+// context given to Config.ConnContext or ToxicInfo.Context, or empty when there is
+// none.
+//
+// IDs look like "3f9a0c12-7": a prefix unique to each Proxy and the connection's
+// Number, which is also used by ConnectionInfo and TraceEvent.
+func ConnectionID(ctx context.Context) string {
+	id, _ := ctx.Value(connectionIDKey{}).(string)
+	return id
+}
+
+type connectionIDKey struct{}
+
+// newProxyID returns a random prefix for a Proxy's connection IDs, so connections
+// from different proxies in the same test run can be told apart.
+func newProxyID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (p *Proxy) connectionID(number uint32) string {
+	return fmt.Sprintf("%s-%d", p.id, number)
+}
+
+// connectionIDToxic adds the header with the connection's ID to each HTTP request
+// read from clients.
+func connectionIDToxic(header string) Toxic {
+	if header == "" {
+		return nil
+	}
+	return ToxicFunc(func(c net.Conn, info ToxicInfo) net.Conn {
+		return &connectionIDConn{Conn: c, header: []byte(header + ": " + info.ID + "\r\n")}
+	})
+}
+
+// connectionIDConn inserts header after the request line of each request it reads.
+type connectionIDConn struct {
+	net.Conn
+
+	header []byte
+
+	// pending holds data which didn't fit in the caller's buffer.
+	pending []byte
+}
+
+// NetConn returns the underlying connection.
+func (c *connectionIDConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *connectionIDConn) Read(b []byte) (int, error) {
+	if len(c.pending) > 0 {
+		n := copy(b, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+
+	n, err := c.Conn.Read(b)
+	if n == 0 || !isRequestStart(b[:n]) {
+		return n, err
+	}
+	line := bytes.IndexByte(b[:n], '\n') + 1
+	if line == 0 {
+		return n, err
+	}
+
+	out := make([]byte, 0, n+len(c.header))
+	out = append(out, b[:line]...)
+	out = append(out, c.header...)
+	out = append(out, b[line:n]...)
+
+	copied := copy(b, out)
+	c.pending = out[copied:]
+	if len(c.pending) > 0 {
+		// Errors are returned again by the read after pending is drained
+		err = nil
+	}
+	return copied, err
+}
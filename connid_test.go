@@ -0,0 +1,67 @@
+package badnet
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectionID(t *testing.T) {
+	seen := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- r.Header.Get("X-Badnet-Connection")
+	}))
+	t.Cleanup(server.Close)
+
+	hooked := make(chan string, 2)
+	proxy := ForHTTPTest(t, server, Config{
+		ConnectionIDHeader: "X-Badnet-Connection",
+		LogConnections:     true,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			hooked <- ConnectionID(ctx)
+			return ctx
+		},
+	})
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(proxy.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	conns := proxy.Connections()
+	require.Len(t, conns, 2)
+	require.NotEqual(t, conns[0].ID, conns[1].ID)
+	for _, info := range conns {
+		require.True(t, strings.HasSuffix(info.ID, fmt.Sprintf("-%d", info.Number)))
+		require.Equal(t, info.ID, <-seen)
+		require.Equal(t, info.ID, <-hooked)
+	}
+}
+
+func TestConnectionIDConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	c := connectionIDToxic("X-Id").Wrap(server, ToxicInfo{ID: "abc-1"})
+	defer c.Close()
+
+	go client.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+
+	// Reads which only fit the request line still see the whole request
+	var got []byte
+	buf := make([]byte, 16)
+	for !strings.HasSuffix(string(got), "\r\n\r\n") {
+		n, err := c.Read(buf)
+		require.NoError(t, err)
+		got = append(got, buf[:n]...)
+	}
+	require.Equal(t, "GET / HTTP/1.1\r\nX-Id: abc-1\r\nHost: example.com\r\n\r\n", string(got))
+}
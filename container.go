@@ -0,0 +1,82 @@
+package badnet
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Container is the part of a testcontainers-go container which ForContainer uses.
+// Containers must also have a MappedPort(ctx, nat.Port) (nat.Port, error) method,
+// which is called through reflection so badnet doesn't depend on Docker's packages.
+type Container interface {
+	Host(ctx context.Context) (string, error)
+}
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// containerTimeout bounds how long ForContainer waits on the container's mapped port.
+const containerTimeout = 30 * time.Second
+
+// ForContainer starts a proxy in front of a started testcontainers-go container's
+// port, such as "5432/tcp", and returns it once it's ready. Connect to the
+// container through the proxy's BindAddr instead of the container's own endpoint.
+// Listen defaults to 127.0.0.1:0 and Target is set to the container.
+//
+//	pg, _ := postgres.Run(ctx, "postgres:16")
+//	proxy := badnet.ForContainer(t, pg, "5432/tcp", badnet.Config{
+//	    Write: badnet.Direction{Latency: 50 * time.Millisecond},
+//	})
+//	dsn := fmt.Sprintf("postgres://postgres:postgres@%s/postgres", proxy.BindAddr())
+func ForContainer(t testing.TB, container Container, port string, conf Config) *Proxy {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), containerTimeout)
+	defer cancel()
+
+	target, err := containerEndpoint(ctx, container, port)
+	if err != nil {
+		t.Fatalf("badnet: %v", err)
+	}
+
+	if conf.Listen == "" {
+		conf.Listen = "127.0.0.1:0"
+	}
+	conf.Target = target
+
+	return ForTest(t, conf)
+}
+
+// containerEndpoint returns the host:port a container's port is mapped to.
+func containerEndpoint(ctx context.Context, container Container, port string) (string, error) {
+	host, err := container.Host(ctx)
+	if err != nil {
+		return "", fmt.Errorf("reading container host: %w", err)
+	}
+
+	method := reflect.ValueOf(container).MethodByName("MappedPort")
+	if !method.IsValid() {
+		return "", fmt.Errorf("%T has no MappedPort method", container)
+	}
+	mtype := method.Type()
+	if mtype.NumIn() != 2 || mtype.In(0) != contextType || mtype.In(1).Kind() != reflect.String ||
+		mtype.NumOut() != 2 || mtype.Out(0).Kind() != reflect.String || mtype.Out(1) != errorType {
+		return "", fmt.Errorf("%T.MappedPort has an unexpected signature: %v", container, mtype)
+	}
+
+	out := method.Call([]reflect.Value{
+		reflect.ValueOf(ctx),
+		reflect.ValueOf(port).Convert(mtype.In(1)),
+	})
+	if err, _ := out[1].Interface().(error); err != nil {
+		return "", fmt.Errorf("reading mapped port %s: %w", port, err)
+	}
+
+	// Ports are formatted like 32768/tcp
+	mapped, _, _ := strings.Cut(out[0].String(), "/")
+	return net.JoinHostPort(host, mapped), nil
+}
@@ -0,0 +1,70 @@
+package badnet
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// natPort mirrors nat.Port from github.com/docker/go-connections
+type natPort string
+
+type fakeContainer struct {
+	host  string
+	ports map[natPort]natPort
+}
+
+func (c *fakeContainer) Host(ctx context.Context) (string, error) {
+	return c.host, nil
+}
+
+func (c *fakeContainer) MappedPort(ctx context.Context, port natPort) (natPort, error) {
+	if mapped, ok := c.ports[port]; ok {
+		return mapped, nil
+	}
+	return "", errors.New("port not found")
+}
+
+type hostOnlyContainer struct{}
+
+func (hostOnlyContainer) Host(ctx context.Context) (string, error) {
+	return "localhost", nil
+}
+
+func TestForContainer(t *testing.T) {
+	host, port, err := net.SplitHostPort(echoServer(t))
+	require.NoError(t, err)
+
+	container := &fakeContainer{
+		host:  host,
+		ports: map[natPort]natPort{"6379/tcp": natPort(port + "/tcp")},
+	}
+	proxy := ForContainer(t, container, "6379/tcp", Config{})
+
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("PING"))
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "PING", string(buf))
+}
+
+func TestContainerEndpoint(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := containerEndpoint(ctx, &fakeContainer{host: "localhost"}, "5432/tcp")
+	require.ErrorContains(t, err, "port not found")
+
+	_, err = containerEndpoint(ctx, hostOnlyContainer{}, "5432/tcp")
+	require.ErrorContains(t, err, "no MappedPort method")
+}
@@ -0,0 +1,176 @@
+package badnet
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Resolution controls how often the proxy resolves target hostnames.
+type Resolution int
+
+const (
+	// ResolveEveryConnection looks up the target's hostname for each connection.
+	ResolveEveryConnection Resolution = iota
+
+	// ResolveOnce looks up each target hostname once and reuses the address for the
+	// lifetime of the proxy.
+	ResolveOnce
+)
+
+// HostResolver looks up the addresses of a host. It's implemented by *net.Resolver
+// and *badnet.Resolver.
+type HostResolver interface {
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+}
+
+// targetDialer connects to targets on behalf of the proxy.
+type targetDialer struct {
+	dnsLatency time.Duration
+	dnsJitter  time.Duration
+
+	dialLatency      time.Duration
+	dialJitter       time.Duration
+	dialFailureRatio int
+
+	resolution Resolution
+	resolver   HostResolver
+	onResolved func(target string, ip net.IP)
+
+	clock Clock
+
+	mu     sync.Mutex
+	cached map[string][]net.IP
+}
+
+func newTargetDialer(conf Config) *targetDialer {
+	d := &targetDialer{
+		dnsLatency: conf.DNSLatency,
+		dnsJitter:  conf.DNSJitter,
+
+		dialLatency:      conf.DialLatency,
+		dialJitter:       conf.DialJitter,
+		dialFailureRatio: conf.DialFailureRatio,
+
+		resolution: conf.TargetResolution,
+		resolver:   conf.TargetResolver,
+		onResolved: conf.OnTargetResolved,
+		cached:     make(map[string][]net.IP),
+		clock:      conf.clock(),
+	}
+	if d.resolver == nil {
+		d.resolver = net.DefaultResolver
+	}
+	return d
+}
+
+func (d *targetDialer) dial(ctx context.Context, address string, tlsConfig *tls.Config) (net.Conn, error) {
+	if d.dialLatency > 0 || d.dialJitter > 0 {
+		if err := sleep(ctx, d.clock, withJitter(d.dialLatency, d.dialJitter), nil); err != nil {
+			return nil, err
+		}
+	}
+	if shouldFail(d.dialFailureRatio) {
+		return nil, fmt.Errorf("dial %s: %w", address, syscall.ECONNREFUSED)
+	}
+
+	var dialer net.Dialer
+	if path, ok := unixSocketPath(address); ok {
+		conn, err := dialer.DialContext(ctx, "unix", path)
+		if err != nil || tlsConfig == nil {
+			return conn, err
+		}
+		return handshakeTarget(ctx, conn, tlsConfig, "")
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := d.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var conn net.Conn
+	for _, ip := range ips {
+		conn, err = dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			if d.onResolved != nil {
+				d.onResolved(address, ip)
+			}
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsConfig == nil {
+		return conn, nil
+	}
+	return handshakeTarget(ctx, conn, tlsConfig, host)
+}
+
+func handshakeTarget(ctx context.Context, conn net.Conn, tlsConfig *tls.Config, serverName string) (net.Conn, error) {
+	tlsConfig = tlsConfig.Clone()
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = serverName
+	}
+	tc := tls.Client(conn, tlsConfig)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+// unixSocketPath returns the socket path of a unix:// target.
+func unixSocketPath(target string) (string, bool) {
+	path, ok := strings.CutPrefix(target, "unix://")
+	return path, ok && path != ""
+}
+
+func (d *targetDialer) resolve(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	if d.resolution == ResolveOnce {
+		d.mu.Lock()
+		ips, ok := d.cached[host]
+		d.mu.Unlock()
+		if ok {
+			return ips, nil
+		}
+	}
+
+	if err := d.resolveDelay(ctx); err != nil {
+		return nil, err
+	}
+
+	ips, err := d.resolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no addresses found for %s", host)
+	}
+
+	if d.resolution == ResolveOnce {
+		d.mu.Lock()
+		d.cached[host] = ips
+		d.mu.Unlock()
+	}
+	return ips, nil
+}
+
+// resolveDelay simulates a slow DNS resolver before hostnames are dialed.
+func (d *targetDialer) resolveDelay(ctx context.Context) error {
+	return sleep(ctx, d.clock, withJitter(d.dnsLatency, d.dnsJitter), nil)
+}
@@ -0,0 +1,196 @@
+package badnet
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSLatency(t *testing.T) {
+	_, port, err := net.SplitHostPort(echoServer(t))
+	require.NoError(t, err)
+
+	ping := func(proxy *Proxy) time.Duration {
+		t.Helper()
+
+		start := time.Now()
+		conn, err := net.Dial("tcp", proxy.BindAddr())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+		_, err = io.ReadFull(conn, make([]byte, 4))
+		require.NoError(t, err)
+
+		return time.Since(start)
+	}
+
+	hostname := ForTest(t, Config{
+		Listen:     "127.0.0.1:0",
+		Target:     net.JoinHostPort("localhost", port),
+		DNSLatency: 100 * time.Millisecond,
+		DNSJitter:  50 * time.Millisecond,
+	})
+	require.GreaterOrEqual(t, ping(hostname), 100*time.Millisecond)
+
+	ip := ForTest(t, Config{
+		Listen:     "127.0.0.1:0",
+		Target:     net.JoinHostPort("127.0.0.1", port),
+		DNSLatency: time.Second,
+	})
+	require.Less(t, ping(ip), time.Second)
+}
+
+type countingResolver struct {
+	lookups atomic.Int32
+}
+
+func (r *countingResolver) LookupIP(_ context.Context, _, _ string) ([]net.IP, error) {
+	r.lookups.Add(1)
+	return []net.IP{net.ParseIP("127.0.0.1")}, nil
+}
+
+func TestTargetResolution(t *testing.T) {
+	_, port, err := net.SplitHostPort(echoServer(t))
+	require.NoError(t, err)
+
+	cases := map[Resolution]int32{
+		ResolveEveryConnection: 3,
+		ResolveOnce:            1,
+	}
+	for resolution, lookups := range cases {
+		resolver := &countingResolver{}
+		var used []string
+
+		proxy := ForTest(t, Config{
+			Listen:           "127.0.0.1:0",
+			Target:           net.JoinHostPort("service.test", port),
+			TargetResolution: resolution,
+			TargetResolver:   resolver,
+			OnTargetResolved: func(_ string, ip net.IP) {
+				used = append(used, ip.String())
+			},
+		})
+
+		for i := 0; i < 3; i++ {
+			conn, err := net.Dial("tcp", proxy.BindAddr())
+			require.NoError(t, err)
+
+			_, err = conn.Write([]byte("ping"))
+			require.NoError(t, err)
+			_, err = io.ReadFull(conn, make([]byte, 4))
+			require.NoError(t, err)
+			require.NoError(t, conn.Close())
+		}
+
+		require.Equal(t, lookups, resolver.lookups.Load())
+		require.Equal(t, []string{"127.0.0.1", "127.0.0.1", "127.0.0.1"}, used)
+	}
+}
+
+func TestUnixSocketTarget(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badnet")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "app.sock")
+	ln, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	proxy := ForTest(t, Config{
+		Listen: "127.0.0.1:0",
+		Target: "unix://" + path,
+	})
+
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+}
+
+func TestDialFaults(t *testing.T) {
+	target := echoServer(t)
+
+	ping := func(proxy *Proxy) error {
+		conn, err := net.Dial("tcp", proxy.BindAddr())
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			return err
+		}
+		_, err = io.ReadFull(conn, make([]byte, 4))
+		return err
+	}
+
+	t.Run("DialFailureRatio", func(t *testing.T) {
+		proxy := ForTest(t, Config{
+			Listen:           "127.0.0.1:0",
+			Target:           target,
+			DialFailureRatio: 100,
+		})
+		require.Error(t, ping(proxy))
+		require.Error(t, ping(proxy))
+		require.Equal(t, uint32(2), proxy.targetFailures.Load())
+	})
+
+	t.Run("DialLatency", func(t *testing.T) {
+		proxy := ForTest(t, Config{
+			Listen:      "127.0.0.1:0",
+			Target:      target,
+			DialLatency: 100 * time.Millisecond,
+			DialJitter:  10 * time.Millisecond,
+		})
+		start := time.Now()
+		require.NoError(t, ping(proxy))
+		require.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		// Neither the dial or DNS latency outlast the context
+		for _, conf := range []Config{
+			{DialLatency: 20 * time.Second},
+			{DNSLatency: 20 * time.Second},
+		} {
+			start := time.Now()
+			_, err := newTargetDialer(conf).dial(ctx, "localhost:1", nil)
+			require.ErrorIs(t, err, context.DeadlineExceeded)
+			require.Less(t, time.Since(start), 5*time.Second)
+		}
+	})
+}
@@ -0,0 +1,201 @@
+package badnet
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Dialer originates connections toward a server under test with faults applied. It is
+// the reverse orientation of a Proxy: badnet plays the flaky client so server-side
+// robustness can be exercised with the same fault engine.
+//
+// Read applies to data the client reads from the server and Write applies to data
+// the client sends to the server. Besides them, only Target, TargetTLS, Network,
+// Toxics, FailFirstConnections, FailConnectionsAfter, TargetKeepAlive, TargetNagle,
+// Clock, IgnoreEnv and Metrics apply, and DialerForTest fails the test when anything
+// else is set. Config.Listen is ignored.
+type Dialer struct {
+	conf Config
+
+	mu    sync.Mutex
+	conns map[*dialedConn]struct{}
+
+	// closing is closed when the test finishes, ending any waits
+	closing chan struct{}
+
+	throttles
+
+	stats
+}
+
+// DialerForTest returns a Dialer whose connections are closed when the test finishes.
+func DialerForTest(t testing.TB, conf Config) *Dialer {
+	t.Helper()
+
+	if unsupported := dialerUnsupported(conf); len(unsupported) > 0 {
+		t.Fatalf("badnet: Dialer doesn't apply %s", strings.Join(unsupported, ", "))
+	}
+	conf = conf.Network.apply(conf, true)
+	if !conf.IgnoreEnv {
+		var err error
+		conf, err = ApplyEnv(conf)
+		if err != nil {
+			t.Fatalf("badnet: %v", err)
+		}
+	}
+
+	d := &Dialer{
+		conf:    conf,
+		conns:   make(map[*dialedConn]struct{}),
+		closing: make(chan struct{}),
+	}
+	d.metrics = conf.Metrics
+	d.throttles = newThrottles(conf)
+
+	t.Cleanup(func() {
+		close(d.closing)
+
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		for c := range d.conns {
+			c.Conn.Close()
+		}
+	})
+
+	return d
+}
+
+// dialerUnsupported returns the names of settings in conf which a Dialer ignores.
+func dialerUnsupported(conf Config) []string {
+	supported := Config{
+		Listen:               conf.Listen,
+		Target:               conf.Target,
+		TargetTLS:            conf.TargetTLS,
+		Read:                 conf.Read,
+		Write:                conf.Write,
+		Network:              conf.Network,
+		Toxics:               conf.Toxics,
+		FailFirstConnections: conf.FailFirstConnections,
+		FailConnectionsAfter: conf.FailConnectionsAfter,
+		TargetKeepAlive:      conf.TargetKeepAlive,
+		TargetNagle:          conf.TargetNagle,
+		Clock:                conf.Clock,
+		IgnoreEnv:            conf.IgnoreEnv,
+		Metrics:              conf.Metrics,
+	}
+	var names []string
+	for _, diff := range DiffConfig(supported, conf) {
+		// Only name the top level setting, such as TLSHandshake for TLSHandshake.Stall
+		name := diff[:strings.IndexAny(diff, ".:[")]
+		if len(names) == 0 || names[len(names)-1] != name {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Dial connects to Config.Target.
+func (d *Dialer) Dial(ctx context.Context) (net.Conn, error) {
+	if path, ok := unixSocketPath(d.conf.Target); ok {
+		return d.DialContext(ctx, "unix", path)
+	}
+	return d.DialContext(ctx, "tcp", d.conf.targetAddress())
+}
+
+// DialContext connects to the given address and is suitable for clients which accept
+// a custom dial function. Config.TargetTLS is negotiated over the faulty connection.
+// Unlike net.Dialer, ctx is kept by the connection's faults, so canceling it ends
+// any waits for latency or bandwidth with an error.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	c, err := d.dial(ctx, network, address)
+	if err != nil || d.conf.TargetTLS == nil {
+		return c, err
+	}
+
+	tlsConfig := d.conf.TargetTLS.Clone()
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName, _, _ = net.SplitHostPort(address)
+	}
+	return tls.Client(c, tlsConfig), nil
+}
+
+func (d *Dialer) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	count := d.connectionCount.Add(1)
+	d.incCounter(MetricConnections)
+
+	if d.conf.refuseConnection(count) {
+		d.connectionRefused()
+		return nil, fmt.Errorf("badnet: dialing %s: %w", address, syscall.ECONNREFUSED)
+	}
+
+	start := time.Now()
+	var dialer net.Dialer
+	c, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		d.targetFailures.Add(1)
+		d.incCounter(MetricTargetFailures)
+		return nil, fmt.Errorf("badnet: dialing %s: %w", address, err)
+	}
+	d.targetDialed(time.Since(start))
+	d.conf.TargetKeepAlive.apply(c)
+	setNagle(c, d.conf.TargetNagle)
+
+	tracked := &dialedConn{Conn: c, d: d}
+	d.mu.Lock()
+	d.conns[tracked] = struct{}{}
+	d.mu.Unlock()
+
+	info := ToxicInfo{
+		Context:    ctx,
+		Number:     count,
+		RemoteAddr: c.RemoteAddr(),
+		Clock:      d.conf.clock(),
+	}
+	toxics := append([]Toxic{
+		mtuToxic(d.conf.Read, d.conf.Write),
+		coalesceToxic(d.conf.Read, d.conf.Write),
+		d.bandwidthToxic(d.read, d.write, d.conf.clock(), d.closing),
+		d.trickleToxic(d.conf.Read, d.conf.Write, d.conf.clock()),
+		d.squeezeToxic(d.conf.Read, d.conf.Write, d.conf.clock()),
+		d.faultToxic(d.conf.Read, d.conf.Write, nil, nil, nil),
+	}, directionToxics(d.conf.Read, d.conf.Write, nil)...)
+	toxics = append(toxics, d.conf.Toxics...)
+	return applyToxics(tracked, info, toxics...), nil
+}
+
+// HTTPClient returns an http.Client which makes every connection through the Dialer.
+func (d *Dialer) HTTPClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			// Connections outlive the request which dialed them
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return d.dial(context.WithoutCancel(ctx), network, address)
+			},
+			TLSClientConfig: d.conf.TargetTLS,
+		},
+	}
+}
+
+// dialedConn removes itself from the Dialer's open connections once closed.
+type dialedConn struct {
+	net.Conn
+
+	d *Dialer
+}
+
+func (c *dialedConn) Close() error {
+	c.d.mu.Lock()
+	delete(c.d.conns, c)
+	c.d.mu.Unlock()
+
+	return c.Conn.Close()
+}
@@ -0,0 +1,84 @@
+package badnet
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialer(t *testing.T) {
+	t.Run("healthy", func(t *testing.T) {
+		dialer := DialerForTest(t, Config{
+			Target: echoServer(t),
+		})
+
+		conn, err := dialer.Dial(context.Background())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		require.Equal(t, "ping", string(buf))
+		require.Equal(t, float64(0), dialer.FailureRatio())
+	})
+
+	t.Run("failing writes", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Write([]byte("PONG"))
+		}))
+		t.Cleanup(server.Close)
+
+		dialer := DialerForTest(t, Config{
+			Write: Direction{FailureRatio: 100},
+		})
+		client := dialer.HTTPClient()
+
+		_, err := client.Get(server.URL)
+		require.Error(t, err)
+		require.Greater(t, dialer.FailureRatio(), 0.0)
+	})
+	t.Run("canceled", func(t *testing.T) {
+		dialer := DialerForTest(t, Config{
+			Target: echoServer(t),
+			Write:  Direction{Latency: time.Minute},
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		conn, err := dialer.Dial(ctx)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		// Canceling the dial's context ends the wait for latency
+		time.AfterFunc(10*time.Millisecond, cancel)
+		start := time.Now()
+		_, err = conn.Write([]byte("ping"))
+		require.ErrorIs(t, err, context.Canceled)
+		require.Less(t, time.Since(start), 5*time.Second)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		require.Empty(t, dialerUnsupported(Config{
+			Target:  "127.0.0.1:1",
+			Read:    Direction{Latency: time.Second},
+			Network: Network{Loss: 0.1},
+		}))
+
+		unsupported := dialerUnsupported(Config{
+			Target:           "127.0.0.1:1",
+			Bursts:           []Burst{{FailureRatio: 10}},
+			IdleTimeout:      time.Minute,
+			DialLatency:      time.Second,
+			TLSHandshake:     TLSHandshakeFaults{StallRatio: 10},
+			MaxConnectionAge: time.Minute,
+		})
+		require.Equal(t, []string{"Bursts", "DialLatency", "IdleTimeout", "MaxConnectionAge", "TLSHandshake"}, unsupported)
+	})
+}
@@ -0,0 +1,119 @@
+package badnet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// dsnPorts are the default ports of the datastores ProxyDSN understands, used when
+// a DSN leaves the port out.
+var dsnPorts = map[string]string{
+	"postgres":   "5432",
+	"postgresql": "5432",
+	"mysql":      "3306",
+	"redis":      "6379",
+	"rediss":     "6379",
+	"amqp":       "5672",
+	"amqps":      "5671",
+}
+
+// ProxyDSN starts a proxy in front of the datastore a DSN points at and returns the
+// DSN rewritten to connect through it. URLs like postgres://, mysql://, redis:// and
+// amqp:// are supported, as are go-sql-driver/mysql DSNs like user:pass@tcp(host:port)/db.
+// Everything besides the host and port is left as it was.
+// Listen defaults to 127.0.0.1:0 and Target is set to the datastore.
+//
+//	dsn := badnet.ProxyDSN(t, os.Getenv("DATABASE_URL"), badnet.Config{
+//	    Read: badnet.Direction{Latency: 20 * time.Millisecond},
+//	})
+//	db, err := sql.Open("pgx", dsn)
+func ProxyDSN(t testing.TB, dsn string, conf Config) string {
+	t.Helper()
+
+	target, rewrite, err := parseDSN(dsn)
+	if err != nil {
+		t.Fatalf("badnet: %v", err)
+	}
+
+	if conf.Listen == "" {
+		conf.Listen = "127.0.0.1:0"
+	}
+	conf.Target = target
+
+	proxy := ForTest(t, conf)
+	return rewrite(proxy.BindAddr())
+}
+
+// parseDSN returns the host:port a DSN connects to and a func which replaces it
+// with another address.
+func parseDSN(dsn string) (string, func(addr string) string, error) {
+	// go-sql-driver/mysql wraps the address in tcp(...)
+	if start := strings.Index(dsn, "tcp("); start >= 0 && !strings.Contains(dsn[:start], "://") {
+		start += len("tcp(")
+		end := strings.IndexByte(dsn[start:], ')')
+		if end < 0 {
+			return "", nil, fmt.Errorf("parsing DSN: unterminated tcp(")
+		}
+		end += start
+
+		target, err := withDefaultPort(dsn[start:end], dsnPorts["mysql"])
+		if err != nil {
+			return "", nil, fmt.Errorf("parsing DSN: %w", err)
+		}
+		return target, replaceRange(dsn, start, end), nil
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", nil, fmt.Errorf("parsing DSN: %w", err)
+	}
+	if u.Host == "" {
+		return "", nil, errors.New("parsing DSN: no host to proxy")
+	}
+	if strings.Contains(u.Host, ",") {
+		return "", nil, fmt.Errorf("parsing DSN: multiple hosts are not supported: %s", u.Host)
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	target, err := withDefaultPort(u.Host, dsnPorts[scheme])
+	if err != nil {
+		return "", nil, fmt.Errorf("parsing %s DSN: %w", scheme, err)
+	}
+
+	// Find the host in the original DSN so userinfo and query escaping are kept as-is
+	start := strings.Index(dsn, "://") + len("://")
+	end := len(dsn)
+	if i := strings.IndexAny(dsn[start:], "/?#"); i >= 0 {
+		end = start + i
+	}
+	if at := strings.LastIndexByte(dsn[start:end], '@'); at >= 0 {
+		start += at + 1
+	}
+	return target, replaceRange(dsn, start, end), nil
+}
+
+// withDefaultPort adds port to hostport when it doesn't have one.
+func withDefaultPort(hostport, port string) (string, error) {
+	if _, _, err := net.SplitHostPort(hostport); err == nil {
+		return hostport, nil
+	}
+	if port == "" {
+		return "", fmt.Errorf("no port in %q", hostport)
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(hostport, "["), "]")
+	if host == "" {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// replaceRange returns a func which replaces s[start:end].
+func replaceRange(s string, start, end int) func(string) string {
+	return func(replacement string) string {
+		return s[:start] + replacement + s[end:]
+	}
+}
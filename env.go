@@ -0,0 +1,79 @@
+package badnet
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Direction values can be overridden from environment variables so CI can turn
+// faults up or down without changing tests. Each setting is read from BADNET_<NAME>
+// for both directions, then BADNET_READ_<NAME> and BADNET_WRITE_<NAME> for one.
+//
+//	BADNET_LATENCY=50ms BADNET_WRITE_FAILURE_RATIO=5 go test ./...
+var directionEnv = []struct {
+	name  string
+	field func(d *Direction) interface{}
+}{
+	{"MAX_KBPS", func(d *Direction) interface{} { return &d.MaxKBps }},
+	{"BANDWIDTH", func(d *Direction) interface{} { return &d.Bandwidth }},
+	{"LATENCY", func(d *Direction) interface{} { return &d.Latency }},
+	{"LATENCY_PER_KB", func(d *Direction) interface{} { return &d.LatencyPerKB }},
+	{"FAILURE_RATIO", func(d *Direction) interface{} { return &d.FailureRatio }},
+	{"FAILURE_PROBABILITY", func(d *Direction) interface{} { return &d.FailureProbability }},
+	{"FAILURE_BASIS_POINTS", func(d *Direction) interface{} { return &d.FailureBasisPoints }},
+	{"FAIL_AFTER_BYTES", func(d *Direction) interface{} { return &d.FailAfterBytes }},
+	{"FAIL_EVERY_NTH", func(d *Direction) interface{} { return &d.FailEveryNth }},
+	{"TRUNCATE_RATIO", func(d *Direction) interface{} { return &d.TruncateRatio }},
+	{"DUPLICATE_RATIO", func(d *Direction) interface{} { return &d.DuplicateRatio }},
+}
+
+// ApplyEnv returns conf with its Read and Write overridden by any BADNET_ environment
+// variables. ForTest and DialerForTest call this unless Config.IgnoreEnv is set.
+//
+// Supported names are MAX_KBPS, BANDWIDTH, LATENCY, LATENCY_PER_KB, FAILURE_RATIO,
+// FAILURE_PROBABILITY, FAILURE_BASIS_POINTS, FAIL_AFTER_BYTES, FAIL_EVERY_NTH,
+// TRUNCATE_RATIO and DUPLICATE_RATIO. Durations use time.ParseDuration's format, e.g. "250ms", and
+// BANDWIDTH uses ParseRate's, e.g. "2mbit".
+func ApplyEnv(conf Config) (Config, error) {
+	for _, setting := range directionEnv {
+		for _, prefix := range []string{"BADNET_", "BADNET_READ_", "BADNET_WRITE_"} {
+			key := prefix + setting.name
+			value, ok := os.LookupEnv(key)
+			if !ok {
+				continue
+			}
+			if prefix != "BADNET_WRITE_" {
+				if err := parseEnv(setting.field(&conf.Read), value); err != nil {
+					return conf, fmt.Errorf("%s: %w", key, err)
+				}
+			}
+			if prefix != "BADNET_READ_" {
+				if err := parseEnv(setting.field(&conf.Write), value); err != nil {
+					return conf, fmt.Errorf("%s: %w", key, err)
+				}
+			}
+		}
+	}
+	return conf, nil
+}
+
+func parseEnv(field interface{}, value string) error {
+	var err error
+	switch f := field.(type) {
+	case *int:
+		*f, err = strconv.Atoi(value)
+	case *int64:
+		*f, err = strconv.ParseInt(value, 10, 64)
+	case *float64:
+		*f, err = strconv.ParseFloat(value, 64)
+	case *time.Duration:
+		*f, err = time.ParseDuration(value)
+	case *Rate:
+		*f, err = ParseRate(value)
+	default:
+		err = fmt.Errorf("unsupported field %T", field)
+	}
+	return err
+}
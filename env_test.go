@@ -0,0 +1,58 @@
+package badnet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyEnv(t *testing.T) {
+	t.Setenv("BADNET_LATENCY", "50ms")
+	t.Setenv("BADNET_WRITE_LATENCY", "100ms")
+	t.Setenv("BADNET_READ_FAILURE_RATIO", "5")
+	t.Setenv("BADNET_FAILURE_PROBABILITY", "0.25")
+	t.Setenv("BADNET_WRITE_FAIL_AFTER_BYTES", "1024")
+	t.Setenv("BADNET_WRITE_FAILURE_BASIS_POINTS", "5")
+
+	conf, err := ApplyEnv(Config{
+		Read:  Direction{MaxKBps: 10, FailureRatio: 50},
+		Write: Direction{MaxKBps: 20},
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, Direction{
+		MaxKBps:            10,
+		Latency:            50 * time.Millisecond,
+		FailureRatio:       5,
+		FailureProbability: 0.25,
+	}, conf.Read)
+	require.Equal(t, Direction{
+		MaxKBps:            20,
+		Latency:            100 * time.Millisecond,
+		FailureProbability: 0.25,
+		FailureBasisPoints: 5,
+		FailAfterBytes:     1024,
+	}, conf.Write)
+
+	t.Setenv("BADNET_MAX_KBPS", "fast")
+	_, err = ApplyEnv(Config{})
+	require.ErrorContains(t, err, "BADNET_MAX_KBPS")
+}
+
+func TestIgnoreEnv(t *testing.T) {
+	t.Setenv("BADNET_LATENCY", "50ms")
+
+	proxy := ForTest(t, Config{
+		Listen: "127.0.0.1:0",
+		Target: echoServer(t),
+	})
+	require.Equal(t, 50*time.Millisecond, proxy.EffectiveConfig().Read.Latency)
+
+	proxy = ForTest(t, Config{
+		Listen:    "127.0.0.1:0",
+		Target:    echoServer(t),
+		IgnoreEnv: true,
+	})
+	require.Zero(t, proxy.EffectiveConfig().Read.Latency)
+}
@@ -13,6 +13,5 @@ require (
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	go4.org v0.0.0-20230225012048-214862532bf5 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,140 @@
+package badnet
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// ConnectionInfo describes a connection made through the proxy.
+type ConnectionInfo struct {
+	// ID is the connection's ID, as returned by ConnectionID.
+	ID string
+
+	// Number is the order the connection was accepted in, starting at 1.
+	Number uint32
+
+	// Target is the address which served the connection. It's empty when no target
+	// could be reached.
+	Target string
+
+	// Hijacked is set when the connection was sent to Config.HijackTarget.
+	Hijacked bool
+}
+
+// Connections returns every connection made through the proxy in the order they were
+// connected to a target.
+func (p *Proxy) Connections() []ConnectionInfo {
+	p.connsMu.Lock()
+	defer p.connsMu.Unlock()
+
+	out := make([]ConnectionInfo, len(p.conns))
+	copy(out, p.conns)
+	return out
+}
+
+func (p *Proxy) recordConnection(ctx context.Context, client net.Conn, info ConnectionInfo) {
+	info.ID = ConnectionID(ctx)
+	if p.logf != nil {
+		target := info.Target
+		if target == "" {
+			target = "no target"
+		}
+		p.logf("badnet: connection %s from %s to %s", info.ID, client.RemoteAddr(), target)
+	}
+
+	p.connsMu.Lock()
+	defer p.connsMu.Unlock()
+
+	p.conns = append(p.conns, info)
+}
+
+// failover tracks which of a route's target or Config.Backups is serving connections.
+type failover struct {
+	dialer   *targetDialer
+	backups  []string
+	interval time.Duration
+	clock    Clock
+
+	mu        sync.Mutex
+	active    int // 0 is the route's target, backups follow
+	lastProbe time.Time
+}
+
+func newFailover(conf Config, dialer *targetDialer) *failover {
+	f := &failover{
+		dialer:   dialer,
+		interval: conf.FailbackInterval,
+		clock:    conf.clock(),
+	}
+	for _, backup := range conf.Backups {
+		f.backups = append(f.backups, targetAddress(backup, conf.TargetTLS != nil))
+	}
+	return f
+}
+
+// dial connects to the active target, failing over to backups in order when it can't
+// be reached. The address which was connected to is returned.
+func (f *failover) dial(ctx context.Context, rt route, onFailover func()) (net.Conn, string, error) {
+	if len(f.backups) == 0 {
+		c, err := f.dialer.dial(ctx, rt.target, rt.targetTLS)
+		return c, rt.target, err
+	}
+	candidates := append([]string{rt.target}, f.backups...)
+
+	f.mu.Lock()
+	active := f.active
+	probe := active > 0 && f.interval > 0 && f.clock.Now().Sub(f.lastProbe) >= f.interval
+	if probe {
+		f.lastProbe = f.clock.Now()
+	}
+	f.mu.Unlock()
+
+	// Periodically check if the target has recovered
+	if probe {
+		if c, err := f.dialer.dial(ctx, rt.target, rt.targetTLS); err == nil {
+			f.setActive(0)
+			return c, rt.target, nil
+		}
+	}
+
+	order := append([]int{active}, indexesExcept(len(candidates), active)...)
+
+	var lastErr error
+	for _, idx := range order {
+		c, err := f.dialer.dial(ctx, candidates[idx], rt.targetTLS)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if idx != active {
+			f.setActive(idx)
+			if onFailover != nil {
+				onFailover()
+			}
+		}
+		return c, candidates[idx], nil
+	}
+	return nil, "", lastErr
+}
+
+func (f *failover) setActive(idx int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.active == 0 && idx > 0 {
+		f.lastProbe = f.clock.Now()
+	}
+	f.active = idx
+}
+
+func indexesExcept(n, skip int) []int {
+	out := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		if i != skip {
+			out = append(out, i)
+		}
+	}
+	return out
+}
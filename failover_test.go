@@ -0,0 +1,70 @@
+package badnet
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailover(t *testing.T) {
+	// Reserve an address for the primary which isn't listening yet
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	primary := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	backup := echoServer(t)
+
+	proxy := ForTest(t, Config{
+		Listen:           "127.0.0.1:0",
+		Target:           primary,
+		Backups:          []string{backup},
+		FailbackInterval: 100 * time.Millisecond,
+	})
+
+	ping := func() {
+		t.Helper()
+
+		conn, err := net.Dial("tcp", proxy.BindAddr())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+	}
+
+	ping()
+	ping()
+	require.Equal(t, uint32(1), proxy.failovers.Load())
+
+	// Bring the primary up and wait for the proxy to fail back
+	ln, err = net.Listen("tcp", primary)
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	time.Sleep(150 * time.Millisecond)
+	ping()
+
+	conns := proxy.Connections()
+	require.Len(t, conns, 3)
+	require.Equal(t, backup, conns[0].Target)
+	require.Equal(t, backup, conns[1].Target)
+	require.Equal(t, primary, conns[2].Target)
+}
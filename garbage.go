@@ -0,0 +1,81 @@
+package badnet
+
+import (
+	"crypto/rand"
+)
+
+// GarbagePosition is where injected bytes are placed relative to the real data.
+type GarbagePosition int
+
+const (
+	// GarbageBefore injects bytes ahead of the data.
+	GarbageBefore GarbagePosition = iota
+
+	// GarbageAfter injects bytes following the data.
+	GarbageAfter
+
+	// GarbageInterleaved injects bytes at a random offset within the data.
+	GarbageInterleaved
+)
+
+func (p GarbagePosition) String() string {
+	switch p {
+	case GarbageBefore:
+		return "before"
+	case GarbageAfter:
+		return "after"
+	case GarbageInterleaved:
+		return "interleaved"
+	}
+	return "unknown"
+}
+
+// Garbage injects extra bytes into a connection's stream to exercise how parsers
+// reject malformed input and resynchronize.
+type Garbage struct {
+	// Ratio is the percentage of reads or writes which have garbage injected.
+	Ratio int
+
+	// Bytes are injected as-is. When empty, Length random bytes are used instead.
+	Bytes  []byte
+	Length int // defaults to 16
+
+	Position GarbagePosition
+}
+
+// offset picks where garbage is placed in n bytes of data.
+func (g Garbage) offset(n int) int {
+	switch g.Position {
+	case GarbageAfter:
+		return n
+	case GarbageInterleaved:
+		return randomInt(n + 1)
+	}
+	return 0
+}
+
+// insertGarbage returns data with garbage placed at offset, or at the end when the
+// data is shorter.
+func insertGarbage(data, garbage []byte, offset int) []byte {
+	offset = min(offset, len(data))
+
+	out := make([]byte, 0, len(data)+len(garbage))
+	out = append(out, data[:offset]...)
+	out = append(out, garbage...)
+	return append(out, data[offset:]...)
+}
+
+const defaultGarbageLength = 16
+
+func (g Garbage) bytes() []byte {
+	if len(g.Bytes) > 0 {
+		return g.Bytes
+	}
+	length := g.Length
+	if length <= 0 {
+		length = defaultGarbageLength
+	}
+	garbage := make([]byte, length)
+	rand.Read(garbage)
+	return garbage
+}
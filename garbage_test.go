@@ -0,0 +1,55 @@
+package badnet
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGarbage(t *testing.T) {
+	data := []byte("ping")
+	c := &conn{}
+	inject := func(g Garbage) []byte {
+		return c.garbage("write", 1, g, data)
+	}
+
+	g := Garbage{Bytes: []byte("XX")}
+	require.Equal(t, "XXping", string(inject(g)))
+
+	g.Position = GarbageAfter
+	require.Equal(t, "pingXX", string(inject(g)))
+
+	g.Position = GarbageInterleaved
+	out := inject(g)
+	require.Len(t, out, 6)
+	require.Contains(t, string(out), "XX")
+
+	g = Garbage{Length: 8}
+	require.Len(t, inject(g), 12)
+	require.Len(t, inject(Garbage{}), 20)
+}
+
+func TestGarbageInjection(t *testing.T) {
+	dialer := DialerForTest(t, Config{
+		Target: echoServer(t),
+		Write:  Direction{Garbage: Garbage{Ratio: 100, Bytes: []byte("XX")}},
+		Read:   Direction{Garbage: Garbage{Ratio: 100, Bytes: []byte("YY"), Position: GarbageAfter}},
+	})
+	conn, err := dialer.Dial(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	n, err := conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	require.Equal(t, 4, n)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 8)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "XXpingYY", string(buf))
+	require.Equal(t, uint32(2), dialer.garbage.Load())
+}
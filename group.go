@@ -0,0 +1,142 @@
+package badnet
+
+import (
+	"sort"
+	"sync"
+	"testing"
+)
+
+// ProxyGroup manages several proxies, such as one for each dependency of a service,
+// so they can be degraded together and their stats read as a whole.
+type ProxyGroup struct {
+	t testing.TB
+
+	mu      sync.Mutex
+	proxies map[string]*Proxy
+}
+
+// GroupForTest returns an empty ProxyGroup whose proxies are closed when the test finishes.
+func GroupForTest(t testing.TB) *ProxyGroup {
+	return &ProxyGroup{
+		t:       t,
+		proxies: make(map[string]*Proxy),
+	}
+}
+
+// Add starts a proxy with conf and tracks it under name, replacing any proxy
+// previously added with that name.
+func (g *ProxyGroup) Add(name string, conf Config) *Proxy {
+	g.t.Helper()
+
+	p := ForTest(g.t, conf)
+
+	g.mu.Lock()
+	g.proxies[name] = p
+	g.mu.Unlock()
+
+	return p
+}
+
+// Get returns the proxy added under name, or nil.
+func (g *ProxyGroup) Get(name string) *Proxy {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.proxies[name]
+}
+
+// Names returns the names of every proxy in the group, sorted.
+func (g *ProxyGroup) Names() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	names := make([]string, 0, len(g.proxies))
+	for name := range g.proxies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Apply calls fn with each proxy in the group, in name order, for changes which
+// SetNetwork and DisableToxic don't cover.
+func (g *ProxyGroup) Apply(fn func(name string, p *Proxy)) {
+	for _, name := range g.Names() {
+		fn(name, g.Get(name))
+	}
+}
+
+// SetNetwork changes every proxy in the group to the latency and bandwidth of n,
+// including on connections which are already open, such as to move all of a
+// service's dependencies onto a congested link together. Unlike Config.Network,
+// settings which aren't set remove the latency or limit, so SetNetwork(Network{})
+// restores full speed. Loss and Near only apply when a proxy starts, and the test
+// fails if they're set. Proxies added afterwards start with their own Config.
+func (g *ProxyGroup) SetNetwork(n Network) {
+	g.t.Helper()
+
+	if n.Loss != 0 || n.Near != 0 {
+		g.t.Fatalf("badnet: ProxyGroup.SetNetwork can't change Loss or Near on running proxies")
+	}
+	// Proxies read what clients upload
+	read := n.Latency / 2
+	g.each(func(p *Proxy) {
+		p.SetLatency(read, n.Latency-read)
+		p.SetRate(n.Upload, n.Download)
+	})
+}
+
+// DisableToxic turns off the fault or toxic with the given name on every proxy in
+// the group. See Proxy.DisableToxic.
+func (g *ProxyGroup) DisableToxic(name string) {
+	g.each(func(p *Proxy) {
+		p.DisableToxic(name)
+	})
+}
+
+// EnableToxic turns a toxic switched off with DisableToxic back on for every proxy
+// in the group.
+func (g *ProxyGroup) EnableToxic(name string) {
+	g.each(func(p *Proxy) {
+		p.EnableToxic(name)
+	})
+}
+
+// each calls fn with every proxy while holding the lock, so changes made through
+// the group are applied to all of its proxies before the next one starts.
+func (g *ProxyGroup) each(fn func(p *Proxy)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, p := range g.proxies {
+		fn(p)
+	}
+}
+
+// ConnectionCount returns how many connections every proxy in the group has accepted.
+func (g *ProxyGroup) ConnectionCount() uint32 {
+	var total uint32
+	g.Apply(func(_ string, p *Proxy) {
+		total += p.connectionCount.Load()
+	})
+	return total
+}
+
+// Stats returns the sum of the stats of every proxy in the group.
+func (g *ProxyGroup) Stats() Stats {
+	var total Stats
+	g.Apply(func(_ string, p *Proxy) {
+		total = total.add(p.Stats())
+	})
+	return total
+}
+
+// FailureRatio is the ratio of failures against connections across every proxy
+// in the group, or zero before any connections. See Proxy.FailureRatio.
+func (g *ProxyGroup) FailureRatio() float64 {
+	stats := g.Stats()
+	if stats.Connections == 0 {
+		return 0
+	}
+	return float64(stats.Failures()) / float64(stats.Connections)
+}
@@ -0,0 +1,90 @@
+package badnet
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyGroup(t *testing.T) {
+	group := GroupForTest(t)
+	require.Equal(t, 0.0, group.FailureRatio())
+
+	for _, name := range []string{"postgres", "redis", "api"} {
+		group.Add(name, Config{
+			Listen: "127.0.0.1:0",
+			Target: echoServer(t),
+		})
+	}
+	require.Equal(t, []string{"api", "postgres", "redis"}, group.Names())
+	require.Nil(t, group.Get("missing"))
+
+	// Degrade every dependency together
+	group.Apply(func(_ string, p *Proxy) {
+		p.SetLatency(0, 50*time.Millisecond)
+	})
+
+	for _, name := range group.Names() {
+		conn, err := net.Dial("tcp", group.Get(name).BindAddr())
+		require.NoError(t, err)
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		start := time.Now()
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+		_, err = io.ReadFull(conn, make([]byte, 4))
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+		conn.Close()
+	}
+
+	require.Equal(t, uint32(3), group.ConnectionCount())
+	require.Equal(t, 0.0, group.FailureRatio())
+
+	stats := group.Stats()
+	require.Equal(t, uint32(3), stats.Connections)
+	require.Equal(t, uint64(12), stats.ReadBytes)
+	require.Equal(t, uint64(3), stats.TargetDial.Count)
+}
+
+func TestProxyGroupSetNetwork(t *testing.T) {
+	upper := ToxicFunc(func(c net.Conn, _ ToxicInfo) net.Conn {
+		return &upperConn{Conn: c}
+	})
+
+	group := GroupForTest(t)
+	for _, name := range []string{"postgres", "redis"} {
+		group.Add(name, Config{
+			Listen:    "127.0.0.1:0",
+			Target:    echoServer(t),
+			IgnoreEnv: true,
+			Toxics:    []Toxic{Named("upper", upper)},
+		})
+	}
+
+	group.SetNetwork(Network{Latency: 100 * time.Millisecond, Download: Mbit})
+	group.DisableToxic("upper")
+	for _, name := range group.Names() {
+		p := group.Get(name)
+		conf := p.EffectiveConfig()
+		require.Equal(t, 50*time.Millisecond, conf.Read.Latency, name)
+		require.Equal(t, 50*time.Millisecond, conf.Write.Latency, name)
+		require.Zero(t, conf.Read.Bandwidth, name)
+		require.Equal(t, Mbit, conf.Write.Bandwidth, name)
+		require.False(t, p.toggles.enabled("upper"), name)
+	}
+
+	// and back to full speed
+	group.SetNetwork(Network{})
+	group.EnableToxic("upper")
+	for _, name := range group.Names() {
+		p := group.Get(name)
+		conf := p.EffectiveConfig()
+		require.Zero(t, conf.Read.Latency, name)
+		require.Zero(t, conf.Write.Bandwidth, name)
+		require.True(t, p.toggles.enabled("upper"), name)
+	}
+}
@@ -0,0 +1,159 @@
+package badnet
+
+import (
+	"encoding/json"
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// Histograms group durations into buckets which double in width every 8 buckets,
+// keeping each bucket within 12.5% of the durations recorded in it.
+const (
+	histogramSubBits    = 3
+	histogramSubBuckets = 1 << histogramSubBits
+	histogramBuckets    = histogramSubBuckets + (64-histogramSubBits)*histogramSubBuckets
+)
+
+// Histogram is a snapshot of recorded durations.
+type Histogram struct {
+	Count uint64
+	Sum   time.Duration
+	Max   time.Duration
+
+	counts []uint64
+}
+
+// Mean is the average of every recorded duration.
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Quantile returns the duration which q of the recorded durations are at or below,
+// such as 0.5 for the median or 0.99 for the 99th percentile. The result is rounded
+// up to the end of its bucket and never exceeds Max.
+func (h Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.Count)))
+	if rank < 1 {
+		rank = 1
+	}
+
+	var seen uint64
+	for idx, n := range h.counts {
+		seen += n
+		if seen >= rank {
+			if upper := bucketUpperBound(idx); upper < h.Max {
+				return upper
+			}
+			break
+		}
+	}
+	return h.Max
+}
+
+// MarshalJSON summarizes the histogram with its count and common quantiles.
+func (h Histogram) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Count uint64
+		Mean  string
+		P50   string
+		P90   string
+		P99   string
+		Max   string
+	}{
+		Count: h.Count,
+		Mean:  h.Mean().String(),
+		P50:   h.Quantile(0.5).String(),
+		P90:   h.Quantile(0.9).String(),
+		P99:   h.Quantile(0.99).String(),
+		Max:   h.Max.String(),
+	})
+}
+
+// add returns a histogram holding the durations recorded in both h and o.
+func (h Histogram) add(o Histogram) Histogram {
+	out := Histogram{
+		Count: h.Count + o.Count,
+		Sum:   h.Sum + o.Sum,
+		Max:   max(h.Max, o.Max),
+	}
+	if out.Count > 0 {
+		out.counts = make([]uint64, histogramBuckets)
+		for i := range h.counts {
+			out.counts[i] += h.counts[i]
+		}
+		for i := range o.counts {
+			out.counts[i] += o.counts[i]
+		}
+	}
+	return out
+}
+
+// histogram records durations concurrently.
+type histogram struct {
+	counts [histogramBuckets]atomic.Uint64
+	count  atomic.Uint64
+	sum    atomic.Int64
+	max    atomic.Int64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	h.counts[bucketIndex(uint64(d))].Add(1)
+	h.count.Add(1)
+	h.sum.Add(int64(d))
+	for {
+		max := h.max.Load()
+		if int64(d) <= max || h.max.CompareAndSwap(max, int64(d)) {
+			return
+		}
+	}
+}
+
+func (h *histogram) snapshot() Histogram {
+	out := Histogram{
+		Count: h.count.Load(),
+		Sum:   time.Duration(h.sum.Load()),
+		Max:   time.Duration(h.max.Load()),
+	}
+	if out.Count > 0 {
+		out.counts = make([]uint64, histogramBuckets)
+		for i := range h.counts {
+			out.counts[i] = h.counts[i].Load()
+		}
+	}
+	return out
+}
+
+func bucketIndex(v uint64) int {
+	if v < histogramSubBuckets {
+		return int(v)
+	}
+	exp := bits.Len64(v) - 1
+	sub := (v >> (exp - histogramSubBits)) & (histogramSubBuckets - 1)
+	return histogramSubBuckets + (exp-histogramSubBits)*histogramSubBuckets + int(sub)
+}
+
+// bucketUpperBound returns the largest duration recorded in a bucket.
+func bucketUpperBound(idx int) time.Duration {
+	if idx < histogramSubBuckets {
+		return time.Duration(idx)
+	}
+	exp := (idx-histogramSubBuckets)/histogramSubBuckets + histogramSubBits
+	sub := uint64((idx - histogramSubBuckets) % histogramSubBuckets)
+	width := uint64(1) << (exp - histogramSubBits)
+	upper := (histogramSubBuckets+sub)*width + width - 1
+	if upper > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(upper)
+}
@@ -0,0 +1,84 @@
+package badnet
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	for _, v := range []uint64{0, 1, 7, 8, 15, 16, 17, 1000, 123456789, 1 << 62} {
+		idx := bucketIndex(v)
+		require.GreaterOrEqual(t, uint64(bucketUpperBound(idx)), v, "value %d", v)
+		if idx > 0 {
+			require.Less(t, uint64(bucketUpperBound(idx-1)), v, "value %d", v)
+		}
+	}
+	require.Less(t, bucketIndex(1<<63), histogramBuckets)
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	var h histogram
+	require.Zero(t, h.snapshot().Quantile(0.5))
+
+	for i := 1; i <= 100; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+	snapshot := h.snapshot()
+	require.Equal(t, uint64(100), snapshot.Count)
+	require.Equal(t, 100*time.Millisecond, snapshot.Max)
+	require.Equal(t, 50500*time.Microsecond, snapshot.Mean())
+
+	// Quantiles are within a bucket's width of the real value
+	require.InEpsilon(t, 50*time.Millisecond, snapshot.Quantile(0.5), 0.125)
+	require.InEpsilon(t, 99*time.Millisecond, snapshot.Quantile(0.99), 0.125)
+	require.Equal(t, 100*time.Millisecond, snapshot.Quantile(1))
+}
+
+func TestHistogramAdd(t *testing.T) {
+	var low, high histogram
+	for i := 1; i <= 50; i++ {
+		low.observe(time.Duration(i) * time.Millisecond)
+		high.observe(time.Duration(i+50) * time.Millisecond)
+	}
+	sum := low.snapshot().add(high.snapshot())
+	require.Equal(t, uint64(100), sum.Count)
+	require.Equal(t, 100*time.Millisecond, sum.Max)
+	require.InEpsilon(t, 50*time.Millisecond, sum.Quantile(0.5), 0.125)
+
+	require.Equal(t, sum, sum.add(Histogram{}))
+}
+
+func TestInjectedDelay(t *testing.T) {
+	proxy := ForTest(t, Config{
+		Listen: "127.0.0.1:0",
+		Target: echoServer(t),
+		Write: Direction{
+			Latency: 20 * time.Millisecond,
+		},
+	})
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err = conn.Write([]byte("hello"))
+		require.NoError(t, err)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err = io.ReadFull(conn, make([]byte, 5))
+		require.NoError(t, err)
+	}
+
+	// The last delay is recorded after the write back to the client finishes
+	require.Eventually(t, func() bool {
+		return proxy.Stats().InjectedDelay.Count == 3
+	}, time.Second, 10*time.Millisecond)
+
+	stats := proxy.Stats()
+	require.Equal(t, 20*time.Millisecond, stats.InjectedDelay.Quantile(0.5))
+	require.Equal(t, uint64(1), stats.TargetDial.Count)
+	require.Greater(t, stats.TargetDial.Max, time.Duration(0))
+}
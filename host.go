@@ -0,0 +1,104 @@
+package badnet
+
+import (
+	"bytes"
+	"net"
+)
+
+// hostHeaderMax caps how much of a request is held while waiting for the end of
+// its headers. Requests with more headers are forwarded unchanged.
+const hostHeaderMax = 64 * 1024
+
+// hostHeaderToxic replaces the Host header of HTTP requests read from clients with
+// host. It's applied before any other faults, so the headers are rewritten however
+// MTU, Trickle or BufferSize split them up.
+func hostHeaderToxic(host string) Toxic {
+	if host == "" {
+		return nil
+	}
+	return ToxicFunc(func(c net.Conn, _ ToxicInfo) net.Conn {
+		return &hostHeaderConn{Conn: c, host: []byte("Host: " + host)}
+	})
+}
+
+// hostHeaderConn holds back the start of each request read until its headers are
+// complete, then rewrites them.
+type hostHeaderConn struct {
+	net.Conn
+
+	host []byte
+
+	// head is the start of a request whose headers haven't all been read, and
+	// pending holds data ready to be returned by Read.
+	head    []byte
+	pending []byte
+	err     error
+}
+
+// NetConn returns the underlying connection.
+func (c *hostHeaderConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *hostHeaderConn) Read(b []byte) (int, error) {
+	for len(c.pending) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		n, err := c.Conn.Read(b)
+		if len(c.head) == 0 && !requestStart(b[:n]) {
+			return n, err
+		}
+		c.head = append(c.head, b[:n]...)
+		c.err = err
+		c.pending = c.rewrite()
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// rewrite returns the request held in head once its headers are complete, with
+// the Host header replaced, or as it was read when it turns out not to be HTTP or
+// its headers are too long or cut short. Nothing is returned while more of the
+// headers are needed.
+func (c *hostHeaderConn) rewrite() []byte {
+	head := c.head
+	line := bytes.IndexByte(head, '\n')
+	end := bytes.Index(head, []byte("\r\n\r\n"))
+	switch {
+	case line >= 0 && !bytes.Contains(head[:line], []byte(" HTTP/1.")),
+		end < 0 && (c.err != nil || len(head) > hostHeaderMax):
+		c.head = nil
+		return head
+	case end < 0:
+		return nil
+	}
+	c.head = nil
+
+	lines := bytes.Split(head[:end], []byte("\r\n"))
+	for i, line := range lines {
+		name, _, found := bytes.Cut(line, []byte(":"))
+		if found && bytes.EqualFold(bytes.TrimSpace(name), []byte("Host")) {
+			lines[i] = c.host
+		}
+	}
+	return append(bytes.Join(lines, []byte("\r\n")), head[end:]...)
+}
+
+// httpMethods start the requests whose headers are rewritten.
+var httpMethods = []string{"GET ", "HEAD ", "POST ", "PUT ", "DELETE ", "CONNECT ", "OPTIONS ", "TRACE ", "PATCH "}
+
+// requestStart reports if data could be the start of an HTTP request: a method
+// followed by a space, or the beginning of one.
+func requestStart(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	for _, method := range httpMethods {
+		if n := min(len(data), len(method)); string(data[:n]) == method[:n] {
+			return true
+		}
+	}
+	return false
+}
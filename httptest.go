@@ -0,0 +1,57 @@
+package badnet
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// HTTPTest is a Proxy fronting an httptest.Server.
+type HTTPTest struct {
+	*Proxy
+
+	// URL is the base URL of the proxy, e.g. https://127.0.0.1:41234
+	URL string
+
+	// Client is configured to make requests through the proxy and trusts its certificate.
+	Client *http.Client
+}
+
+// ForHTTPTest starts a proxy in front of server. TLS servers are fronted by a proxy
+// with ListenTLS enabled which trusts the server's certificate, so callers need no
+// TLS setup of their own. Listen defaults to 127.0.0.1:0 and Target is set to the server.
+func ForHTTPTest(t testing.TB, server *httptest.Server, conf Config) *HTTPTest {
+	t.Helper()
+
+	if conf.Listen == "" {
+		conf.Listen = "127.0.0.1:0"
+	}
+	conf.Target = server.URL
+
+	isTLS := server.TLS != nil
+	if isTLS {
+		conf.ListenTLS = true
+		if conf.TargetTLS == nil {
+			if tr, ok := server.Client().Transport.(*http.Transport); ok && tr.TLSClientConfig != nil {
+				conf.TargetTLS = tr.TLSClientConfig.Clone()
+			}
+		}
+	}
+
+	proxy := ForTest(t, conf)
+
+	out := &HTTPTest{
+		Proxy:  proxy,
+		URL:    "http://" + proxy.BindAddr(),
+		Client: &http.Client{Transport: &http.Transport{}},
+	}
+	if isTLS {
+		out.URL = "https://" + proxy.BindAddr()
+		out.Client.Transport = &http.Transport{
+			TLSClientConfig: proxy.ClientTLSConfig(),
+		}
+	}
+	t.Cleanup(out.Client.CloseIdleConnections)
+
+	return out
+}
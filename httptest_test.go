@@ -0,0 +1,37 @@
+package badnet
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestForHTTPTest(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("PONG"))
+	})
+
+	servers := map[string]*httptest.Server{
+		"plain": httptest.NewServer(handler),
+		"TLS":   httptest.NewTLSServer(handler),
+	}
+	for name, server := range servers {
+		t.Run(name, func(t *testing.T) {
+			t.Cleanup(server.Close)
+
+			ht := ForHTTPTest(t, server, Config{})
+
+			resp, err := ht.Client.Get(ht.URL)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			bs, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, "PONG", string(bs))
+			require.Equal(t, uint32(1), ht.connectionCount.Load())
+		})
+	}
+}
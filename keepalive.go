@@ -0,0 +1,42 @@
+package badnet
+
+import (
+	"net"
+	"time"
+)
+
+// KeepAlive controls TCP keep-alives on one leg of the proxy. Go enables keep-alives
+// every 15 seconds by default, which lets the proxy notice dead peers that a real
+// middlebox might not, hiding half-open connections from the other side.
+type KeepAlive struct {
+	// Disable stops keep-alive probes being sent, so a peer which disappears
+	// without closing is never noticed.
+	Disable bool
+
+	// Period is how long a connection is idle before it's probed, such as a second
+	// to notice dead peers quickly. Zero leaves Go's default.
+	Period time.Duration
+}
+
+func (k KeepAlive) enabled() bool {
+	return k.Disable || k.Period > 0
+}
+
+// apply sets the keep-alive options on the TCP connection underneath c. Other
+// connections are left as they are.
+func (k KeepAlive) apply(c net.Conn) error {
+	if !k.enabled() {
+		return nil
+	}
+	tcp, ok := tcpConn(c)
+	if !ok {
+		return nil
+	}
+	if k.Disable {
+		return tcp.SetKeepAlive(false)
+	}
+	if err := tcp.SetKeepAlive(true); err != nil {
+		return err
+	}
+	return tcp.SetKeepAlivePeriod(k.Period)
+}
@@ -0,0 +1,44 @@
+//go:build linux
+
+package badnet
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeepAlive(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+
+	sockopt := func(level, opt int) int {
+		raw, err := client.(*net.TCPConn).SyscallConn()
+		require.NoError(t, err)
+
+		var value int
+		var serr error
+		require.NoError(t, raw.Control(func(fd uintptr) {
+			value, serr = syscall.GetsockoptInt(int(fd), level, opt)
+		}))
+		require.NoError(t, serr)
+		return value
+	}
+	require.Equal(t, 1, sockopt(syscall.SOL_SOCKET, syscall.SO_KEEPALIVE))
+
+	// Wrapped connections are unwrapped
+	wrapped := &conn{Conn: client}
+	require.NoError(t, KeepAlive{Period: 3 * time.Second}.apply(wrapped))
+	require.Equal(t, 3, sockopt(syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE))
+
+	require.NoError(t, KeepAlive{Disable: true}.apply(wrapped))
+	require.Equal(t, 0, sockopt(syscall.SOL_SOCKET, syscall.SO_KEEPALIVE))
+}
@@ -0,0 +1,217 @@
+package badnet
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// CloseMode is how the proxy ends connections it decides to close.
+type CloseMode int
+
+const (
+	// CloseGraceful closes connections normally, so peers read EOF.
+	CloseGraceful CloseMode = iota
+
+	// CloseReset aborts connections with a TCP reset (RST), so peers see errors
+	// such as ECONNRESET.
+	CloseReset
+)
+
+func (m CloseMode) String() string {
+	switch m {
+	case CloseGraceful:
+		return "graceful"
+	case CloseReset:
+		return "reset"
+	}
+	return "unknown"
+}
+
+// terminate closes connections the proxy has decided to end according to CloseMode.
+func (p *Proxy) terminate(conns ...net.Conn) {
+	for _, c := range conns {
+		if p.conf.CloseMode == CloseReset {
+			setLingerZero(c)
+		}
+		c.Close()
+	}
+}
+
+// limitLifetime applies IdleTimeout, MaxConnectionAge and MaxBytesPerConnection
+// to a client's connection and its target, which is nil for pooled connections,
+// terminating both once one is reached. stop cancels the timers.
+func (p *Proxy) limitLifetime(conn, target net.Conn) (_, _ net.Conn, stop func()) {
+	// Callbacks close the connections as they are now, before they're wrapped
+	ends := []net.Conn{conn}
+	if target != nil {
+		ends = append(ends, target)
+	}
+	var stops []func()
+
+	if p.conf.IdleTimeout > 0 {
+		idle := newIdleTimer(p.conf.clock(), p.conf.IdleTimeout, func() {
+			p.idleTimedOut()
+			p.terminate(ends...)
+		})
+		stops = append(stops, idle.stop)
+
+		conn = &activityConn{Conn: conn, idle: idle}
+	}
+
+	if age := p.conf.maxConnectionAge(); age > 0 {
+		expire := afterFunc(p.conf.clock(), age, func() {
+			p.connectionExpired()
+			p.terminate(ends...)
+		})
+		stops = append(stops, func() { expire.Stop() })
+	}
+
+	if p.conf.MaxBytesPerConnection > 0 {
+		budget := &byteBudget{remaining: p.conf.MaxBytesPerConnection}
+		budget.onSpent = func() {
+			p.byteLimitReached()
+			p.terminate(ends...)
+		}
+		conn = &budgetConn{Conn: conn, budget: budget}
+		if target != nil {
+			target = &budgetConn{Conn: target, budget: budget}
+		}
+	}
+
+	return conn, target, func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}
+}
+
+// setLingerZero makes closing c discard unsent data and send a reset. Wrapped
+// connections are unwrapped to find the TCP connection.
+func setLingerZero(c net.Conn) {
+	for {
+		if tc, ok := c.(*net.TCPConn); ok {
+			tc.SetLinger(0)
+			return
+		}
+		u, ok := c.(interface{ NetConn() net.Conn })
+		if !ok {
+			return
+		}
+		c = u.NetConn()
+	}
+}
+
+// maxConnectionAge returns how long a new connection may live, or zero for no limit.
+func (c Config) maxConnectionAge() time.Duration {
+	if c.MaxConnectionAge <= 0 {
+		return 0
+	}
+	return withJitter(c.MaxConnectionAge, c.MaxConnectionAgeJitter)
+}
+
+// idleTimer closes a connection once no data has flowed through it for the timeout.
+type idleTimer struct {
+	timeout time.Duration
+	timer   *clockTimer
+}
+
+func newIdleTimer(clock Clock, timeout time.Duration, onIdle func()) *idleTimer {
+	return &idleTimer{
+		timeout: timeout,
+		timer:   afterFunc(clock, timeout, onIdle),
+	}
+}
+
+func (t *idleTimer) active() {
+	t.timer.Reset(t.timeout)
+}
+
+func (t *idleTimer) stop() {
+	t.timer.Stop()
+}
+
+// activityConn reports each read or write which moved data.
+type activityConn struct {
+	net.Conn
+
+	idle *idleTimer
+}
+
+// NetConn returns the underlying connection.
+func (c *activityConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *activityConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.idle.active()
+	}
+	return n, err
+}
+
+func (c *activityConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.idle.active()
+	}
+	return n, err
+}
+
+// byteBudget is the data left to forward over a connection, shared by both of
+// its directions, before onSpent closes it.
+type byteBudget struct {
+	mu        sync.Mutex
+	remaining int64
+	pending   int
+	spent     bool
+
+	onSpent func()
+}
+
+// take claims up to n bytes of the budget for a write, which must be followed by done.
+func (b *byteBudget) take(n int) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n = int(min(int64(n), b.remaining))
+	b.remaining -= int64(n)
+	b.pending++
+	return n
+}
+
+// done finishes a write and calls onSpent once the budget has run out and every
+// write which claimed part of it has been delivered.
+func (b *byteBudget) done() {
+	b.mu.Lock()
+	b.pending--
+	spent := !b.spent && b.remaining == 0 && b.pending == 0
+	if spent {
+		b.spent = true
+	}
+	b.mu.Unlock()
+
+	if spent {
+		b.onSpent()
+	}
+}
+
+// budgetConn only writes data which fits in what's left of its budget.
+type budgetConn struct {
+	net.Conn
+
+	budget *byteBudget
+}
+
+// NetConn returns the underlying connection.
+func (c *budgetConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *budgetConn) Write(b []byte) (int, error) {
+	allowed := c.budget.take(len(b))
+	defer c.budget.done()
+
+	return c.Conn.Write(b[:allowed])
+}
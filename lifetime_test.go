@@ -0,0 +1,210 @@
+package badnet
+
+import (
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	proxy := ForTest(t, Config{
+		Listen:      "127.0.0.1:0",
+		Target:      echoServer(t),
+		IdleTimeout: 100 * time.Millisecond,
+	})
+
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Regular traffic keeps the connection open
+	buf := make([]byte, 4)
+	for i := 0; i < 4; i++ {
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// Going quiet closes it
+	time.Sleep(150 * time.Millisecond)
+	_, err = conn.Read(buf)
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, uint32(1), proxy.idleTimeouts.Load())
+}
+
+func TestMaxConnectionAge(t *testing.T) {
+	proxy := ForTest(t, Config{
+		Listen:                 "127.0.0.1:0",
+		Target:                 echoServer(t),
+		MaxConnectionAge:       100 * time.Millisecond,
+		MaxConnectionAgeJitter: 50 * time.Millisecond,
+	})
+
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Activity doesn't extend the connection's life
+	start := time.Now()
+	buf := make([]byte, 4)
+	for {
+		if _, err = conn.Write([]byte("ping")); err != nil {
+			break
+		}
+		if _, err = io.ReadFull(conn, buf); err != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	elapsed := time.Since(start)
+	require.GreaterOrEqual(t, elapsed, 100*time.Millisecond)
+	require.Less(t, elapsed, time.Second)
+	require.Equal(t, uint32(1), proxy.expirations.Load())
+
+	age := Config{MaxConnectionAge: time.Second, MaxConnectionAgeJitter: time.Second}.maxConnectionAge()
+	require.GreaterOrEqual(t, age, time.Second)
+	require.Less(t, age, 2*time.Second)
+}
+
+func TestLifetimeFakeClock(t *testing.T) {
+	dial := func(t *testing.T, conf Config) (*Proxy, *FakeClock, net.Conn) {
+		clock := NewFakeClock(time.Now())
+		conf.Listen, conf.Target, conf.Clock = "127.0.0.1:0", echoServer(t), clock
+		proxy := ForTest(t, conf)
+
+		conn, err := net.Dial("tcp", proxy.BindAddr())
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+
+		echoOnce(t, conn, "ping")
+		require.Eventually(t, func() bool { return clock.Waiters() > 0 }, 5*time.Second, time.Millisecond)
+		return proxy, clock, conn
+	}
+
+	t.Run("IdleTimeout", func(t *testing.T) {
+		proxy, clock, conn := dial(t, Config{IdleTimeout: time.Minute})
+
+		clock.Advance(59 * time.Second)
+		echoOnce(t, conn, "ping")
+		require.Zero(t, proxy.idleTimeouts.Load())
+
+		// Traffic may have pushed the timeout back, so keep going until it fires
+		require.Eventually(t, func() bool {
+			clock.Advance(time.Minute)
+			return proxy.idleTimeouts.Load() == 1
+		}, 5*time.Second, time.Millisecond)
+		_, err := conn.Read(make([]byte, 1))
+		require.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("MaxConnectionAge", func(t *testing.T) {
+		proxy, clock, conn := dial(t, Config{MaxConnectionAge: time.Hour})
+
+		clock.Advance(59 * time.Minute)
+		echoOnce(t, conn, "ping")
+		require.Zero(t, proxy.expirations.Load())
+
+		clock.Advance(time.Minute)
+		require.Eventually(t, func() bool { return proxy.expirations.Load() == 1 }, 5*time.Second, time.Millisecond)
+		_, err := conn.Read(make([]byte, 1))
+		require.ErrorIs(t, err, io.EOF)
+	})
+}
+
+func TestCloseMode(t *testing.T) {
+	for _, mode := range []CloseMode{CloseGraceful, CloseReset} {
+		t.Run(mode.String(), func(t *testing.T) {
+			proxy := ForTest(t, Config{
+				Listen:           "127.0.0.1:0",
+				Target:           echoServer(t),
+				MaxConnectionAge: 50 * time.Millisecond,
+				CloseMode:        mode,
+			})
+			conn, err := net.Dial("tcp", proxy.BindAddr())
+			require.NoError(t, err)
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			_, err = conn.Read(make([]byte, 1))
+			if mode == CloseReset {
+				require.ErrorIs(t, err, syscall.ECONNRESET)
+			} else {
+				require.ErrorIs(t, err, io.EOF)
+			}
+		})
+	}
+}
+
+func TestConnectDelay(t *testing.T) {
+	proxy := ForTest(t, Config{
+		Listen:        "127.0.0.1:0",
+		Target:        echoServer(t),
+		ConnectDelay:  100 * time.Millisecond,
+		ConnectJitter: 50 * time.Millisecond,
+	})
+
+	start := time.Now()
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, make([]byte, 4))
+	require.NoError(t, err)
+
+	elapsed := time.Since(start)
+	require.GreaterOrEqual(t, elapsed, 100*time.Millisecond)
+	require.Less(t, elapsed, time.Second)
+}
+
+func TestCloseAfterAccept(t *testing.T) {
+	proxy := ForTest(t, Config{
+		Listen:                "127.0.0.1:0",
+		Target:                echoServer(t),
+		CloseAfterAcceptRatio: 100,
+	})
+
+	// The connection is established but closed before any response
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	conn.Write([]byte("ping"))
+	_, err = conn.Read(make([]byte, 4))
+	require.Error(t, err)
+	require.Equal(t, uint32(1), proxy.earlyCloses.Load())
+}
+
+func TestMaxBytesPerConnection(t *testing.T) {
+	proxy := ForTest(t, Config{
+		Listen:                "127.0.0.1:0",
+		Target:                echoServer(t),
+		MaxBytesPerConnection: 1000,
+	})
+
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// 800 bytes out leave 200 to be echoed back before the connection is closed
+	go conn.Write(make([]byte, 800))
+
+	got, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Len(t, got, 200)
+	require.Eventually(t, func() bool {
+		return proxy.Stats().ByteLimits == 1
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,114 @@
+package badnet
+
+import (
+	"context"
+	"time"
+)
+
+// LimitPolicy decides what happens to connections beyond Config.MaxConnections
+// or Config.MaxNewConnectionsPerSecond.
+type LimitPolicy int
+
+const (
+	// LimitRefuse closes connections accepted while the proxy is at capacity.
+	LimitRefuse LimitPolicy = iota
+
+	// LimitQueue holds new connections until an existing one finishes.
+	LimitQueue
+)
+
+func (l LimitPolicy) String() string {
+	switch l {
+	case LimitRefuse:
+		return "refuse"
+	case LimitQueue:
+		return "queue"
+	}
+	return "unknown"
+}
+
+// connectionLimit caps how many connections are proxied at once.
+type connectionLimit struct {
+	policy LimitPolicy
+	slots  chan struct{}
+}
+
+func newConnectionLimit(conf Config) *connectionLimit {
+	if conf.MaxConnections <= 0 {
+		return nil
+	}
+	return &connectionLimit{
+		policy: conf.ConnectionLimit,
+		slots:  make(chan struct{}, conf.MaxConnections),
+	}
+}
+
+// acquire reserves a slot for a connection, waiting for one to free up when
+// queueing. It returns false if the connection should be refused.
+func (l *connectionLimit) acquire(ctx context.Context) bool {
+	if l == nil {
+		return true
+	}
+	if l.policy == LimitQueue {
+		select {
+		case l.slots <- struct{}{}:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *connectionLimit) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
+
+// acceptRate spaces out new connections to at most a number per second.
+type acceptRate struct {
+	clock    Clock
+	policy   LimitPolicy
+	interval time.Duration
+	next     time.Time
+}
+
+func newAcceptRate(conf Config) *acceptRate {
+	if conf.MaxNewConnectionsPerSecond <= 0 {
+		return nil
+	}
+	return &acceptRate{
+		clock:    conf.clock(),
+		policy:   conf.NewConnectionLimit,
+		interval: time.Duration(float64(time.Second) / conf.MaxNewConnectionsPerSecond),
+	}
+}
+
+// allow is called from the accept loop for each new connection. When queueing it
+// waits for the connection's turn, otherwise it returns false for connections
+// which arrive too soon.
+func (r *acceptRate) allow(ctx context.Context) bool {
+	if r == nil {
+		return true
+	}
+	now := r.clock.Now()
+	if now.Before(r.next) {
+		if r.policy != LimitQueue {
+			return false
+		}
+		select {
+		case <-r.clock.After(r.next.Sub(now)):
+		case <-ctx.Done():
+			return false
+		}
+		now = r.next
+	}
+	r.next = now.Add(r.interval)
+	return true
+}
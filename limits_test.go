@@ -0,0 +1,133 @@
+package badnet
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxConnections(t *testing.T) {
+	target := echoServer(t)
+
+	ping := func(t *testing.T, conn net.Conn) error {
+		t.Helper()
+
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			return err
+		}
+		buf := make([]byte, 4)
+		_, err := io.ReadFull(conn, buf)
+		return err
+	}
+
+	t.Run("refuse", func(t *testing.T) {
+		proxy := ForTest(t, Config{
+			Listen:         "127.0.0.1:0",
+			Target:         target,
+			MaxConnections: 1,
+		})
+
+		first, err := net.Dial("tcp", proxy.BindAddr())
+		require.NoError(t, err)
+		require.NoError(t, ping(t, first))
+
+		second, err := net.Dial("tcp", proxy.BindAddr())
+		require.NoError(t, err)
+		defer second.Close()
+		require.Error(t, ping(t, second))
+		require.Equal(t, uint32(1), proxy.refused.Load())
+
+		// Capacity frees up once the first connection is done
+		first.Close()
+		require.Eventually(t, func() bool {
+			conn, err := net.Dial("tcp", proxy.BindAddr())
+			if err != nil {
+				return false
+			}
+			defer conn.Close()
+			return ping(t, conn) == nil
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("queue", func(t *testing.T) {
+		proxy := ForTest(t, Config{
+			Listen:          "127.0.0.1:0",
+			Target:          target,
+			MaxConnections:  1,
+			ConnectionLimit: LimitQueue,
+		})
+
+		first, err := net.Dial("tcp", proxy.BindAddr())
+		require.NoError(t, err)
+		require.NoError(t, ping(t, first))
+
+		second, err := net.Dial("tcp", proxy.BindAddr())
+		require.NoError(t, err)
+		defer second.Close()
+
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			first.Close()
+		}()
+
+		start := time.Now()
+		require.NoError(t, ping(t, second))
+		require.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+		require.Equal(t, uint32(0), proxy.refused.Load())
+	})
+}
+
+func TestMaxNewConnectionsPerSecond(t *testing.T) {
+	target := echoServer(t)
+
+	ping := func(proxy *Proxy) error {
+		conn, err := net.Dial("tcp", proxy.BindAddr())
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			return err
+		}
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		return err
+	}
+
+	t.Run("refuse", func(t *testing.T) {
+		proxy := ForTest(t, Config{
+			Listen:                     "127.0.0.1:0",
+			Target:                     target,
+			MaxNewConnectionsPerSecond: 5,
+		})
+		require.NoError(t, ping(proxy))
+		require.Error(t, ping(proxy))
+		require.Error(t, ping(proxy))
+
+		time.Sleep(200 * time.Millisecond)
+		require.NoError(t, ping(proxy))
+		require.Equal(t, uint32(2), proxy.refused.Load())
+	})
+
+	t.Run("queue", func(t *testing.T) {
+		proxy := ForTest(t, Config{
+			Listen:                     "127.0.0.1:0",
+			Target:                     target,
+			MaxNewConnectionsPerSecond: 20,
+			NewConnectionLimit:         LimitQueue,
+		})
+
+		start := time.Now()
+		for i := 0; i < 5; i++ {
+			require.NoError(t, ping(proxy))
+		}
+		require.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+		require.Equal(t, uint32(0), proxy.refused.Load())
+	})
+}
@@ -0,0 +1,135 @@
+package badnet
+
+import (
+	"bytes"
+	"net"
+	"regexp"
+)
+
+// Match describes the data which triggers a toxic wrapped by WhenMatches, such as
+// requests containing "BEGIN TRANSACTION" or responses starting with a magic number.
+// Each read or write is matched on its own, so patterns split across them aren't seen.
+type Match struct {
+	// Prefix matches data starting with these bytes.
+	Prefix []byte
+
+	// Pattern matches data containing it. When Prefix is also set both must match.
+	Pattern *regexp.Regexp
+
+	// Sticky applies the toxic to every later read, or every later write, on the
+	// connection once one has matched, affecting the rest of the stream.
+	Sticky bool
+}
+
+func (m Match) matches(data []byte) bool {
+	if len(m.Prefix) > 0 && !bytes.HasPrefix(data, m.Prefix) {
+		return false
+	}
+	if m.Pattern != nil && !m.Pattern.Match(data) {
+		return false
+	}
+	return true
+}
+
+// WhenMatches applies toxic only to the reads and writes whose data matches m, with
+// everything else forwarded untouched. Use a Direction's Toxics to match only one way.
+//
+// Reads are matched once their data has arrived, which is then handed to the toxic's
+// connection as if it had just been read.
+func WhenMatches(m Match, toxic Toxic) Toxic {
+	return ToxicFunc(func(c net.Conn, info ToxicInfo) net.Conn {
+		direct := &unreadConn{Conn: c}
+		return &matchConn{
+			Conn:   toxic.Wrap(direct, info),
+			direct: direct,
+			match:  m,
+		}
+	})
+}
+
+// matchConn sends matching reads and writes through a toxic's connection and the
+// rest straight to the connection the toxic wrapped. Closing and deadlines go
+// through the toxic.
+type matchConn struct {
+	net.Conn
+
+	direct *unreadConn
+	match  Match
+
+	// readMatched and writeMatched latch for Sticky matches
+	readMatched, writeMatched bool
+}
+
+// NetConn returns the connection the toxic wrapped.
+func (c *matchConn) NetConn() net.Conn {
+	return c.direct.Conn
+}
+
+// triggered reports if data goes through the toxic, latching sticky matches.
+func (c *matchConn) triggered(matched *bool, data []byte) bool {
+	if *matched {
+		return true
+	}
+	if !c.match.matches(data) {
+		return false
+	}
+	*matched = c.match.Sticky
+	return true
+}
+
+func (c *matchConn) Read(b []byte) (int, error) {
+	// Finish handing data which matched to the toxic
+	if c.direct.buffered() || c.readMatched {
+		return c.Conn.Read(b)
+	}
+
+	n, err := c.direct.Read(b)
+	if n == 0 || !c.triggered(&c.readMatched, b[:n]) {
+		return n, err
+	}
+	c.direct.unread(b[:n], err)
+	return c.Conn.Read(b)
+}
+
+func (c *matchConn) Write(b []byte) (int, error) {
+	if c.triggered(&c.writeMatched, b) {
+		return c.Conn.Write(b)
+	}
+	return c.direct.Write(b)
+}
+
+// unreadConn returns data which was pushed back before reading any more.
+type unreadConn struct {
+	net.Conn
+
+	pending []byte
+	err     error
+}
+
+// NetConn returns the underlying connection.
+func (c *unreadConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *unreadConn) buffered() bool {
+	return len(c.pending) > 0 || c.err != nil
+}
+
+// unread pushes data back to be read again, followed by err if it's set.
+func (c *unreadConn) unread(data []byte, err error) {
+	c.pending = append(c.pending, data...)
+	c.err = err
+}
+
+func (c *unreadConn) Read(b []byte) (int, error) {
+	if len(c.pending) > 0 {
+		n := copy(b, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	if err := c.err; err != nil {
+		c.err = nil
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
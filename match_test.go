@@ -0,0 +1,120 @@
+package badnet
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// upperReadConn upper-cases the data it reads
+type upperReadConn struct {
+	net.Conn
+}
+
+func (c *upperReadConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	copy(b, bytes.ToUpper(b[:n]))
+	return n, err
+}
+
+func TestMatch(t *testing.T) {
+	require.True(t, Match{}.matches([]byte("anything")))
+	require.True(t, Match{Prefix: []byte{0xca, 0xfe}}.matches([]byte{0xca, 0xfe, 0x01}))
+	require.False(t, Match{Prefix: []byte{0xca, 0xfe}}.matches([]byte{0x01, 0xca, 0xfe}))
+
+	m := Match{Prefix: []byte("BEGIN"), Pattern: regexp.MustCompile(`TRANSACTION`)}
+	require.True(t, m.matches([]byte("BEGIN TRANSACTION;")))
+	require.False(t, m.matches([]byte("BEGIN;")))
+	require.False(t, m.matches([]byte("COMMIT TRANSACTION;")))
+}
+
+func TestWhenMatchesWrites(t *testing.T) {
+	upper := ToxicFunc(func(c net.Conn, _ ToxicInfo) net.Conn {
+		return &upperConn{Conn: c}
+	})
+
+	rec := &recordingConn{}
+	c := WhenMatches(Match{Prefix: []byte("shout")}, upper).Wrap(rec, ToxicInfo{})
+	for _, msg := range []string{"hello ", "shout this ", "quietly"} {
+		_, err := c.Write([]byte(msg))
+		require.NoError(t, err)
+	}
+	require.Equal(t, "hello SHOUT THIS quietly", rec.buf.String())
+
+	// Sticky matches carry on to the rest of the stream
+	rec = &recordingConn{}
+	c = WhenMatches(Match{Prefix: []byte("shout"), Sticky: true}, upper).Wrap(rec, ToxicInfo{})
+	for _, msg := range []string{"hello ", "shout this ", "quietly"} {
+		_, err := c.Write([]byte(msg))
+		require.NoError(t, err)
+	}
+	require.Equal(t, "hello SHOUT THIS QUIETLY", rec.buf.String())
+}
+
+func TestWhenMatchesReads(t *testing.T) {
+	upper := ToxicFunc(func(c net.Conn, _ ToxicInfo) net.Conn {
+		return &upperReadConn{Conn: c}
+	})
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	c := WhenMatches(Match{Pattern: regexp.MustCompile(`(?i)begin`)}, upper).Wrap(server, ToxicInfo{})
+	go func() {
+		for _, msg := range []string{"select 1;", "begin work;", "select 2;"} {
+			client.Write([]byte(msg))
+		}
+		client.Close()
+	}()
+
+	var got []string
+	buf := make([]byte, 64)
+	for {
+		n, err := c.Read(buf)
+		if n > 0 {
+			got = append(got, string(buf[:n]))
+		}
+		if err != nil {
+			require.ErrorIs(t, err, io.EOF)
+			break
+		}
+	}
+	require.Equal(t, []string{"select 1;", "BEGIN WORK;", "select 2;"}, got)
+}
+
+func TestWhenMatchesProxy(t *testing.T) {
+	proxy := ForTest(t, Config{
+		Target: echoServer(t),
+		Write: Direction{
+			Toxics: []Toxic{
+				WhenMatches(Match{Pattern: regexp.MustCompile(`secret`)}, ToxicFunc(func(c net.Conn, _ ToxicInfo) net.Conn {
+					return &upperConn{Conn: c}
+				})),
+			},
+		},
+	})
+
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	echo := func(msg string) string {
+		_, err := conn.Write([]byte(msg))
+		require.NoError(t, err)
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, len(msg))
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		return string(buf)
+	}
+	require.Equal(t, "public", echo("public"))
+	require.Equal(t, "A SECRET", echo("a secret"))
+	require.Equal(t, "public", echo("public"))
+}
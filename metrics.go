@@ -0,0 +1,156 @@
+package badnet
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Metric names reported to a MetricsSink.
+const (
+	MetricConnections                 = "connections"
+	MetricReadFailures                = "read_failures"
+	MetricWriteFailures               = "write_failures"
+	MetricTargetFailures              = "target_failures"
+	MetricOutages                     = "outages"
+	MetricHandshakeFailures           = "handshake_failures"
+	MetricBadCertificates             = "bad_certificates"
+	MetricClientCertificateRejections = "client_certificate_rejections"
+	MetricFailovers                   = "failovers"
+	MetricRefusedConnections          = "refused_connections"
+	MetricTruncations                 = "truncations"
+	MetricGarbageInjections           = "garbage_injections"
+	MetricDuplications                = "duplications"
+	MetricIdleTimeouts                = "idle_timeouts"
+	MetricConnectionExpirations       = "connection_expirations"
+	MetricEarlyCloses                 = "early_closes"
+	MetricTarpits                     = "tarpits"
+	MetricByteLimits                  = "byte_limits"
+	MetricFailedRequests              = "failed_requests"
+	MetricReorderedResponses          = "reordered_responses"
+	MetricPoolDials                   = "pool_dials"
+	MetricPoolReuses                  = "pool_reuses"
+	MetricStormConnections            = "storm_connections"
+	MetricDroppedDatagrams            = "dropped_datagrams"
+	MetricDuplicatedDatagrams         = "duplicated_datagrams"
+	MetricReorderedDatagrams          = "reordered_datagrams"
+	MetricHijacks                     = "hijacks"
+	MetricOfflineResponses            = "offline_responses"
+
+	MetricTargetDial         = "target_dial"
+	MetricConnectionDuration = "connection_duration"
+)
+
+// MetricsSink receives badnet's counters and latency observations so they can be
+// forwarded into whichever metrics stack a project already uses.
+//
+// Implementations must be safe for concurrent use.
+type MetricsSink interface {
+	IncCounter(name string)
+	ObserveLatency(name string, d time.Duration)
+}
+
+// SinkFuncs adapts plain functions into a MetricsSink. Either field may be nil.
+type SinkFuncs struct {
+	Counter func(name string)
+	Latency func(name string, d time.Duration)
+}
+
+func (s SinkFuncs) IncCounter(name string) {
+	if s.Counter != nil {
+		s.Counter(name)
+	}
+}
+
+func (s SinkFuncs) ObserveLatency(name string, d time.Duration) {
+	if s.Latency != nil {
+		s.Latency(name, d)
+	}
+}
+
+// PrometheusSink forwards metrics into Prometheus collectors without badnet importing
+// the Prometheus client. Latencies are reported in seconds, as Prometheus expects.
+//
+//	badnet.PrometheusSink(
+//	    func(name string) { counters.WithLabelValues(name).Inc() },
+//	    func(name string, secs float64) { histogram.WithLabelValues(name).Observe(secs) },
+//	)
+func PrometheusSink(inc func(name string), observe func(name string, seconds float64)) MetricsSink {
+	return SinkFuncs{
+		Counter: inc,
+		Latency: func(name string, d time.Duration) {
+			if observe != nil {
+				observe(name, d.Seconds())
+			}
+		},
+	}
+}
+
+// OTelSink forwards metrics into OpenTelemetry instruments without badnet importing
+// the OpenTelemetry SDK. Latencies are reported in milliseconds.
+//
+//	badnet.OTelSink(
+//	    func(ctx context.Context, name string, n int64) { counter.Add(ctx, n, metric.WithAttributes(attribute.String("name", name))) },
+//	    func(ctx context.Context, name string, ms float64) { histogram.Record(ctx, ms, metric.WithAttributes(attribute.String("name", name))) },
+//	)
+func OTelSink(add func(ctx context.Context, name string, n int64), record func(ctx context.Context, name string, ms float64)) MetricsSink {
+	return SinkFuncs{
+		Counter: func(name string) {
+			if add != nil {
+				add(context.Background(), name, 1)
+			}
+		},
+		Latency: func(name string, d time.Duration) {
+			if record != nil {
+				record(context.Background(), name, float64(d)/float64(time.Millisecond))
+			}
+		},
+	}
+}
+
+// ExpvarSink publishes metrics under the given expvar name. Counters are stored as
+// integers and latencies as the total observed duration in nanoseconds, suffixed
+// with "_ns", alongside a "_count" of observations.
+//
+// The expvar.Map is created on first use and reused if it already exists. An error
+// is returned if something other than an expvar.Map is published under name.
+func ExpvarSink(name string) (MetricsSink, error) {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+
+	var m *expvar.Map
+	switch v := expvar.Get(name).(type) {
+	case nil:
+		m = expvar.NewMap(name)
+	case *expvar.Map:
+		m = v
+	default:
+		return nil, fmt.Errorf("expvar %q is a %T, not an *expvar.Map", name, v)
+	}
+	return SinkFuncs{
+		Counter: func(name string) {
+			m.Add(name, 1)
+		},
+		Latency: func(name string, d time.Duration) {
+			m.Add(name+"_ns", int64(d))
+			m.Add(name+"_count", 1)
+		},
+	}, nil
+}
+
+// expvarMu stops two ExpvarSinks racing to publish the same name.
+var expvarMu sync.Mutex
+
+func (s *stats) incCounter(name string) {
+	if s.metrics != nil {
+		s.metrics.IncCounter(name)
+	}
+}
+
+func (s *stats) observeLatency(name string, d time.Duration) {
+	if s.metrics != nil {
+		s.metrics.ObserveLatency(name, d)
+	}
+}
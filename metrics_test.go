@@ -0,0 +1,129 @@
+package badnet
+
+import (
+	"context"
+	"expvar"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type recordingSink struct {
+	mu        sync.Mutex
+	counters  map[string]int
+	latencies map[string][]time.Duration
+}
+
+func (r *recordingSink) IncCounter(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counters[name]++
+}
+
+func (r *recordingSink) ObserveLatency(name string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latencies[name] = append(r.latencies[name], d)
+}
+
+func TestMetricsSink(t *testing.T) {
+	sink := &recordingSink{
+		counters:  make(map[string]int),
+		latencies: make(map[string][]time.Duration),
+	}
+	proxy := ForTest(t, Config{
+		Listen:  "127.0.0.1:0",
+		Target:  echoServer(t),
+		Metrics: sink,
+	})
+
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+	require.NoError(t, conn.Close())
+
+	require.Eventually(t, func() bool {
+		sink.mu.Lock()
+		defer sink.mu.Unlock()
+		return len(sink.latencies[MetricConnectionDuration]) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	require.Equal(t, 1, sink.counters[MetricConnections])
+	require.Len(t, sink.latencies[MetricTargetDial], 1)
+}
+
+func TestMetricsAdapters(t *testing.T) {
+	t.Run("Prometheus", func(t *testing.T) {
+		var seconds float64
+		sink := PrometheusSink(nil, func(_ string, secs float64) { seconds = secs })
+		sink.IncCounter(MetricConnections)
+		sink.ObserveLatency(MetricTargetDial, 1500*time.Millisecond)
+		require.InDelta(t, 1.5, seconds, 0.001)
+	})
+
+	t.Run("OTel", func(t *testing.T) {
+		counts := make(map[string]int64)
+		var ms float64
+		sink := OTelSink(
+			func(ctx context.Context, name string, n int64) {
+				require.NotNil(t, ctx)
+				counts[name] += n
+			},
+			func(ctx context.Context, name string, v float64) {
+				require.NotNil(t, ctx)
+				require.Equal(t, MetricTargetDial, name)
+				ms = v
+			},
+		)
+		sink.IncCounter(MetricConnections)
+		sink.IncCounter(MetricConnections)
+		sink.ObserveLatency(MetricTargetDial, 1500*time.Microsecond)
+		require.Equal(t, int64(2), counts[MetricConnections])
+		require.InDelta(t, 1.5, ms, 0.001)
+
+		// Either instrument can be left out
+		sink = OTelSink(nil, nil)
+		sink.IncCounter(MetricConnections)
+		sink.ObserveLatency(MetricTargetDial, time.Second)
+	})
+
+	t.Run("expvar", func(t *testing.T) {
+		name := "badnet_test_" + t.Name()
+		if expvar.Get(name) == nil {
+			expvar.NewMap(name)
+		}
+		m, ok := expvar.Get(name).(*expvar.Map)
+		require.True(t, ok)
+		m.Init()
+
+		sink, err := ExpvarSink(name)
+		require.NoError(t, err)
+		sink.IncCounter(MetricConnections)
+		sink.IncCounter(MetricConnections)
+		sink.ObserveLatency(MetricTargetDial, time.Second)
+
+		require.Equal(t, "2", m.Get(MetricConnections).String())
+		require.Equal(t, "1", m.Get(MetricTargetDial+"_count").String())
+
+		// Names already published as something else aren't replaced
+		other := name + "_int"
+		if expvar.Get(other) == nil {
+			expvar.NewInt(other)
+		}
+		_, err = ExpvarSink(other)
+		require.Error(t, err)
+	})
+}
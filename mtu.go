@@ -0,0 +1,63 @@
+package badnet
+
+import (
+	"net"
+)
+
+// mtuConn never moves more than a Direction's MTU in a single read or write of
+// the underlying connection, splitting larger writes into several.
+type mtuConn struct {
+	net.Conn
+
+	read, write int
+}
+
+// NetConn returns the underlying connection.
+func (c *mtuConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func mtu(c net.Conn, read, write Direction) net.Conn {
+	if read.MTU <= 0 && write.MTU <= 0 {
+		return c
+	}
+	return &mtuConn{
+		Conn:  c,
+		read:  read.MTU,
+		write: write.MTU,
+	}
+}
+
+// mtuToxic limits the size of each read and write to each Direction's MTU.
+func mtuToxic(read, write Direction) Toxic {
+	return ToxicFunc(func(c net.Conn, _ ToxicInfo) net.Conn {
+		return mtu(c, read, write)
+	})
+}
+
+func (c *mtuConn) Read(b []byte) (int, error) {
+	if c.read > 0 && len(b) > c.read {
+		b = b[:c.read]
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *mtuConn) Write(b []byte) (int, error) {
+	if c.write <= 0 {
+		return c.Conn.Write(b)
+	}
+	var written int
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > c.write {
+			chunk = chunk[:c.write]
+		}
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
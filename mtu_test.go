@@ -0,0 +1,65 @@
+package badnet
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMTU(t *testing.T) {
+	dst := &recordingConn{}
+	c := mtu(dst, Direction{}, Direction{MTU: 3})
+
+	n, err := c.Write([]byte("pingpong"))
+	require.NoError(t, err)
+	require.Equal(t, 8, n)
+	require.Equal(t, "pingpong", dst.buf.String())
+	require.Equal(t, []int{3, 3, 2}, dst.writes)
+
+	// Nothing is wrapped without an MTU
+	require.Equal(t, net.Conn(dst), mtu(dst, Direction{}, Direction{}))
+}
+
+func TestMTUProxy(t *testing.T) {
+	proxy := ForTest(t, Config{
+		Listen:       "127.0.0.1:0",
+		Target:       echoServer(t),
+		PreserveHost: true,
+		Read:         Direction{MTU: 100},
+	})
+	require.False(t, proxy.fastPath(proxy.route("", nil)))
+
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	payload := bytes.Repeat([]byte("a"), 10_000)
+	go conn.Write(payload)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got := make([]byte, len(payload))
+	_, err = io.ReadFull(conn, got)
+	require.NoError(t, err)
+	require.Equal(t, payload, got)
+}
+
+func TestDropOversized(t *testing.T) {
+	wrapped, peer := packetPair(t, Config{
+		Write: Direction{
+			MTU:       5,
+			Datagrams: Datagrams{DropOversized: true},
+		},
+	})
+
+	_, err := wrapped.WriteTo([]byte("small"), peer.LocalAddr())
+	require.NoError(t, err)
+	_, err = wrapped.WriteTo([]byte("too large"), peer.LocalAddr())
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"small"}, readDatagrams(t, peer, 100*time.Millisecond))
+	require.Equal(t, uint32(1), wrapped.Stats().DroppedDatagrams)
+}
@@ -0,0 +1,122 @@
+package badnet
+
+import (
+	"bytes"
+	"net"
+	"sync"
+)
+
+// maxRecordedResponse bounds how much of each response is kept for OfflineFallback.
+// Larger responses aren't recorded.
+const maxRecordedResponse = 1 << 20
+
+// offlineResponses holds the most recent response the target gave to each request,
+// so they can be served while the target is unreachable.
+type offlineResponses struct {
+	mu        sync.Mutex
+	responses map[string][]byte
+}
+
+func newOfflineResponses(conf Config) *offlineResponses {
+	if !conf.OfflineFallback {
+		return nil
+	}
+	return &offlineResponses{responses: make(map[string][]byte)}
+}
+
+// requestKey matches HTTP requests by their request line, such as "GET /users HTTP/1.1",
+// and other protocols by the exact bytes of the request.
+func requestKey(request []byte) string {
+	if line, _, ok := bytes.Cut(request, []byte("\r\n")); ok && bytes.Contains(line, []byte(" HTTP/1.")) {
+		return string(line)
+	}
+	return string(request)
+}
+
+func (o *offlineResponses) store(request, response []byte) {
+	if len(request) == 0 || len(response) == 0 || len(response) > maxRecordedResponse {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.responses[requestKey(request)] = response
+}
+
+func (o *offlineResponses) lookup(request []byte) ([]byte, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	response, ok := o.responses[requestKey(request)]
+	return response, ok
+}
+
+// serve answers each request read from c with its recorded response, until a request
+// without one arrives or the client closes. onServed is called for each response.
+func (o *offlineResponses) serve(c net.Conn, buf []byte, onServed func()) {
+	for {
+		n, _ := c.Read(buf)
+		if n == 0 {
+			return
+		}
+		response, ok := o.lookup(buf[:n])
+		if !ok {
+			return
+		}
+		if _, err := c.Write(response); err != nil {
+			return
+		}
+		onServed()
+	}
+}
+
+// responseRecorder records each request read from a client and the response written
+// back, assuming they take turns as in HTTP/1.1 and most RPC protocols.
+type responseRecorder struct {
+	net.Conn
+
+	responses *offlineResponses
+
+	mu       sync.Mutex
+	request  []byte
+	response []byte
+}
+
+// NetConn returns the underlying connection.
+func (c *responseRecorder) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *responseRecorder) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.mu.Lock()
+		if len(c.response) > 0 {
+			// A new request begins once the last one has been answered
+			c.responses.store(c.request, c.response)
+			c.request, c.response = nil, nil
+		}
+		c.request = append(c.request, b[:n]...)
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+func (c *responseRecorder) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	if len(c.request) > 0 && len(c.response) <= maxRecordedResponse {
+		c.response = append(c.response, b...)
+	}
+	c.mu.Unlock()
+
+	return c.Conn.Write(b)
+}
+
+func (c *responseRecorder) Close() error {
+	c.mu.Lock()
+	c.responses.store(c.request, c.response)
+	c.request, c.response = nil, nil
+	c.mu.Unlock()
+
+	return c.Conn.Close()
+}
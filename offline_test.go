@@ -0,0 +1,67 @@
+package badnet
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOfflineFallback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fresh " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	proxy := ForTest(t, Config{
+		Target:          server.URL,
+		OfflineFallback: true,
+	})
+	client := &http.Client{
+		Transport: &http.Transport{DisableKeepAlives: true},
+		Timeout:   5 * time.Second,
+	}
+	get := func(path string) (string, error) {
+		resp, err := client.Get("http://" + proxy.BindAddr() + path)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	for _, path := range []string{"/a", "/b"} {
+		body, err := get(path)
+		require.NoError(t, err)
+		require.Equal(t, "fresh "+path, body)
+	}
+	server.Close()
+
+	// Recorded responses are served once the target is gone
+	require.Eventually(t, func() bool {
+		body, err := get("/a")
+		return err == nil && body == "fresh /a"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	body, err := get("/b")
+	require.NoError(t, err)
+	require.Equal(t, "fresh /b", body)
+
+	_, err = get("/never-seen")
+	require.Error(t, err)
+
+	stats := proxy.Stats()
+	require.GreaterOrEqual(t, stats.OfflineResponses, uint32(2))
+	require.GreaterOrEqual(t, stats.TargetFailures, uint32(3))
+}
+
+func TestRequestKey(t *testing.T) {
+	require.Equal(t, "GET /users HTTP/1.1", requestKey([]byte("GET /users HTTP/1.1\r\nHost: example.com\r\n\r\n")))
+	require.Equal(t, "PING\r\n", requestKey([]byte("PING\r\n")))
+	require.Equal(t, "\x00\x01", requestKey([]byte{0, 1}))
+}
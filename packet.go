@@ -0,0 +1,409 @@
+package badnet
+
+import (
+	"container/heap"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// PacketConn applies a Config's faults to datagrams read and written through a
+// net.PacketConn. Read faults apply to ReadFrom and Write faults to WriteTo.
+//
+// FailureRatio, FailureProbability and FailureBasisPoints drop datagrams as
+// failures, Latency and LatencyPerKB delay them, and Direction.Datagrams drops,
+// duplicates and reorders them. Writes which are dropped or delayed still report
+// success, as a real network would.
+//
+// Read deadlines are measured on Config.Clock, so tests using a FakeClock set them
+// from its Now.
+type PacketConn struct {
+	net.PacketConn
+
+	read, write *datagramFaults
+
+	// incoming holds datagrams which have made it through the read faults
+	incoming chan datagram
+
+	mu              sync.Mutex
+	readDeadline    time.Time
+	deadlineChanged chan struct{}
+
+	closeOnce sync.Once
+	done      chan struct{}
+	wg        sync.WaitGroup
+
+	clock Clock
+	stats *stats
+}
+
+// incomingDatagrams is how many datagrams are held for ReadFrom before more are
+// dropped, like a full socket buffer.
+const incomingDatagrams = 1024
+
+// WrapPacketConn applies conf's Read and Write faults to pc, so UDP code can be
+// tested without a proxy. Closing the returned PacketConn closes pc.
+//
+// Unlike ForTest, BADNET_ environment variables aren't read. Pass the Config
+// through ApplyEnv first to use them.
+func WrapPacketConn(pc net.PacketConn, conf Config) *PacketConn {
+	return wrapPacketConn(pc, conf, &stats{metrics: conf.Metrics})
+}
+
+func wrapPacketConn(pc net.PacketConn, conf Config, s *stats) *PacketConn {
+	c := &PacketConn{
+		PacketConn:      pc,
+		deadlineChanged: make(chan struct{}),
+		done:            make(chan struct{}),
+		clock:           conf.clock(),
+		stats:           s,
+	}
+	if !conf.Write.datagramPassive() {
+		c.write = newDatagramFaults(conf.Write, conf.clock(), c.send, s.writeFailed, s)
+	}
+	if !conf.Read.datagramPassive() {
+		c.incoming = make(chan datagram, incomingDatagrams)
+		c.read = newDatagramFaults(conf.Read, conf.clock(), c.receive, s.readFailed, s)
+
+		c.wg.Add(1)
+		go c.readLoop()
+	}
+	return c
+}
+
+// Stats returns a snapshot of the datagrams dropped and duplicated so far.
+func (c *PacketConn) Stats() Stats {
+	return c.stats.Stats()
+}
+
+func (c *PacketConn) readLoop() {
+	defer c.wg.Done()
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		c.read.forward(buf[:n], addr)
+	}
+}
+
+// receive holds a datagram for ReadFrom, dropping it when too many are waiting.
+func (c *PacketConn) receive(b []byte, addr net.Addr) {
+	select {
+	case c.incoming <- datagram{data: b, addr: addr}:
+	default:
+	}
+}
+
+func (c *PacketConn) send(b []byte, addr net.Addr) {
+	if n, err := c.PacketConn.WriteTo(b, addr); err == nil {
+		c.stats.writeBytes.Add(uint64(n))
+	}
+}
+
+func (c *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	if c.read == nil {
+		n, addr, err := c.PacketConn.ReadFrom(b)
+		c.stats.readBytes.Add(uint64(n))
+		return n, addr, err
+	}
+
+	for {
+		c.mu.Lock()
+		deadline, changed := c.readDeadline, c.deadlineChanged
+		c.mu.Unlock()
+
+		// Deadlines are measured on the Config's Clock
+		var timeout <-chan time.Time
+		stopTimeout := func() {}
+		if !deadline.IsZero() {
+			timeout, stopTimeout = after(c.clock, deadline.Sub(c.clock.Now()))
+		}
+
+		select {
+		case d := <-c.incoming:
+			stopTimeout()
+			n := copy(b, d.data)
+			c.stats.readBytes.Add(uint64(n))
+			return n, d.addr, nil
+
+		case <-timeout:
+			return 0, nil, &net.OpError{Op: "read", Net: c.LocalAddr().Network(), Addr: c.LocalAddr(), Err: os.ErrDeadlineExceeded}
+
+		case <-changed:
+			stopTimeout()
+
+		case <-c.done:
+			stopTimeout()
+			return 0, nil, &net.OpError{Op: "read", Net: c.LocalAddr().Network(), Addr: c.LocalAddr(), Err: net.ErrClosed}
+		}
+	}
+}
+
+func (c *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if c.write == nil {
+		n, err := c.PacketConn.WriteTo(b, addr)
+		c.stats.writeBytes.Add(uint64(n))
+		return n, err
+	}
+	select {
+	case <-c.done:
+		return 0, &net.OpError{Op: "write", Net: c.LocalAddr().Network(), Addr: addr, Err: net.ErrClosed}
+	default:
+	}
+	c.write.forward(b, addr)
+	return len(b), nil
+}
+
+func (c *PacketConn) SetDeadline(t time.Time) error {
+	if c.read == nil {
+		return c.PacketConn.SetDeadline(t)
+	}
+	c.SetReadDeadline(t)
+	return c.PacketConn.SetWriteDeadline(t)
+}
+
+func (c *PacketConn) SetReadDeadline(t time.Time) error {
+	if c.read == nil {
+		return c.PacketConn.SetReadDeadline(t)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.readDeadline = t
+	close(c.deadlineChanged)
+	c.deadlineChanged = make(chan struct{})
+	return nil
+}
+
+func (c *PacketConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.done)
+		err = c.PacketConn.Close()
+		if c.read != nil {
+			c.read.close()
+		}
+		if c.write != nil {
+			c.write.close()
+		}
+		c.wg.Wait()
+	})
+	return err
+}
+
+// Datagrams are faults which are applied to whole datagrams, the usual ways UDP
+// traffic goes wrong. Each ratio is a percentage of datagrams.
+type Datagrams struct {
+	// DropRatio is how many datagrams are silently lost.
+	DropRatio int
+
+	// DuplicateRatio is how many datagrams are delivered twice.
+	DuplicateRatio int
+
+	// ReorderRatio is how many datagrams are held back by a random delay of up to
+	// ReorderWindow, 20ms by default, letting later datagrams overtake them.
+	ReorderRatio  int
+	ReorderWindow time.Duration
+
+	// DropOversized drops datagrams larger than the Direction's MTU, like a path
+	// which doesn't fragment them.
+	DropOversized bool
+}
+
+const defaultReorderWindow = 20 * time.Millisecond
+
+func (d Datagrams) enabled() bool {
+	return d.DropRatio > 0 || d.DuplicateRatio > 0 || d.ReorderRatio > 0
+}
+
+func (d Datagrams) reorderWindow() time.Duration {
+	if d.ReorderWindow > 0 {
+		return d.ReorderWindow
+	}
+	return defaultReorderWindow
+}
+
+// datagramPassive reports if d has no faults which apply to datagrams.
+func (d Direction) datagramPassive() bool {
+	return d.failureProbability() == 0 && d.Latency == 0 && d.LatencyPerKB == 0 &&
+		!d.Datagrams.enabled() && !d.dropsOversized()
+}
+
+func (d Direction) dropsOversized() bool {
+	return d.Datagrams.DropOversized && d.MTU > 0
+}
+
+// datagramFaults applies a Direction's faults to individual datagrams. Data is never
+// split or merged, so each datagram is dropped, delayed or repeated whole.
+type datagramFaults struct {
+	dir   Direction
+	queue *datagramQueue
+
+	onFailure func()
+	stats     *stats
+}
+
+func newDatagramFaults(dir Direction, clock Clock, send func(b []byte, addr net.Addr), onFailure func(), s *stats) *datagramFaults {
+	return &datagramFaults{
+		dir:       dir,
+		queue:     newDatagramQueue(send, clock),
+		onFailure: onFailure,
+		stats:     s,
+	}
+}
+
+// forward drops, delays, duplicates or reorders b on its way to addr. b is copied so
+// the caller can reuse it.
+func (f *datagramFaults) forward(b []byte, addr net.Addr) {
+	if shouldFailProbability(f.dir.failureProbability()) {
+		f.onFailure()
+		return
+	}
+	faults := f.dir.Datagrams
+	if shouldFail(faults.DropRatio) || (f.dir.dropsOversized() && len(b) > f.dir.MTU) {
+		f.stats.datagramDropped()
+		return
+	}
+
+	copies := 1
+	if shouldFail(faults.DuplicateRatio) {
+		f.stats.datagramDuplicated()
+		copies++
+	}
+
+	delay := f.dir.Latency + time.Duration(float64(f.dir.LatencyPerKB)*float64(len(b))/1024)
+	for i := 0; i < copies; i++ {
+		held := delay
+		if shouldFail(faults.ReorderRatio) {
+			f.stats.datagramReordered()
+			held = withJitter(delay, faults.reorderWindow())
+		}
+		f.queue.push(append([]byte(nil), b...), addr, held)
+	}
+}
+
+func (f *datagramFaults) close() {
+	f.queue.close()
+}
+
+// datagramQueue sends datagrams once they're due, in the order they fall due.
+type datagramQueue struct {
+	send  func(b []byte, addr net.Addr)
+	clock Clock
+
+	mu      sync.Mutex
+	pending datagramHeap
+	seq     uint64
+	closed  bool
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+type datagram struct {
+	data []byte
+	addr net.Addr
+	due  time.Time
+	seq  uint64
+}
+
+func newDatagramQueue(send func(b []byte, addr net.Addr), clock Clock) *datagramQueue {
+	q := &datagramQueue{
+		send:  send,
+		clock: clock,
+		wake:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+func (q *datagramQueue) push(b []byte, addr net.Addr, delay time.Duration) {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	// Datagrams without a delay skip the queue unless others are waiting ahead of them
+	if delay <= 0 && len(q.pending) == 0 {
+		q.mu.Unlock()
+		q.send(b, addr)
+		return
+	}
+	q.seq++
+	heap.Push(&q.pending, datagram{data: b, addr: addr, due: q.clock.Now().Add(delay), seq: q.seq})
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *datagramQueue) run() {
+	for {
+		q.mu.Lock()
+		var next *datagram
+		var due <-chan time.Time
+		if len(q.pending) > 0 {
+			if wait := q.pending[0].due.Sub(q.clock.Now()); wait > 0 {
+				due = q.clock.After(wait)
+			} else {
+				d := heap.Pop(&q.pending).(datagram)
+				next = &d
+			}
+		}
+		q.mu.Unlock()
+
+		if next != nil {
+			q.send(next.data, next.addr)
+			continue
+		}
+
+		select {
+		case <-q.done:
+			return
+		case <-q.wake:
+		case <-due:
+		}
+	}
+}
+
+// close discards any datagrams which haven't been sent.
+func (q *datagramQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		q.pending = nil
+		close(q.done)
+	}
+}
+
+// datagramHeap orders datagrams by when they're due, then by when they were queued.
+type datagramHeap []datagram
+
+func (h datagramHeap) Len() int { return len(h) }
+func (h datagramHeap) Less(i, j int) bool {
+	if h[i].due.Equal(h[j].due) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].due.Before(h[j].due)
+}
+func (h datagramHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *datagramHeap) Push(x any)   { *h = append(*h, x.(datagram)) }
+func (h *datagramHeap) Pop() any {
+	old := *h
+	d := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return d
+}
@@ -0,0 +1,227 @@
+package badnet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func packetPair(t *testing.T, conf Config) (*PacketConn, net.PacketConn) {
+	t.Helper()
+
+	a, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	b, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	wrapped := WrapPacketConn(a, conf)
+	t.Cleanup(func() {
+		wrapped.Close()
+		b.Close()
+	})
+	return wrapped, b
+}
+
+func readDatagrams(t *testing.T, conn net.PacketConn, wait time.Duration) []string {
+	t.Helper()
+
+	var out []string
+	buf := make([]byte, 1024)
+	for {
+		conn.SetReadDeadline(time.Now().Add(wait))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			var nerr net.Error
+			require.True(t, errors.As(err, &nerr) && nerr.Timeout(), "unexpected error: %v", err)
+			return out
+		}
+		out = append(out, string(buf[:n]))
+	}
+}
+
+func TestPacketConnPassive(t *testing.T) {
+	wrapped, peer := packetPair(t, Config{})
+
+	_, err := wrapped.WriteTo([]byte("hello"), peer.LocalAddr())
+	require.NoError(t, err)
+	require.Equal(t, []string{"hello"}, readDatagrams(t, peer, 100*time.Millisecond))
+
+	_, err = peer.WriteTo([]byte("world"), wrapped.LocalAddr())
+	require.NoError(t, err)
+	require.Equal(t, []string{"world"}, readDatagrams(t, wrapped, 100*time.Millisecond))
+}
+
+func TestPacketConnDrops(t *testing.T) {
+	wrapped, peer := packetPair(t, Config{
+		Read:  Direction{FailureRatio: 100},
+		Write: Direction{FailureRatio: 100},
+	})
+
+	// Dropped writes still succeed
+	n, err := wrapped.WriteTo([]byte("hello"), peer.LocalAddr())
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+	require.Empty(t, readDatagrams(t, peer, 50*time.Millisecond))
+
+	_, err = peer.WriteTo([]byte("world"), wrapped.LocalAddr())
+	require.NoError(t, err)
+	require.Empty(t, readDatagrams(t, wrapped, 50*time.Millisecond))
+
+	stats := wrapped.Stats()
+	require.Equal(t, uint32(1), stats.ReadFailures)
+	require.Equal(t, uint32(1), stats.WriteFailures)
+}
+
+func TestPacketConnLatency(t *testing.T) {
+	wrapped, peer := packetPair(t, Config{
+		Read: Direction{Latency: 50 * time.Millisecond},
+	})
+
+	start := time.Now()
+	_, err := peer.WriteTo([]byte("hello"), wrapped.LocalAddr())
+	require.NoError(t, err)
+
+	wrapped.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 10)
+	n, addr, err := wrapped.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf[:n]))
+	require.Equal(t, peer.LocalAddr().String(), addr.String())
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestPacketConnDuplicates(t *testing.T) {
+	wrapped, peer := packetPair(t, Config{
+		Write: Direction{
+			Datagrams: Datagrams{DuplicateRatio: 100},
+		},
+	})
+
+	_, err := wrapped.WriteTo([]byte("hello"), peer.LocalAddr())
+	require.NoError(t, err)
+	require.Equal(t, []string{"hello", "hello"}, readDatagrams(t, peer, 100*time.Millisecond))
+	require.Equal(t, uint32(1), wrapped.Stats().DuplicatedDatagrams)
+}
+
+func TestPacketConnReorder(t *testing.T) {
+	wrapped, peer := packetPair(t, Config{
+		Write: Direction{
+			Datagrams: Datagrams{ReorderRatio: 100, ReorderWindow: 50 * time.Millisecond},
+		},
+	})
+
+	var sent []string
+	for i := 0; i < 20; i++ {
+		msg := fmt.Sprintf("%02d", i)
+		sent = append(sent, msg)
+		_, err := wrapped.WriteTo([]byte(msg), peer.LocalAddr())
+		require.NoError(t, err)
+	}
+
+	received := readDatagrams(t, peer, 200*time.Millisecond)
+	require.NotEqual(t, sent, received)
+
+	sort.Strings(received)
+	require.Equal(t, sent, received)
+	require.Equal(t, uint32(20), wrapped.Stats().ReorderedDatagrams)
+}
+
+func TestPacketConnDatagramDrops(t *testing.T) {
+	wrapped, peer := packetPair(t, Config{
+		Read: Direction{
+			Datagrams: Datagrams{DropRatio: 100},
+		},
+	})
+
+	for i := 0; i < 3; i++ {
+		_, err := peer.WriteTo([]byte("hello"), wrapped.LocalAddr())
+		require.NoError(t, err)
+	}
+	require.Empty(t, readDatagrams(t, wrapped, 50*time.Millisecond))
+
+	stats := wrapped.Stats()
+	require.Equal(t, uint32(3), stats.DroppedDatagrams)
+	require.Zero(t, stats.ReadFailures)
+}
+
+func TestDatagramsPartialReorder(t *testing.T) {
+	sent := make(chan string, 100)
+	faults := newDatagramFaults(Direction{
+		Datagrams: Datagrams{ReorderRatio: 50},
+	}, realClock{}, func(b []byte, _ net.Addr) {
+		sent <- string(b)
+	}, func() {}, &stats{})
+	defer faults.close()
+
+	for i := 0; i < 100; i++ {
+		faults.forward([]byte("x"), nil)
+	}
+	reordered := faults.stats.reorderedDatagrams.Load()
+	require.Greater(t, reordered, uint32(20))
+	require.Less(t, reordered, uint32(80))
+}
+
+func TestPacketConnClose(t *testing.T) {
+	wrapped, _ := packetPair(t, Config{
+		Read: Direction{Latency: time.Millisecond},
+	})
+
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := wrapped.ReadFrom(make([]byte, 10))
+		errs <- err
+	}()
+	require.NoError(t, wrapped.Close())
+
+	select {
+	case err := <-errs:
+		require.ErrorIs(t, err, net.ErrClosed)
+	case <-time.After(time.Second):
+		t.Fatal("ReadFrom didn't return after Close")
+	}
+}
+
+func TestPacketConnDeadlineClock(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	wrapped, _ := packetPair(t, Config{
+		Read:  Direction{Latency: time.Millisecond},
+		Clock: clock,
+	})
+
+	errs := make(chan error, 1)
+	go func() {
+		wrapped.SetReadDeadline(clock.Now().Add(time.Minute))
+		_, _, err := wrapped.ReadFrom(make([]byte, 10))
+		errs <- err
+	}()
+	require.Eventually(t, func() bool {
+		return clock.Waiters() == 1
+	}, time.Second, time.Millisecond)
+
+	clock.Advance(time.Minute)
+	select {
+	case err := <-errs:
+		require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	case <-time.After(time.Second):
+		t.Fatal("ReadFrom didn't return at the deadline")
+	}
+
+	// Reads which end before their deadline stop waiting on the clock
+	go func() {
+		wrapped.SetReadDeadline(clock.Now().Add(time.Minute))
+		_, _, err := wrapped.ReadFrom(make([]byte, 10))
+		errs <- err
+	}()
+	require.Eventually(t, func() bool {
+		return clock.Waiters() == 1
+	}, time.Second, time.Millisecond)
+	require.NoError(t, wrapped.Close())
+	require.ErrorIs(t, <-errs, net.ErrClosed)
+	require.Zero(t, clock.Waiters())
+}
@@ -0,0 +1,73 @@
+package badnet
+
+import (
+	"time"
+)
+
+// Perspective is which end of a connection a Network's poor conditions are near.
+type Perspective int
+
+const (
+	// NearClient is a poor network on the client's side, such as a phone with a weak
+	// signal, so connecting to the proxy is slow too.
+	NearClient Perspective = iota + 1
+
+	// NearServer is a poor network in front of the server, such as a congested
+	// datacenter link, so the proxy's dials to the target are slow too.
+	NearServer
+)
+
+// Network describes conditions from the client's point of view, which is mapped
+// onto a Proxy's or Dialer's Read and Write for it. The directions mean different
+// things for each: a Proxy reads what the client uploads, while a Dialer reads
+// what the client downloads.
+//
+// Settings which are set replace those in Read and Write, and environment variables
+// from ApplyEnv are applied afterwards.
+type Network struct {
+	// Near decides which connection setup is slowed by Latency on a Proxy: accepting
+	// the client's connection (ConnectDelay) or dialing the target (DialLatency).
+	// Dialers aren't affected.
+	Near Perspective
+
+	// Latency is the round trip time added, split between the two directions.
+	Latency time.Duration
+
+	// Loss is the FailureProbability of reads and writes in both directions.
+	Loss float64
+
+	// Upload limits data sent by the client and Download what it receives.
+	Upload, Download Rate
+}
+
+// apply maps the network onto conf for a Proxy, or a Dialer when dialer is set.
+func (n Network) apply(conf Config, dialer bool) Config {
+	up, down := &conf.Read, &conf.Write
+	if dialer {
+		up, down = &conf.Write, &conf.Read
+	}
+
+	if n.Latency > 0 {
+		up.Latency = n.Latency / 2
+		down.Latency = n.Latency - up.Latency
+
+		switch {
+		case dialer:
+		case n.Near == NearClient:
+			conf.ConnectDelay = n.Latency
+		case n.Near == NearServer:
+			conf.DialLatency = n.Latency
+		}
+	}
+	if n.Loss > 0 {
+		up.FailureProbability = n.Loss
+		down.FailureProbability = n.Loss
+	}
+	if n.Upload > 0 {
+		up.Bandwidth = n.Upload
+	}
+	if n.Download > 0 {
+		down.Bandwidth = n.Download
+	}
+	return conf
+}
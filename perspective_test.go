@@ -0,0 +1,65 @@
+package badnet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetwork(t *testing.T) {
+	slow := Network{
+		Latency:  100 * time.Millisecond,
+		Loss:     0.01,
+		Upload:   Mbit,
+		Download: 10 * Mbit,
+	}
+
+	t.Run("Proxy", func(t *testing.T) {
+		near := slow
+		near.Near = NearClient
+		conf := near.apply(Config{}, false)
+
+		// Proxies read what clients upload
+		require.Equal(t, Mbit, conf.Read.Bandwidth)
+		require.Equal(t, 10*Mbit, conf.Write.Bandwidth)
+		require.Equal(t, 50*time.Millisecond, conf.Read.Latency)
+		require.Equal(t, 50*time.Millisecond, conf.Write.Latency)
+		require.Equal(t, 0.01, conf.Write.FailureProbability)
+		require.Equal(t, 100*time.Millisecond, conf.ConnectDelay)
+		require.Zero(t, conf.DialLatency)
+
+		near.Near = NearServer
+		conf = near.apply(Config{}, false)
+		require.Zero(t, conf.ConnectDelay)
+		require.Equal(t, 100*time.Millisecond, conf.DialLatency)
+	})
+
+	t.Run("Dialer", func(t *testing.T) {
+		conf := slow.apply(Config{}, true)
+
+		// Dialers read what clients download
+		require.Equal(t, 10*Mbit, conf.Read.Bandwidth)
+		require.Equal(t, Mbit, conf.Write.Bandwidth)
+		require.Zero(t, conf.ConnectDelay)
+	})
+
+	t.Run("unset", func(t *testing.T) {
+		base := Config{Read: Direction{Latency: time.Second, Bandwidth: Kbit}}
+		conf := Network{Download: Mbit}.apply(base, false)
+
+		// Only the settings given are replaced
+		require.Equal(t, base.Read, conf.Read)
+		require.Equal(t, Mbit, conf.Write.Bandwidth)
+	})
+
+	t.Run("ForTest", func(t *testing.T) {
+		proxy := ForTest(t, Config{
+			Listen:    "127.0.0.1:0",
+			Target:    echoServer(t),
+			IgnoreEnv: true,
+			Network:   Network{Near: NearServer, Download: Mbit},
+		})
+		require.Equal(t, Mbit, proxy.EffectiveConfig().Write.Bandwidth)
+	})
+}
@@ -0,0 +1,191 @@
+package badnet
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TargetPool reuses connections to each target between client connections instead
+// of dialing the target for every one, which avoids running out of ephemeral ports
+// in tests making thousands of short connections.
+//
+// Pooling treats traffic as HTTP/1: requests are read from each client, with the
+// client's faults applied, and sent over the pooled connections, so requests from
+// many clients share them. Upgrades such as WebSockets aren't supported, and
+// pooling is skipped for SOCKS5 and Transparent connections.
+//
+// IdleTimeout, MaxConnectionAge, MaxBytesPerConnection and CloseMode apply to the
+// client's connection, as pooled connections outlive it. ForTest fails when Backups,
+// OfflineFallback or ConnectionStorm are set, since they work on a client's own
+// connection to the target.
+type TargetPool struct {
+	// Size is the most connections kept open to each target, zero disables pooling.
+	// Requests wait for a connection once Size are busy.
+	Size int
+
+	// IdleTimeout closes pooled connections which haven't been used for the
+	// duration, defaulting to 90 seconds.
+	IdleTimeout time.Duration
+}
+
+func (p TargetPool) enabled() bool {
+	return p.Size > 0
+}
+
+const defaultPoolIdleTimeout = 90 * time.Second
+
+// targetPool holds an http.Transport for each target connections are routed to.
+type targetPool struct {
+	conf TargetPool
+
+	mu         sync.Mutex
+	transports map[poolKey]*http.Transport
+}
+
+// poolKey separates the connections to a target made with different TLS configs,
+// such as by SNIRoutes sharing a target.
+type poolKey struct {
+	target string
+	tls    *tls.Config
+}
+
+func newTargetPool(conf Config) (*targetPool, error) {
+	if !conf.TargetPool.enabled() {
+		return nil, nil
+	}
+	var unsupported []string
+	if len(conf.Backups) > 0 {
+		unsupported = append(unsupported, "Backups")
+	}
+	if conf.OfflineFallback {
+		unsupported = append(unsupported, "OfflineFallback")
+	}
+	if conf.ConnectionStorm.Ratio > 0 {
+		unsupported = append(unsupported, "ConnectionStorm")
+	}
+	if len(unsupported) > 0 {
+		return nil, fmt.Errorf("TargetPool can't be used with %s", strings.Join(unsupported, ", "))
+	}
+	return &targetPool{
+		conf:       conf.TargetPool,
+		transports: make(map[poolKey]*http.Transport),
+	}, nil
+}
+
+// transport returns the pool for rt's target, creating it with dial the first
+// time the target is used.
+func (tp *targetPool) transport(rt route, dial func(ctx context.Context) (net.Conn, error)) *http.Transport {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	key := poolKey{target: rt.target, tls: rt.targetTLS}
+	if tr, ok := tp.transports[key]; ok {
+		return tr
+	}
+	idle := tp.conf.IdleTimeout
+	if idle <= 0 {
+		idle = defaultPoolIdleTimeout
+	}
+	tr := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dial(ctx)
+		},
+		TLSClientConfig:     rt.targetTLS,
+		MaxConnsPerHost:     tp.conf.Size,
+		MaxIdleConnsPerHost: tp.conf.Size,
+		IdleConnTimeout:     idle,
+		DisableCompression:  true,
+	}
+	tp.transports[key] = tr
+	return tr
+}
+
+// close hangs up every idle pooled connection.
+func (tp *targetPool) close() {
+	if tp == nil {
+		return
+	}
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	for _, tr := range tp.transports {
+		tr.CloseIdleConnections()
+	}
+}
+
+// pooled forwards each HTTP request read from conn to rt's target over a pooled
+// connection and writes the response back, until either side closes.
+func (p *Proxy) pooled(ctx context.Context, conn net.Conn, rt route, number uint32) {
+	p.recordConnection(ctx, conn, ConnectionInfo{Number: number, Target: rt.target, Hijacked: rt.hijacked})
+
+	conn, _, stopLimits := p.limitLifetime(conn, nil)
+	defer stopLimits()
+
+	tr := p.pool.transport(rt, func(ctx context.Context) (net.Conn, error) {
+		start := time.Now()
+		target, err := p.dialer.dial(ctx, rt.target, nil)
+		if err != nil {
+			return nil, err
+		}
+		p.targetDialed(time.Since(start))
+		p.poolDialed()
+		p.conf.TargetKeepAlive.apply(target)
+		setNagle(target, p.conf.TargetNagle)
+		return target, nil
+	})
+	scheme, host := "http", rt.target
+	if rt.targetTLS != nil {
+		scheme = "https"
+	}
+	if _, ok := unixSocketPath(rt.target); ok {
+		// The transport's dial ignores the address, but URLs need a valid host
+		host = "localhost"
+	}
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				p.poolReused()
+			}
+		},
+	}
+
+	requests := bufio.NewReader(io.TeeReader(conn, countingWriter{w: io.Discard, count: p.forwardedRead}))
+	responses := countingWriter{w: conn, count: p.forwardedWrite}
+	for {
+		req, err := http.ReadRequest(requests)
+		if err != nil {
+			return
+		}
+		// Clients hanging up don't close the pooled connection
+		clientClose := req.Close
+		req.Close = false
+		req.Header.Del("Connection")
+
+		req.RequestURI = ""
+		req.URL.Scheme, req.URL.Host = scheme, host
+		req = req.WithContext(httptrace.WithClientTrace(ctx, trace))
+
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			p.targetFailures.Add(1)
+			p.incCounter(MetricTargetFailures)
+			fmt.Fprintf(responses, "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+			return
+		}
+		resp.Close = resp.Close || clientClose
+		err = resp.Write(responses)
+		resp.Body.Close()
+		if err != nil || resp.Close {
+			return
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package badnet
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTargetPool(t *testing.T) {
+	var mu sync.Mutex
+	remotes := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		remotes[r.RemoteAddr] = true
+		mu.Unlock()
+
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte(r.Method + " " + string(body)))
+	}))
+	t.Cleanup(server.Close)
+
+	proxy := ForHTTPTest(t, server, Config{
+		TargetPool: TargetPool{Size: 2},
+	})
+
+	// Every request opens a new connection to the proxy
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	post := func(body string) string {
+		resp, err := client.Post(proxy.URL, "text/plain", strings.NewReader(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		out, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(out)
+	}
+
+	for i := 0; i < 10; i++ {
+		require.Equal(t, "POST hello", post("hello"))
+	}
+	require.Len(t, remotes, 1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			resp, err := client.Get(proxy.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	require.LessOrEqual(t, len(remotes), 2)
+
+	stats := proxy.Stats()
+	require.Equal(t, uint32(20), stats.Connections)
+	require.Equal(t, uint32(len(remotes)), stats.PoolDials)
+	require.Equal(t, uint32(20)-stats.PoolDials, stats.PoolReuses)
+}
+
+func TestTargetPoolConfig(t *testing.T) {
+	_, err := newTargetPool(Config{
+		TargetPool:      TargetPool{Size: 1},
+		Backups:         []string{"127.0.0.1:1"},
+		OfflineFallback: true,
+		ConnectionStorm: ConnectionStorm{Ratio: 10},
+	})
+	require.EqualError(t, err, "TargetPool can't be used with Backups, OfflineFallback, ConnectionStorm")
+
+	pool, err := newTargetPool(Config{})
+	require.NoError(t, err)
+	require.Nil(t, pool)
+
+	// Routes to one target with their own TLS configs get separate connections
+	pool, err = newTargetPool(Config{TargetPool: TargetPool{Size: 1}})
+	require.NoError(t, err)
+	dial := func(ctx context.Context) (net.Conn, error) { return nil, errors.New("unused") }
+	plain := pool.transport(route{target: "127.0.0.1:443"}, dial)
+	secure := pool.transport(route{target: "127.0.0.1:443", targetTLS: &tls.Config{}}, dial)
+	require.NotSame(t, plain, secure)
+	require.Same(t, plain, pool.transport(route{target: "127.0.0.1:443"}, dial))
+}
+
+func TestTargetPoolIdleTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(server.Close)
+
+	proxy := ForHTTPTest(t, server, Config{
+		TargetPool:  TargetPool{Size: 1},
+		IdleTimeout: 100 * time.Millisecond,
+	})
+
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	// The quiet client is hung up on while the pooled connection stays open
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, uint32(1), proxy.Stats().IdleTimeouts)
+}
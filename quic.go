@@ -0,0 +1,111 @@
+package badnet
+
+// QUIC packets start with a header whose first bit marks the long form, used
+// during the handshake, or the short form used afterwards. Both carry the
+// destination connection ID, but only long headers carry its length and the
+// source connection ID. See RFC 9000, section 17.
+const (
+	quicLongHeader = 0x80
+	quicFixedBit   = 0x40
+
+	// quicMaxIDLength is the longest connection ID QUIC version 1 allows.
+	quicMaxIDLength = 20
+)
+
+// quicRoutes finds the session of a client from the connection IDs in its
+// packets, which stay the same when its address changes. Only the IDs a target
+// announces in long headers are seen; those issued later are encrypted, so a
+// client which moves to one of them, as active migration does, starts a new
+// session.
+type quicRoutes struct {
+	sessions map[string]*udpSession
+
+	// lengths are the distinct lengths of the IDs, which short headers don't include
+	lengths []int
+}
+
+func newQUICRoutes() *quicRoutes {
+	return &quicRoutes{
+		sessions: make(map[string]*udpSession),
+	}
+}
+
+// learn records the source connection ID of a long header packet from the target.
+func (r *quicRoutes) learn(packet []byte, s *udpSession) {
+	id, ok := quicSourceID(packet)
+	if !ok || len(id) == 0 {
+		return
+	}
+	if _, ok := r.sessions[string(id)]; !ok {
+		r.sessions[string(id)] = s
+	}
+	for _, n := range r.lengths {
+		if n == len(id) {
+			return
+		}
+	}
+	r.lengths = append(r.lengths, len(id))
+}
+
+// find returns the session whose target chose the packet's destination connection ID.
+func (r *quicRoutes) find(packet []byte) (*udpSession, bool) {
+	if len(packet) == 0 || packet[0]&quicFixedBit == 0 {
+		return nil, false
+	}
+	if packet[0]&quicLongHeader != 0 {
+		id, ok := quicDestinationID(packet)
+		if !ok {
+			return nil, false
+		}
+		s, ok := r.sessions[string(id)]
+		return s, ok
+	}
+	for _, n := range r.lengths {
+		if len(packet) > n {
+			if s, ok := r.sessions[string(packet[1:1+n])]; ok {
+				return s, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// forget drops every connection ID routed to s.
+func (r *quicRoutes) forget(s *udpSession) {
+	for id, other := range r.sessions {
+		if other == s {
+			delete(r.sessions, id)
+		}
+	}
+}
+
+// quicDestinationID returns the destination connection ID of a long header packet,
+// which follows the first byte, the 4 byte version and the ID's length.
+func quicDestinationID(packet []byte) ([]byte, bool) {
+	if len(packet) < 6 || packet[0]&quicLongHeader == 0 {
+		return nil, false
+	}
+	n := int(packet[5])
+	if n > quicMaxIDLength || len(packet) < 6+n {
+		return nil, false
+	}
+	return packet[6 : 6+n], true
+}
+
+// quicSourceID returns the source connection ID of a long header packet, which
+// follows the destination connection ID.
+func quicSourceID(packet []byte) ([]byte, bool) {
+	dest, ok := quicDestinationID(packet)
+	if !ok {
+		return nil, false
+	}
+	at := 6 + len(dest)
+	if len(packet) < at+1 {
+		return nil, false
+	}
+	n := int(packet[at])
+	if n > quicMaxIDLength || len(packet) < at+1+n {
+		return nil, false
+	}
+	return packet[at+1 : at+1+n], true
+}
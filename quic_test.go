@@ -0,0 +1,177 @@
+package badnet
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/stretchr/testify/require"
+)
+
+func quicLongPacket(dest, src string, payload string) []byte {
+	packet := []byte{0xc0, 0, 0, 0, 1}
+	packet = append(packet, byte(len(dest)))
+	packet = append(packet, dest...)
+	packet = append(packet, byte(len(src)))
+	packet = append(packet, src...)
+	return append(packet, payload...)
+}
+
+func TestQUICConnectionIDs(t *testing.T) {
+	packet := quicLongPacket("client-chosen", "server", "payload")
+
+	dest, ok := quicDestinationID(packet)
+	require.True(t, ok)
+	require.Equal(t, "client-chosen", string(dest))
+
+	src, ok := quicSourceID(packet)
+	require.True(t, ok)
+	require.Equal(t, "server", string(src))
+
+	// Truncated and short header packets have no IDs to read
+	_, ok = quicSourceID(packet[:10])
+	require.False(t, ok)
+	_, ok = quicDestinationID([]byte{0x40, 1, 2, 3, 4, 5, 6})
+	require.False(t, ok)
+
+	routes := newQUICRoutes()
+	s := &udpSession{}
+	routes.learn(packet, s)
+
+	found, ok := routes.find(append([]byte{0x40}, "server and more"...))
+	require.True(t, ok)
+	require.Same(t, s, found)
+
+	_, ok = routes.find(append([]byte{0x40}, "unknown"...))
+	require.False(t, ok)
+
+	routes.forget(s)
+	_, ok = routes.find(append([]byte{0x40}, "server and more"...))
+	require.False(t, ok)
+}
+
+func TestUDPProxyQUICRebinding(t *testing.T) {
+	// The target answers every packet from a connection with the ID "server01"
+	// and notes where packets came from.
+	target, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { target.Close() })
+
+	var mu sync.Mutex
+	sources := make(map[string]bool)
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, addr, err := target.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			sources[addr.String()] = true
+			mu.Unlock()
+
+			target.WriteTo(quicLongPacket("client01", "server01", string(buf[:n])), addr)
+		}
+	}()
+
+	proxy := UDPForTest(t, Config{
+		Listen: "127.0.0.1:0",
+		Target: target.LocalAddr().String(),
+		QUIC:   true,
+	})
+
+	roundTrip := func(conn net.Conn, packet []byte) {
+		t.Helper()
+
+		_, err := conn.Write(packet)
+		require.NoError(t, err)
+
+		buf := make([]byte, 1024)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+		require.Contains(t, string(buf[:n]), string(packet))
+	}
+
+	before, err := net.Dial("udp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer before.Close()
+	roundTrip(before, quicLongPacket("random", "client01", "initial"))
+
+	// The client's address changes, but its packets still carry the target's ID
+	after, err := net.Dial("udp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer after.Close()
+	roundTrip(after, append([]byte{0x40}, "server01 moved"...))
+
+	require.Equal(t, uint32(1), proxy.Stats().Connections)
+	mu.Lock()
+	require.Len(t, sources, 1)
+	mu.Unlock()
+
+	// Packets for other connections still start sessions of their own
+	other, err := net.Dial("udp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer other.Close()
+	roundTrip(other, append([]byte{0x40}, "unknown1 hello"...))
+	require.Equal(t, uint32(2), proxy.Stats().Connections)
+}
+
+func TestUDPProxyHTTP3(t *testing.T) {
+	// Borrow httptest's certificate for the HTTP/3 server
+	certs := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(certs.Close)
+	clientTLS := certs.Client().Transport.(*http.Transport).TLSClientConfig
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &http3.Server{
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: certs.TLS.Certificates}),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		}),
+	}
+	go server.Serve(pc)
+	t.Cleanup(func() {
+		server.Close()
+		pc.Close()
+	})
+
+	degraded := Direction{
+		Latency: 10 * time.Millisecond,
+		Datagrams: Datagrams{
+			DropRatio:    10,
+			ReorderRatio: 20,
+		},
+	}
+	proxy := UDPForTest(t, Config{
+		Listen: "127.0.0.1:0",
+		Target: pc.LocalAddr().String(),
+		Read:   degraded,
+		Write:  degraded,
+		QUIC:   true,
+	})
+
+	transport := &http3.RoundTripper{TLSClientConfig: clientTLS}
+	t.Cleanup(func() { transport.Close() })
+	client := &http.Client{Transport: transport, Timeout: 30 * time.Second}
+
+	for i := 0; i < 5; i++ {
+		resp, err := client.Get("https://" + proxy.BindAddr() + "/")
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		require.Equal(t, "HTTP/3.0", string(body))
+	}
+
+	stats := proxy.Stats()
+	require.Equal(t, uint32(1), stats.Connections)
+	require.Greater(t, stats.ReadBytes, uint64(0))
+}
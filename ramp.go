@@ -0,0 +1,57 @@
+package badnet
+
+import (
+	"math"
+	"time"
+)
+
+// Ramp gradually changes a Direction's bandwidth from one rate to another, and
+// optionally back, to reproduce a network which degrades and recovers rather than
+// changing abruptly. The ramp begins when the proxy or Dialer starts.
+//
+// From and To should both be set, as a zero Rate is unlimited and can't be ramped.
+type Ramp struct {
+	From, To Rate
+
+	// After holds the bandwidth at From for this long before the ramp begins.
+	After time.Duration
+
+	// Duration is how long the change from From to To takes.
+	Duration time.Duration
+
+	// Steps changes the bandwidth in this many equal jumps rather than linearly.
+	Steps int
+
+	// Recover ramps back to From over another Duration after holding To for Hold.
+	Recover bool
+	Hold    time.Duration
+}
+
+func (r Ramp) enabled() bool {
+	return r.Duration > 0 && r.From != r.To
+}
+
+// at returns the bandwidth in bytes per second once elapsed has passed.
+func (r Ramp) at(elapsed time.Duration) float64 {
+	elapsed -= r.After
+
+	var progress float64
+	switch {
+	case elapsed <= 0:
+		progress = 0
+	case elapsed < r.Duration:
+		progress = float64(elapsed) / float64(r.Duration)
+	case !r.Recover || elapsed < r.Duration+r.Hold:
+		progress = 1
+	case elapsed < 2*r.Duration+r.Hold:
+		progress = 1 - float64(elapsed-r.Duration-r.Hold)/float64(r.Duration)
+	default:
+		progress = 0
+	}
+	if r.Steps > 0 {
+		progress = math.Floor(progress*float64(r.Steps)) / float64(r.Steps)
+	}
+
+	from, to := r.From.bytesPerSecond(), r.To.bytesPerSecond()
+	return from + (to-from)*progress
+}
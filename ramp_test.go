@@ -0,0 +1,58 @@
+package badnet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRamp(t *testing.T) {
+	ramp := Ramp{
+		From:     8 * Mbit,
+		To:       800 * Kbit,
+		After:    time.Second,
+		Duration: 10 * time.Second,
+	}
+	require.True(t, ramp.enabled())
+	require.InDelta(t, 1_000_000, ramp.at(0), 1)
+	require.InDelta(t, 1_000_000, ramp.at(time.Second), 1)
+	require.InDelta(t, 550_000, ramp.at(6*time.Second), 1)
+	require.InDelta(t, 100_000, ramp.at(11*time.Second), 1)
+	require.InDelta(t, 100_000, ramp.at(time.Hour), 1)
+
+	ramp.Recover = true
+	ramp.Hold = 5 * time.Second
+	require.InDelta(t, 100_000, ramp.at(16*time.Second), 1)
+	require.InDelta(t, 550_000, ramp.at(21*time.Second), 1)
+	require.InDelta(t, 1_000_000, ramp.at(26*time.Second), 1)
+
+	steps := Ramp{From: 4 * Mbit, To: 0, Duration: 4 * time.Second, Steps: 4}
+	require.InDelta(t, 500_000, steps.at(0), 1)
+	require.InDelta(t, 500_000, steps.at(999*time.Millisecond), 1)
+	require.InDelta(t, 375_000, steps.at(time.Second), 1)
+	require.InDelta(t, 125_000, steps.at(3500*time.Millisecond), 1)
+
+	require.False(t, Ramp{From: Mbit, To: Mbit, Duration: time.Second}.enabled())
+	require.False(t, Ramp{From: Mbit, To: 2 * Mbit}.enabled())
+}
+
+func TestRampLink(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	l := newLink(Direction{
+		Ramp: Ramp{From: 8 * Kbit, To: 80 * Kbit, Duration: 10 * time.Second},
+	}, clock)
+	require.False(t, l.unthrottled())
+
+	rate, _, _ := l.settings()
+	require.InDelta(t, 1000, rate, 1)
+
+	clock.Advance(5 * time.Second)
+	rate, _, _ = l.settings()
+	require.InDelta(t, 5500, rate, 1)
+
+	// Setting the bandwidth ends the ramp
+	l.setRate(0)
+	clock.Advance(time.Second)
+	require.True(t, l.unthrottled())
+}
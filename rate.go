@@ -0,0 +1,75 @@
+package badnet
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Rate is a bandwidth in bits per second, written the way tc and netem rules are,
+// e.g. "512kbit" or "2mbit". Decimal prefixes are used, so a kbit is 1000 bits.
+type Rate int64
+
+const (
+	Bit  Rate = 1
+	Kbit      = 1000 * Bit
+	Mbit      = 1000 * Kbit
+	Gbit      = 1000 * Mbit
+)
+
+// rateUnits are checked longest first so "kbit" isn't read as "bit".
+var rateUnits = []struct {
+	suffix string
+	rate   Rate
+}{
+	{"gbit", Gbit},
+	{"mbit", Mbit},
+	{"kbit", Kbit},
+	{"bit", Bit},
+}
+
+// ParseRate reads a rate such as "512kbit", "2mbit", "1.5gbit" or "100bit".
+func ParseRate(s string) (Rate, error) {
+	value := strings.ToLower(strings.TrimSpace(s))
+	for _, unit := range rateUnits {
+		if !strings.HasSuffix(value, unit.suffix) {
+			continue
+		}
+		n, err := strconv.ParseFloat(strings.TrimSuffix(value, unit.suffix), 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid rate %q", s)
+		}
+		return Rate(n * float64(unit.rate)), nil
+	}
+	return 0, fmt.Errorf("invalid rate %q: missing unit (bit, kbit, mbit or gbit)", s)
+}
+
+// String formats the rate with the largest unit which represents it exactly.
+func (r Rate) String() string {
+	for _, unit := range rateUnits {
+		if r != 0 && r%unit.rate == 0 {
+			return strconv.FormatInt(int64(r/unit.rate), 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(int64(r), 10) + "bit"
+}
+
+// UnmarshalText lets a Rate be read from text formats, like JSON strings.
+func (r *Rate) UnmarshalText(text []byte) error {
+	parsed, err := ParseRate(string(text))
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+// MarshalText writes the rate as it's parsed, e.g. "2mbit".
+func (r Rate) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// bytesPerSecond converts the rate into bytes per second.
+func (r Rate) bytesPerSecond() float64 {
+	return float64(r) / 8
+}
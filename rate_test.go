@@ -0,0 +1,79 @@
+package badnet
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRate(t *testing.T) {
+	cases := map[string]Rate{
+		"100bit":  100,
+		"512kbit": 512 * Kbit,
+		"2mbit":   2 * Mbit,
+		"1.5mbit": 1500 * Kbit,
+		"1Gbit":   Gbit,
+		" 8kbit ": 8 * Kbit,
+	}
+	for input, expected := range cases {
+		rate, err := ParseRate(input)
+		require.NoError(t, err, input)
+		require.Equal(t, expected, rate, input)
+	}
+
+	for _, input := range []string{"", "fast", "10", "-1kbit", "kbit", "10kbps"} {
+		_, err := ParseRate(input)
+		require.Error(t, err, input)
+	}
+
+	require.Equal(t, "512kbit", (512 * Kbit).String())
+	require.Equal(t, "1500kbit", (1500 * Kbit).String())
+	require.Equal(t, "0bit", Rate(0).String())
+	require.Equal(t, 125_000.0, Mbit.bytesPerSecond())
+}
+
+func TestRateText(t *testing.T) {
+	var conf struct {
+		Rate Rate
+	}
+	require.NoError(t, json.Unmarshal([]byte(`{"Rate": "2mbit"}`), &conf))
+	require.Equal(t, 2*Mbit, conf.Rate)
+
+	bs, err := json.Marshal(conf)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"Rate": "2mbit"}`, string(bs))
+
+	t.Setenv("BADNET_WRITE_BANDWIDTH", "512kbit")
+	env, err := ApplyEnv(Config{})
+	require.NoError(t, err)
+	require.Equal(t, 512*Kbit, env.Write.Bandwidth)
+}
+
+func TestBandwidthRate(t *testing.T) {
+	dialer := DialerForTest(t, Config{
+		Target: echoServer(t),
+		Write: Direction{
+			Bandwidth:      80 * Kbit, // 10,000 bytes per second
+			BandwidthBurst: 1000,
+		},
+	})
+	conn, err := dialer.Dial(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// The burst goes immediately, then the rest is paced
+	start := time.Now()
+	_, err = conn.Write(make([]byte, 4000))
+	require.NoError(t, err)
+	require.InDelta(t, 300*time.Millisecond, time.Since(start), float64(100*time.Millisecond))
+
+	// Changing the rate applies to open connections
+	dialer.SetRate(0, 0)
+	start = time.Now()
+	_, err = conn.Write(make([]byte, 100_000))
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), 100*time.Millisecond)
+}
@@ -0,0 +1,243 @@
+package badnet
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// Error replies for RedisError, such as those returned while a server starts up or
+// a cluster reshards.
+const (
+	RedisLoading  = "-LOADING Redis is loading the dataset in memory"
+	RedisBusy     = "-BUSY Redis is busy running a script. You can only call SCRIPT KILL or SHUTDOWN NOSCRIPT."
+	RedisReadOnly = "-READONLY You can't write against a read only replica."
+	RedisTryAgain = "-TRYAGAIN Multiple keys request during rehashing of slot"
+)
+
+// RedisError answers ratio percent of the Redis commands clients send through a
+// Proxy with reply, such as RedisLoading or "-MOVED 3999 127.0.0.1:6381", instead
+// of forwarding them. Replies are kept in order for pipelined commands. Use it
+// with Config.Toxics.
+func RedisError(ratio int, reply string) Toxic {
+	reply = reply + "\r\n"
+	return unrecordedToxic{func(c net.Conn, _ ToxicInfo) net.Conn {
+		return &redisErrorConn{Conn: c, ratio: ratio, reply: []byte(reply)}
+	}}
+}
+
+// redisErrorConn drops some commands read from the client and injects replies to
+// them between the server's replies to the commands which were forwarded.
+type redisErrorConn struct {
+	net.Conn
+
+	ratio int
+	reply []byte
+
+	// buf is read into, commands holds data read from the client which isn't a
+	// whole command yet and forward what's left to be returned by Read.
+	buf      []byte
+	commands []byte
+	forward  []byte
+	readErr  error
+
+	mu sync.Mutex
+
+	// outstanding is each command sent on, in order, true for those which were
+	// forwarded and false for those answered by reply. replies holds data written
+	// by the server which isn't a whole reply yet.
+	outstanding []bool
+	replies     []byte
+
+	// raw stops parsing once something which isn't RESP is seen.
+	raw bool
+}
+
+// NetConn returns the underlying connection.
+func (c *redisErrorConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *redisErrorConn) Read(b []byte) (int, error) {
+	for len(c.forward) == 0 && c.readErr == nil {
+		if len(c.buf) < len(b) {
+			c.buf = make([]byte, len(b))
+		}
+		n, err := c.Conn.Read(c.buf[:len(b)])
+		c.commands = append(c.commands, c.buf[:n]...)
+		c.readErr = err
+
+		if err := c.splitCommands(); err != nil {
+			return 0, err
+		}
+		if c.readErr != nil {
+			// Incomplete commands are passed on for the server to reject
+			c.forward = append(c.forward, c.commands...)
+			c.commands = nil
+		}
+	}
+	if len(c.forward) == 0 {
+		return 0, c.readErr
+	}
+	n := copy(b, c.forward)
+	c.forward = c.forward[n:]
+	return n, nil
+}
+
+// splitCommands moves each whole command read so far to forward, or answers it.
+func (c *redisErrorConn) splitCommands() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.commands) > 0 {
+		size := 0
+		if !c.raw {
+			size = redisCommandSize(c.commands)
+		}
+		if size < 0 || c.raw {
+			c.raw = true
+			c.forward = append(c.forward, c.commands...)
+			c.commands = nil
+			return nil
+		}
+		if size == 0 {
+			return nil
+		}
+		cmd := c.commands[:size]
+		c.commands = c.commands[size:]
+
+		if !shouldFail(c.ratio) {
+			c.forward = append(c.forward, cmd...)
+			c.outstanding = append(c.outstanding, true)
+			continue
+		}
+		if len(c.outstanding) > 0 {
+			c.outstanding = append(c.outstanding, false)
+			continue
+		}
+		if _, err := c.Conn.Write(c.reply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *redisErrorConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.raw {
+		return c.Conn.Write(b)
+	}
+	c.replies = append(c.replies, b...)
+
+	var out []byte
+	for len(c.replies) > 0 {
+		size := redisReplySize(c.replies)
+		if size < 0 {
+			c.raw = true
+			out = append(out, c.replies...)
+			c.replies = nil
+			break
+		}
+		if size == 0 {
+			break
+		}
+		push := c.replies[0] == '>'
+		out = append(out, c.replies[:size]...)
+		c.replies = c.replies[size:]
+
+		// Pushes and replies without a command waiting, like pub/sub messages,
+		// pass through
+		if !push && len(c.outstanding) > 0 && c.outstanding[0] {
+			c.outstanding = c.outstanding[1:]
+		}
+		for len(c.outstanding) > 0 && !c.outstanding[0] {
+			out = append(out, c.reply...)
+			c.outstanding = c.outstanding[1:]
+		}
+	}
+	if len(out) > 0 {
+		if _, err := c.Conn.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// redisLine returns the line at the start of data, without its CRLF, and the size
+// including it. ok is false when the line hasn't been completely read.
+func redisLine(data []byte) (line []byte, size int, ok bool) {
+	end := bytes.Index(data, []byte("\r\n"))
+	if end < 0 {
+		return nil, 0, false
+	}
+	return data[:end], end + 2, true
+}
+
+// redisCommandSize returns the size of the command at the start of data, zero when
+// it's incomplete or -1 when it isn't a RESP command. Inline commands end at the
+// first newline.
+func redisCommandSize(data []byte) int {
+	if data[0] != '*' {
+		end := bytes.IndexByte(data, '\n')
+		return end + 1
+	}
+	return redisReplySize(data)
+}
+
+// redisReplySize returns the size of the RESP2 or RESP3 value at the start of data,
+// zero when it's incomplete or -1 when it isn't valid.
+func redisReplySize(data []byte) int {
+	if len(data) == 0 {
+		return 0
+	}
+	line, size, ok := redisLine(data)
+	if !ok {
+		return 0
+	}
+	switch data[0] {
+	case '+', '-', ':', '_', ',', '#', '(':
+		return size
+
+	case '$', '!', '=':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return -1
+		}
+		if n < 0 {
+			return size
+		}
+		if len(data) < size+n+2 {
+			return 0
+		}
+		return size + n + 2
+
+	case '*', '~', '>', '%', '|':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return -1
+		}
+		if data[0] == '%' || data[0] == '|' {
+			n *= 2
+		}
+		for i := 0; i < n; i++ {
+			elem := redisReplySize(data[size:])
+			if elem <= 0 {
+				return elem
+			}
+			size += elem
+		}
+		if data[0] == '|' {
+			// Attributes precede the reply they describe
+			next := redisReplySize(data[size:])
+			if next <= 0 {
+				return next
+			}
+			size += next
+		}
+		return size
+	}
+	return -1
+}
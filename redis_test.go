@@ -0,0 +1,85 @@
+package badnet
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisReplySize(t *testing.T) {
+	cases := map[string]int{
+		"+OK\r\n":                        5,
+		"-ERR bad\r\n":                   10,
+		":42\r\n":                        5,
+		"$5\r\nhello\r\n":                11,
+		"$-1\r\n":                        5,
+		"*2\r\n$1\r\na\r\n:1\r\n":        15,
+		"%1\r\n+k\r\n+v\r\n":             12,
+		"|1\r\n+ttl\r\n:3\r\n+value\r\n": 22,
+		"$5\r\nhel":                      0,
+		"*2\r\n:1\r\n":                   0,
+		"+OK":                            0,
+		"hello\r\n":                      -1,
+		"*x\r\n":                         -1,
+	}
+	for reply, want := range cases {
+		require.Equal(t, want, redisReplySize([]byte(reply)), reply)
+	}
+
+	// Inline commands end with their line
+	require.Equal(t, 6, redisCommandSize([]byte("PING\r\n")))
+	require.Equal(t, 0, redisCommandSize([]byte("PIN")))
+}
+
+func TestRedisError(t *testing.T) {
+	t.Run("Proxy", func(t *testing.T) {
+		proxy := ForTest(t, Config{
+			Listen: "127.0.0.1:0",
+			Target: echoServer(t),
+			Toxics: []Toxic{RedisError(100, RedisLoading)},
+		})
+		conn, err := net.Dial("tcp", proxy.BindAddr())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("*1\r\n$4\r\nPING\r\n"))
+		require.NoError(t, err)
+		reply, err := bufio.NewReader(conn).ReadString('\n')
+		require.NoError(t, err)
+		require.Equal(t, RedisLoading+"\r\n", reply)
+	})
+
+	t.Run("pipelined", func(t *testing.T) {
+		client, server := net.Pipe()
+		defer client.Close()
+
+		c := RedisError(100, "-MOVED 1 127.0.0.1:6381").Wrap(server, ToxicInfo{}).(*redisErrorConn)
+		defer c.Close()
+
+		// A command which was forwarded is still waiting for the server's reply
+		c.outstanding = []bool{true}
+
+		go client.Write([]byte("GET a\r\n"))
+		go c.Read(make([]byte, 64))
+		require.Eventually(t, func() bool {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return len(c.outstanding) == 2
+		}, time.Second, time.Millisecond)
+
+		replies := bufio.NewReader(client)
+		go c.Write([]byte(":1\r\n"))
+
+		// so the injected reply comes after it
+		first, err := replies.ReadString('\n')
+		require.NoError(t, err)
+		require.Equal(t, ":1\r\n", first)
+
+		second, err := replies.ReadString('\n')
+		require.NoError(t, err)
+		require.Equal(t, "-MOVED 1 127.0.0.1:6381\r\n", second)
+	})
+}
@@ -0,0 +1,217 @@
+package badnet
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ResponseReorder holds consecutive responses from the target and sends some of
+// them to the client out of order, exposing clients of pipelined protocols, like
+// Redis, Memcached or HTTP/1.1 pipelining, which assume responses arrive in the
+// order requests were sent.
+//
+// Responses are split using Delimiter, such as "\r\n" for single line replies,
+// parsed as HTTP/1 responses when HTTP is set, or taken as each write from the
+// target otherwise.
+type ResponseReorder struct {
+	// Ratio is the percentage of windows which are shuffled.
+	Ratio int
+
+	// Window is how many responses are held and shuffled together, defaulting to 2.
+	Window int
+
+	Delimiter []byte
+	HTTP      bool
+
+	// Wait is how long responses are held for a window to fill, defaulting to 20ms.
+	// Responses are sent in order once it passes, so clients which wait for each
+	// response before sending another request aren't stalled.
+	Wait time.Duration
+}
+
+const (
+	defaultReorderResponseWindow = 2
+	defaultReorderResponseWait   = 20 * time.Millisecond
+)
+
+func (r ResponseReorder) enabled() bool {
+	return r.Ratio > 0
+}
+
+func (r ResponseReorder) window() int {
+	if r.Window > 1 {
+		return r.Window
+	}
+	return defaultReorderResponseWindow
+}
+
+func (r ResponseReorder) wait() time.Duration {
+	if r.Wait > 0 {
+		return r.Wait
+	}
+	return defaultReorderResponseWait
+}
+
+// next returns the length of the first complete response in data, or zero when
+// there isn't one yet.
+func (r ResponseReorder) next(data []byte) int {
+	if r.HTTP {
+		return nextHTTPResponse(data)
+	}
+	end := bytes.Index(data, r.Delimiter)
+	if end < 0 {
+		return 0
+	}
+	return end + len(r.Delimiter)
+}
+
+// nextHTTPResponse returns the length of the first HTTP/1 response in data. Responses
+// which run until the connection closes are never complete.
+func nextHTTPResponse(data []byte) int {
+	rd := bytes.NewReader(data)
+	br := bufio.NewReader(rd)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		return 0
+	}
+	if resp.ContentLength < 0 && len(resp.TransferEncoding) == 0 {
+		return 0
+	}
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return 0
+	}
+	return len(data) - rd.Len() - br.Buffered()
+}
+
+// reorderToxic holds responses written to clients according to conf.
+func (s *stats) reorderToxic(conf ResponseReorder) Toxic {
+	if !conf.enabled() {
+		return nil
+	}
+	return ToxicFunc(func(c net.Conn, info ToxicInfo) net.Conn {
+		return &reorderConn{Conn: c, conf: conf, clock: info.clock(), onReordered: s.responsesReordered}
+	})
+}
+
+// reorderConn buffers writes into responses and sends them a window at a time.
+type reorderConn struct {
+	net.Conn
+
+	conf        ResponseReorder
+	clock       Clock
+	onReordered func()
+
+	mu sync.Mutex
+
+	// partial holds data which isn't a complete response yet and held the
+	// responses waiting for the window to fill.
+	partial []byte
+	held    [][]byte
+	timer   *clockTimer
+
+	// err is the first error writing to the connection, returned by later writes.
+	err error
+}
+
+// NetConn returns the underlying connection.
+func (c *reorderConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *reorderConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return 0, c.err
+	}
+	if !c.conf.HTTP && len(c.conf.Delimiter) == 0 {
+		c.held = append(c.held, bytes.Clone(b))
+	} else {
+		c.partial = append(c.partial, b...)
+		for {
+			n := c.conf.next(c.partial)
+			if n == 0 {
+				break
+			}
+			c.held = append(c.held, c.partial[:n:n])
+			c.partial = c.partial[n:]
+		}
+	}
+
+	if len(c.held) >= c.conf.window() {
+		c.flush(true)
+	} else if c.timer == nil && (len(c.held) > 0 || len(c.partial) > 0) {
+		c.timer = afterFunc(c.clock, c.conf.wait(), func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.flush(false)
+		})
+	}
+	return len(b), c.err
+}
+
+// flush writes the held responses, shuffling them when the window is full and
+// chosen by Ratio. Partial responses are kept unless flushing after Wait or a
+// half-close, when nothing more is expected for them soon.
+func (c *reorderConn) flush(full bool) {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if full && shouldFail(c.conf.Ratio) {
+		shuffleResponses(c.held)
+		c.onReordered()
+	}
+	out := c.held
+	if !full && len(c.partial) > 0 {
+		out = append(out, c.partial)
+		c.partial = nil
+	}
+	c.held = nil
+
+	for _, resp := range out {
+		if c.err != nil {
+			return
+		}
+		_, c.err = c.Conn.Write(resp)
+	}
+}
+
+// shuffleResponses moves responses around, rotating them by a random amount so
+// none are left in their original place.
+func shuffleResponses(responses [][]byte) {
+	if len(responses) < 2 {
+		return
+	}
+	k := 1 + randomInt(len(responses)-1)
+	rotated := append(slices.Clone(responses[k:]), responses[:k]...)
+	copy(responses, rotated)
+}
+
+// CloseWrite sends everything held before half-closing the connection.
+func (c *reorderConn) CloseWrite() error {
+	c.mu.Lock()
+	c.flush(false)
+	c.mu.Unlock()
+
+	return closeWrite(c.Conn)
+}
+
+// Close sends everything held, in order, before closing the connection.
+func (c *reorderConn) Close() error {
+	c.mu.Lock()
+	c.flush(false)
+	if c.err == nil {
+		c.err = net.ErrClosed
+	}
+	c.mu.Unlock()
+
+	return c.Conn.Close()
+}
@@ -0,0 +1,137 @@
+package badnet
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNextHTTPResponse(t *testing.T) {
+	first := "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"
+	chunked := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nok\r\n0\r\n\r\n"
+
+	require.Equal(t, len(first), nextHTTPResponse([]byte(first+chunked)))
+	require.Equal(t, len(chunked), nextHTTPResponse([]byte(chunked+first)))
+	require.Zero(t, nextHTTPResponse([]byte(first[:len(first)-1])))
+	require.Zero(t, nextHTTPResponse([]byte("HTTP/1.1 200 OK\r\n\r\nuntil close")))
+}
+
+// lineServer replies to each line it reads with "reply " and the line.
+func lineServer(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				lines := bufio.NewScanner(conn)
+				for lines.Scan() {
+					fmt.Fprintf(conn, "reply %s\r\n", lines.Text())
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestReorderResponses(t *testing.T) {
+	proxy := ForTest(t, Config{
+		Listen: "127.0.0.1:0",
+		Target: lineServer(t),
+		ReorderResponses: ResponseReorder{
+			Ratio:     100,
+			Delimiter: []byte("\r\n"),
+			Wait:      200 * time.Millisecond,
+		},
+	})
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+	replies := bufio.NewReader(conn)
+
+	// Pipelined requests have their responses swapped
+	_, err = conn.Write([]byte("GET a\r\nGET b\r\n"))
+	require.NoError(t, err)
+	for _, want := range []string{"reply GET b\r\n", "reply GET a\r\n"} {
+		line, err := replies.ReadString('\n')
+		require.NoError(t, err)
+		require.Equal(t, want, line)
+	}
+	require.Equal(t, uint32(1), proxy.Stats().ReorderedResponses)
+
+	// while a lone response is sent once Wait passes
+	start := time.Now()
+	_, err = conn.Write([]byte("GET c\r\n"))
+	require.NoError(t, err)
+	line, err := replies.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "reply GET c\r\n", line)
+	require.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+}
+
+func TestReorderClose(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	client, _ := net.Pipe()
+	dst := &recordingConn{Conn: client}
+
+	var stats stats
+	conn := stats.reorderToxic(ResponseReorder{
+		Ratio:     100,
+		Delimiter: []byte("\r\n"),
+	}).Wrap(dst, ToxicInfo{Clock: clock})
+
+	_, err := conn.Write([]byte("reply a\r\nrep"))
+	require.NoError(t, err)
+	require.Equal(t, 1, clock.Waiters())
+	require.Zero(t, dst.buf.Len())
+
+	// Closing sends what's held and stops waiting for the window to fill
+	require.NoError(t, conn.Close())
+	require.Equal(t, "reply a\r\nrep", dst.buf.String())
+	require.Zero(t, clock.Waiters())
+
+	_, err = conn.Write([]byte("reply b\r\n"))
+	require.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestReorderHTTPResponses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.Path)
+	}))
+	t.Cleanup(server.Close)
+
+	proxy := ForHTTPTest(t, server, Config{
+		ReorderResponses: ResponseReorder{Ratio: 100, HTTP: true, Wait: time.Second},
+	})
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = io.WriteString(conn, "GET /first HTTP/1.1\r\nHost: example\r\n\r\nGET /second HTTP/1.1\r\nHost: example\r\n\r\n")
+	require.NoError(t, err)
+
+	responses := bufio.NewReader(conn)
+	for _, want := range []string{"/second", "/first"} {
+		resp, err := http.ReadResponse(responses, nil)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		require.Equal(t, want, string(body))
+	}
+}
@@ -0,0 +1,122 @@
+package badnet
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// report is the summary written to Config.StatsReport once a proxy closes.
+type report struct {
+	Listen   string
+	Started  time.Time
+	Duration string
+	Failures uint32
+	Stats    Stats
+	Bursts   []burstReport
+}
+
+// burstReport describes when a Burst was in effect, relative to the proxy starting.
+type burstReport struct {
+	AfterConnections uint32
+	Triggered        bool
+	StartedAfter     string `json:",omitempty"`
+	Duration         string
+	Outage           bool
+	FailureRatio     int
+}
+
+func (p *Proxy) report() report {
+	stats := p.Stats()
+	r := report{
+		Listen:   p.BindAddr(),
+		Started:  p.started,
+		Duration: p.conf.clock().Now().Sub(p.started).String(),
+		Failures: stats.Failures(),
+		Stats:    stats,
+	}
+	for i, started := range p.bursts.started() {
+		burst := p.conf.Bursts[i]
+		br := burstReport{
+			AfterConnections: burst.AfterConnections,
+			Triggered:        !started.IsZero(),
+			Duration:         burst.Duration.String(),
+			Outage:           burst.Outage,
+			FailureRatio:     burst.FailureRatio,
+		}
+		if br.Triggered {
+			br.StartedAfter = started.Sub(p.started).String()
+		}
+		r.Bursts = append(r.Bursts, br)
+	}
+	return r
+}
+
+// writeReport saves the proxy's report to path, as CSV when it ends in .csv and
+// JSON otherwise.
+func (p *Proxy) writeReport(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("stats report: %w", err)
+	}
+	defer f.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		err = p.report().writeCSV(f)
+	} else {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(p.report())
+	}
+	if err != nil {
+		return fmt.Errorf("stats report: %w", err)
+	}
+	return f.Close()
+}
+
+// writeCSV writes the report as name,value rows so runs can be diffed line by line.
+// Histograms are split into their count and quantiles, e.g. TargetDial.P99.
+func (r report) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"name", "value"})
+	cw.Write([]string{"Listen", r.Listen})
+	cw.Write([]string{"Started", r.Started.Format(time.RFC3339Nano)})
+	cw.Write([]string{"Duration", r.Duration})
+	cw.Write([]string{"Failures", strconv.FormatUint(uint64(r.Failures), 10)})
+
+	stats := reflect.ValueOf(r.Stats)
+	for i := 0; i < stats.NumField(); i++ {
+		name := stats.Type().Field(i).Name
+		switch v := stats.Field(i).Interface().(type) {
+		case Histogram:
+			cw.Write([]string{name + ".Count", strconv.FormatUint(v.Count, 10)})
+			cw.Write([]string{name + ".Mean", v.Mean().String()})
+			cw.Write([]string{name + ".P50", v.Quantile(0.5).String()})
+			cw.Write([]string{name + ".P90", v.Quantile(0.9).String()})
+			cw.Write([]string{name + ".P99", v.Quantile(0.99).String()})
+			cw.Write([]string{name + ".Max", v.Max.String()})
+		default:
+			cw.Write([]string{name, fmt.Sprint(v)})
+		}
+	}
+
+	for i, burst := range r.Bursts {
+		prefix := fmt.Sprintf("Bursts.%d.", i)
+		cw.Write([]string{prefix + "AfterConnections", strconv.FormatUint(uint64(burst.AfterConnections), 10)})
+		cw.Write([]string{prefix + "Triggered", strconv.FormatBool(burst.Triggered)})
+		cw.Write([]string{prefix + "StartedAfter", burst.StartedAfter})
+		cw.Write([]string{prefix + "Duration", burst.Duration})
+		cw.Write([]string{prefix + "Outage", strconv.FormatBool(burst.Outage)})
+		cw.Write([]string{prefix + "FailureRatio", strconv.Itoa(burst.FailureRatio)})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
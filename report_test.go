@@ -0,0 +1,98 @@
+package badnet
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// runReported proxies two echoes, the second during an outage, and returns the
+// report written once the proxy closes.
+func runReported(t *testing.T, path string) []byte {
+	t.Helper()
+
+	rec := &cleanupT{TB: t}
+	proxy := ForTest(rec, Config{
+		Listen:      "127.0.0.1:0",
+		Target:      echoServer(t),
+		StatsReport: path,
+		Bursts: []Burst{
+			{AfterConnections: 2, Duration: time.Minute, Outage: true},
+			{AfterConnections: 100, Duration: time.Minute},
+		},
+	})
+
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", proxy.BindAddr())
+		require.NoError(t, err)
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte("ping"))
+		conn.(*net.TCPConn).CloseWrite()
+		io.ReadAll(conn)
+		conn.Close()
+	}
+	require.Eventually(t, func() bool {
+		return proxy.active.Load() == 0
+	}, time.Second, 10*time.Millisecond)
+
+	rec.finish()
+	require.Empty(t, rec.errors)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return data
+}
+
+func TestStatsReportJSON(t *testing.T) {
+	data := runReported(t, filepath.Join(t.TempDir(), "report.json"))
+
+	var report struct {
+		Failures uint32
+		Stats    struct {
+			Connections uint32
+			Outages     uint32
+			ReadBytes   uint64
+			TargetDial  struct{ Count uint64 }
+		}
+		Bursts []struct {
+			Triggered    bool
+			StartedAfter string
+		}
+	}
+	require.NoError(t, json.Unmarshal(data, &report))
+	require.Equal(t, uint32(2), report.Stats.Connections)
+	require.Equal(t, uint32(1), report.Stats.Outages)
+	require.Equal(t, uint32(1), report.Failures)
+	require.Equal(t, uint64(4), report.Stats.ReadBytes)
+	require.Equal(t, uint64(1), report.Stats.TargetDial.Count)
+
+	require.Len(t, report.Bursts, 2)
+	require.True(t, report.Bursts[0].Triggered)
+	require.NotEmpty(t, report.Bursts[0].StartedAfter)
+	require.False(t, report.Bursts[1].Triggered)
+}
+
+func TestStatsReportCSV(t *testing.T) {
+	data := runReported(t, filepath.Join(t.TempDir(), "report.csv"))
+
+	rows, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	require.NoError(t, err)
+	values := make(map[string]string)
+	for _, row := range rows {
+		values[row[0]] = row[1]
+	}
+	require.Equal(t, "2", values["Connections"])
+	require.Equal(t, "1", values["Outages"])
+	require.Equal(t, "1", values["TargetDial.Count"])
+	require.Contains(t, values, "TargetDial.P99")
+	require.Equal(t, "true", values["Bursts.0.Triggered"])
+	require.Equal(t, "false", values["Bursts.1.Triggered"])
+}
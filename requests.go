@@ -0,0 +1,112 @@
+package badnet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// FailNextRequests fails exactly the next n HTTP requests sent through the proxy,
+// then lets requests through again, so retry tests can assert how many attempts
+// were made. Failed requests are answered with status, such as 503, or have their
+// connection closed according to CloseMode when status is zero. Each call replaces
+// the previous budget and n of zero cancels it.
+//
+// Requests are recognized on connections the proxy inspects, which is every
+// connection unless PreserveHost is set without any other faults, in which case
+// only connections opened after the call are covered.
+func (p *Proxy) FailNextRequests(n int, status int) {
+	p.requests.set(n, status)
+}
+
+var errRequestFailed = errors.New("badnet: request failed by FailNextRequests")
+
+// requestBudget counts down the requests left to fail.
+type requestBudget struct {
+	mu        sync.Mutex
+	remaining int
+	status    int
+}
+
+func (b *requestBudget) set(n, status int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.remaining, b.status = max(n, 0), status
+}
+
+func (b *requestBudget) pending() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.remaining > 0
+}
+
+// take spends one request from the budget, reporting if it should fail and the
+// status to answer it with.
+func (b *requestBudget) take() (bool, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.remaining <= 0 {
+		return false, 0
+	}
+	b.remaining--
+	return true, b.status
+}
+
+// requestFaultToxic fails requests read from clients while p.requests has any left.
+func (p *Proxy) requestFaultToxic() Toxic {
+	return ToxicFunc(func(c net.Conn, _ ToxicInfo) net.Conn {
+		return &requestFaultConn{Conn: c, requests: p.requests, onFailed: p.requestFailed}
+	})
+}
+
+// requestFaultConn watches reads for the start of HTTP requests.
+type requestFaultConn struct {
+	net.Conn
+
+	requests *requestBudget
+	onFailed func()
+}
+
+// NetConn returns the underlying connection.
+func (c *requestFaultConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *requestFaultConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n == 0 || !isRequestStart(b[:n]) {
+		return n, err
+	}
+	fail, status := c.requests.take()
+	if !fail {
+		return n, err
+	}
+	c.onFailed()
+
+	// The request is dropped and, when there's a status, answered in its place
+	if status > 0 {
+		fmt.Fprintf(c.Conn, "HTTP/1.1 %d %s\r\nContent-Length: 0\r\nConnection: close\r\n\r\n", status, http.StatusText(status))
+	}
+	return 0, errRequestFailed
+}
+
+// isRequestStart reports if data begins with an HTTP/1 request line.
+func isRequestStart(data []byte) bool {
+	line, _, _ := bytes.Cut(data, []byte("\n"))
+	method, rest, ok := bytes.Cut(line, []byte(" "))
+	if !ok || len(method) == 0 {
+		return false
+	}
+	for _, r := range method {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return bytes.Contains(rest, []byte(" HTTP/1."))
+}
@@ -0,0 +1,54 @@
+package badnet
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsRequestStart(t *testing.T) {
+	require.True(t, isRequestStart([]byte("GET /users HTTP/1.1\r\nHost: example.com\r\n\r\n")))
+	require.True(t, isRequestStart([]byte("DELETE /users/1 HTTP/1.0\r\n")))
+	require.False(t, isRequestStart([]byte("HTTP/1.1 200 OK\r\n")))
+	require.False(t, isRequestStart([]byte("get / HTTP/1.1\r\n")))
+	require.False(t, isRequestStart([]byte{0x16, 0x03, 0x01}))
+}
+
+func TestFailNextRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	proxy := ForHTTPTest(t, server, Config{})
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	get := func() (int, error) {
+		resp, err := client.Get(proxy.URL)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	// Exactly the next two requests fail with the status
+	proxy.FailNextRequests(2, http.StatusServiceUnavailable)
+	for _, want := range []int{503, 503, 200, 200} {
+		status, err := get()
+		require.NoError(t, err)
+		require.Equal(t, want, status)
+	}
+
+	// and without one their connections are closed
+	proxy.FailNextRequests(1, 0)
+	_, err := get()
+	require.Error(t, err)
+	status, err := get()
+	require.NoError(t, err)
+	require.Equal(t, 200, status)
+
+	require.Equal(t, uint32(3), proxy.Stats().FailedRequests)
+}
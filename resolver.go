@@ -0,0 +1,233 @@
+package badnet
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// ResolverConfig describes the faults a Resolver injects into DNS lookups.
+type ResolverConfig struct {
+	// NXDomainRatio is the percentage of lookups answered as if the name doesn't exist.
+	NXDomainRatio int
+
+	// ServFailRatio is the percentage of lookups which fail with a temporary server error.
+	ServFailRatio int
+
+	// WrongRatio is the percentage of lookups answered with WrongAddrs, which default
+	// to documentation addresses (192.0.2.1 and 2001:db8::1) that nothing listens on.
+	WrongRatio int
+	WrongAddrs []net.IP
+
+	// StaleRatio is the percentage of lookups answered with the previous, different
+	// answer for the name, as a cache which missed an update would.
+	StaleRatio int
+
+	// DelayRatio is the percentage of lookups delayed by Delay before answering.
+	DelayRatio int
+	Delay      time.Duration
+
+	// Clock times Delay, defaulting to the system clock. See FakeClock.
+	Clock Clock
+
+	// Records are answered instead of asking Upstream. They can be changed with
+	// Resolver.SetRecords.
+	Records map[string][]string
+
+	// Upstream resolves names without Records. Defaults to net.DefaultResolver.
+	Upstream *net.Resolver
+}
+
+// Resolver performs DNS lookups with faults injected. Its methods match those of
+// net.Resolver so it can replace one in code which accepts an interface.
+type Resolver struct {
+	conf ResolverConfig
+
+	mu       sync.Mutex
+	records  map[string][]net.IP
+	answers  map[string][]net.IP // last answer given for each host
+	previous map[string][]net.IP // answer given before the last one changed
+}
+
+// random reports if any lookups are answered with faults chosen at random.
+func (c ResolverConfig) random() bool {
+	return c.NXDomainRatio > 0 || c.ServFailRatio > 0 || c.WrongRatio > 0 || c.StaleRatio > 0 || c.DelayRatio > 0
+}
+
+// NewResolver returns a Resolver which injects the configured faults.
+func NewResolver(conf ResolverConfig) *Resolver {
+	if len(conf.WrongAddrs) == 0 {
+		conf.WrongAddrs = []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")}
+	}
+	if conf.Upstream == nil {
+		conf.Upstream = net.DefaultResolver
+	}
+	r := &Resolver{
+		conf:     conf,
+		records:  make(map[string][]net.IP),
+		answers:  make(map[string][]net.IP),
+		previous: make(map[string][]net.IP),
+	}
+	for host, addrs := range conf.Records {
+		r.SetRecords(host, addrs...)
+	}
+	return r
+}
+
+// SetRecords replaces the addresses answered for host.
+func (r *Resolver) SetRecords(host string, addrs ...string) {
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.records[host] = ips
+}
+
+// LookupHost looks up the given host, returning a slice of its addresses.
+func (r *Resolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	ips, err := r.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, len(ips))
+	for i := range ips {
+		out[i] = ips[i].String()
+	}
+	return out, nil
+}
+
+// LookupIPAddr looks up host, returning a slice of its IPv4 and IPv6 addresses.
+func (r *Resolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ips, err := r.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]net.IPAddr, len(ips))
+	for i := range ips {
+		out[i] = net.IPAddr{IP: ips[i]}
+	}
+	return out, nil
+}
+
+// LookupIP looks up host for the given network ("ip", "ip4" or "ip6").
+func (r *Resolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	if shouldFail(r.conf.DelayRatio) {
+		select {
+		case <-r.clock().After(r.conf.Delay):
+		case <-ctx.Done():
+			return nil, &net.DNSError{Err: ctx.Err().Error(), Name: host, IsTimeout: true}
+		}
+	}
+
+	switch {
+	case shouldFail(r.conf.NXDomainRatio):
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+
+	case shouldFail(r.conf.ServFailRatio):
+		return nil, &net.DNSError{Err: "server misbehaving", Name: host, IsTemporary: true}
+
+	case shouldFail(r.conf.WrongRatio):
+		return filterIPs(network, r.conf.WrongAddrs), nil
+	}
+
+	ips, err := r.resolve(ctx, network, host)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if last, ok := r.answers[host]; ok && !equalIPs(last, ips) {
+		r.previous[host] = last
+	}
+	r.answers[host] = ips
+
+	if shouldFail(r.conf.StaleRatio) {
+		if stale, ok := r.previous[host]; ok {
+			return stale, nil
+		}
+	}
+	return ips, nil
+}
+
+func (r *Resolver) clock() Clock {
+	if r.conf.Clock != nil {
+		return r.conf.Clock
+	}
+	return realClock{}
+}
+
+func (r *Resolver) resolve(ctx context.Context, network, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	r.mu.Lock()
+	ips, ok := r.records[host]
+	r.mu.Unlock()
+	if ok {
+		ips = filterIPs(network, ips)
+		if len(ips) == 0 {
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+		return ips, nil
+	}
+	return r.conf.Upstream.LookupIP(ctx, network, host)
+}
+
+// DialContext resolves the host in address with the Resolver and connects to the
+// first address which accepts the connection.
+func (r *Resolver) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := r.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+
+	var dialer net.Dialer
+	var lastErr error
+	for _, ip := range ips {
+		c, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return c, nil
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("dialing %s: %w", address, lastErr)
+}
+
+func filterIPs(network string, ips []net.IP) []net.IP {
+	out := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		is4 := ip.To4() != nil
+		if (network == "ip4" && !is4) || (network == "ip6" && is4) {
+			continue
+		}
+		out = append(out, ip)
+	}
+	return out
+}
+
+func equalIPs(a, b []net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+package badnet
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolver(t *testing.T) {
+	ctx := context.Background()
+	records := map[string][]string{
+		"db.test": {"127.0.0.1"},
+	}
+
+	t.Run("healthy", func(t *testing.T) {
+		r := NewResolver(ResolverConfig{Records: records})
+
+		addrs, err := r.LookupHost(ctx, "db.test")
+		require.NoError(t, err)
+		require.Equal(t, []string{"127.0.0.1"}, addrs)
+
+		_, err = r.LookupIP(ctx, "ip6", "db.test")
+		require.Error(t, err)
+	})
+
+	t.Run("NXDOMAIN", func(t *testing.T) {
+		r := NewResolver(ResolverConfig{Records: records, NXDomainRatio: 100})
+
+		_, err := r.LookupHost(ctx, "db.test")
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.IsNotFound)
+	})
+
+	t.Run("SERVFAIL", func(t *testing.T) {
+		r := NewResolver(ResolverConfig{Records: records, ServFailRatio: 100})
+
+		_, err := r.LookupIPAddr(ctx, "db.test")
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.Temporary())
+	})
+
+	t.Run("wrong", func(t *testing.T) {
+		r := NewResolver(ResolverConfig{Records: records, WrongRatio: 100})
+
+		ips, err := r.LookupIP(ctx, "ip4", "db.test")
+		require.NoError(t, err)
+		require.Equal(t, "192.0.2.1", ips[0].String())
+	})
+
+	t.Run("stale", func(t *testing.T) {
+		r := NewResolver(ResolverConfig{Records: records, StaleRatio: 100})
+
+		addrs, err := r.LookupHost(ctx, "db.test")
+		require.NoError(t, err)
+		require.Equal(t, []string{"127.0.0.1"}, addrs)
+
+		r.SetRecords("db.test", "127.0.0.2")
+		addrs, err = r.LookupHost(ctx, "db.test")
+		require.NoError(t, err)
+		require.Equal(t, []string{"127.0.0.1"}, addrs)
+	})
+
+	t.Run("delayed", func(t *testing.T) {
+		r := NewResolver(ResolverConfig{Records: records, DelayRatio: 100, Delay: time.Second})
+
+		ctx, cancelFunc := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancelFunc()
+
+		_, err := r.LookupHost(ctx, "db.test")
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		require.True(t, dnsErr.Timeout())
+	})
+
+	t.Run("DialContext", func(t *testing.T) {
+		_, port, err := net.SplitHostPort(echoServer(t))
+		require.NoError(t, err)
+
+		r := NewResolver(ResolverConfig{Records: records})
+		conn, err := r.DialContext(ctx, "tcp", net.JoinHostPort("db.test", port))
+		require.NoError(t, err)
+		require.NoError(t, conn.Close())
+	})
+}
@@ -0,0 +1,194 @@
+package badnet
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strings"
+)
+
+// route is where a proxied connection is sent and the faults applied along the way.
+type route struct {
+	target    string
+	targetTLS *tls.Config
+
+	// rewriteHost replaces the Host header of HTTP requests with the target
+	rewriteHost bool
+
+	read, write Direction
+
+	readLink, writeLink *link
+
+	// hijacked connections go to the HijackTarget
+	hijacked bool
+}
+
+// hostHeader returns the Host header HTTP requests are rewritten with, leaving
+// out the port when it's the default for the target's scheme, or "" when they're
+// forwarded unchanged.
+func (r route) hostHeader() string {
+	if _, ok := unixSocketPath(r.target); ok || !r.rewriteHost {
+		return ""
+	}
+	host, port, err := net.SplitHostPort(r.target)
+	if err != nil {
+		return r.target
+	}
+	if port == "80" && r.targetTLS == nil || port == "443" && r.targetTLS != nil {
+		if strings.Contains(host, ":") {
+			return "[" + host + "]"
+		}
+		return host
+	}
+	return r.target
+}
+
+// SNIRoute sends TLS connections requesting a server name to their own target,
+// with their own faults.
+type SNIRoute struct {
+	// Target defaults to Config.Target when empty.
+	Target    string
+	TargetTLS *tls.Config
+
+	Read  Direction
+	Write Direction
+}
+
+// route picks the target and faults for a connection from client which requested
+// serverName. client can be nil.
+func (p *Proxy) route(serverName string, client net.Addr) route {
+	rt := route{
+		target:      p.conf.targetAddress(),
+		targetTLS:   p.conf.TargetTLS,
+		rewriteHost: !p.conf.PreserveHost,
+		read:        p.conf.Read,
+		write:       p.conf.Write,
+		readLink:    p.throttles.read,
+		writeLink:   p.throttles.write,
+	}
+	if swapped := p.swappedTarget(); swapped != "" {
+		rt.target = swapped
+	} else if target := p.balancer.pick(); target != "" {
+		rt.target = targetAddress(target, p.conf.TargetTLS != nil)
+
+		opts := p.conf.TargetOptions[target]
+		links := p.routeThrottles[targetOptionsKey(target)]
+		if opts.Read != nil {
+			rt.read, rt.readLink = *opts.Read, links.read
+		}
+		if opts.Write != nil {
+			rt.write, rt.writeLink = *opts.Write, links.write
+		}
+	}
+	if sni, ok := p.conf.SNIRoutes[serverName]; ok && serverName != "" {
+		if sni.Target != "" {
+			rt.target = targetAddress(sni.Target, sni.TargetTLS != nil)
+			rt.targetTLS = sni.TargetTLS
+		}
+		rt.read, rt.write = sni.Read, sni.Write
+
+		links := p.routeThrottles[sniRouteKey(serverName)]
+		rt.readLink, rt.writeLink = links.read, links.write
+	}
+	if policy, ok := p.clientPolicy(client); ok {
+		links := p.routeThrottles[clientOptionsKey(policy.key)]
+		if policy.opts.Read != nil {
+			rt.read, rt.readLink = *policy.opts.Read, links.read
+		}
+		if policy.opts.Write != nil {
+			rt.write, rt.writeLink = *policy.opts.Write, links.write
+		}
+	}
+	return rt
+}
+
+// newRouteThrottles creates the links for each TargetOptions, SNIRoute and Clients entry which
+// replaces the Config's faults, so they're shared between that route's connections.
+func newRouteThrottles(conf Config) map[string]throttles {
+	out := make(map[string]throttles)
+	for target, opts := range conf.TargetOptions {
+		var links throttles
+		if opts.Read != nil {
+			links.read = newLink(*opts.Read, conf.clock())
+		}
+		if opts.Write != nil {
+			links.write = newLink(*opts.Write, conf.clock())
+		}
+		out[targetOptionsKey(target)] = links
+	}
+	for network, opts := range conf.Clients {
+		var links throttles
+		if opts.Read != nil {
+			links.read = newLink(*opts.Read, conf.clock())
+		}
+		if opts.Write != nil {
+			links.write = newLink(*opts.Write, conf.clock())
+		}
+		out[clientOptionsKey(network)] = links
+	}
+	for serverName, sni := range conf.SNIRoutes {
+		out[sniRouteKey(serverName)] = throttles{
+			read:  newLink(sni.Read, conf.clock()),
+			write: newLink(sni.Write, conf.clock()),
+		}
+	}
+	return out
+}
+
+func targetOptionsKey(target string) string {
+	return "target:" + target
+}
+
+func sniRouteKey(serverName string) string {
+	return "sni:" + serverName
+}
+
+var errPeeked = errors.New("peeked")
+
+// peekServerName reads the TLS ClientHello from c and returns the server name it
+// requests, if any, along with a net.Conn which replays the bytes that were read.
+func peekServerName(c net.Conn) (string, net.Conn) {
+	var buf bytes.Buffer
+	var serverName string
+
+	// Run the server side of a handshake just far enough to parse the ClientHello
+	tls.Server(&readOnlyConn{Conn: c, r: io.TeeReader(c, &buf)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, errPeeked
+		},
+	}).Handshake()
+
+	return serverName, &replayConn{Conn: c, r: io.MultiReader(&buf, c)}
+}
+
+// readOnlyConn discards writes so a partial handshake never reaches the client.
+type readOnlyConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *readOnlyConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *readOnlyConn) Write(b []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+// replayConn returns previously peeked bytes before reading from the connection.
+type replayConn struct {
+	net.Conn
+	r io.Reader
+}
+
+// NetConn returns the underlying connection.
+func (c *replayConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
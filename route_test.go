@@ -0,0 +1,101 @@
+package badnet
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSNIRoutes(t *testing.T) {
+	handler := func(body string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Write([]byte(body))
+		})
+	}
+	get := func(t *testing.T, client *http.Client, address string) (string, error) {
+		t.Helper()
+
+		resp, err := client.Get(address)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		bs, err := io.ReadAll(resp.Body)
+		return string(bs), err
+	}
+
+	t.Run("terminating", func(t *testing.T) {
+		healthy := httptest.NewServer(handler("healthy"))
+		t.Cleanup(healthy.Close)
+		other := httptest.NewServer(handler("other"))
+		t.Cleanup(other.Close)
+
+		proxy := ForTest(t, Config{
+			Listen:    "127.0.0.1:0",
+			Target:    healthy.URL,
+			ListenTLS: true,
+			SNIRoutes: map[string]SNIRoute{
+				"other.test": {Target: other.URL},
+				"broken.test": {
+					// Requests fail after they're forwarded, so fail the response too
+					Read:  Direction{FailureRatio: 100},
+					Write: Direction{FailureRatio: 100},
+				},
+			},
+		})
+
+		client := func(serverName string) *http.Client {
+			conf := proxy.ClientTLSConfig()
+			conf.ServerName = serverName
+			return &http.Client{Transport: &http.Transport{TLSClientConfig: conf}}
+		}
+
+		body, err := get(t, client(""), "https://"+proxy.BindAddr())
+		require.NoError(t, err)
+		require.Equal(t, "healthy", body)
+
+		body, err = get(t, client("other.test"), "https://"+proxy.BindAddr())
+		require.NoError(t, err)
+		require.Equal(t, "other", body)
+
+		_, err = get(t, client("broken.test"), "https://"+proxy.BindAddr())
+		require.Error(t, err)
+	})
+
+	t.Run("peeking", func(t *testing.T) {
+		healthy := httptest.NewTLSServer(handler("healthy"))
+		t.Cleanup(healthy.Close)
+		other := httptest.NewTLSServer(handler("other"))
+		t.Cleanup(other.Close)
+
+		proxy := ForTest(t, Config{
+			Listen: "127.0.0.1:0",
+			Target: healthy.URL,
+			SNIRoutes: map[string]SNIRoute{
+				"other.test": {Target: other.URL},
+			},
+		})
+
+		client := func(serverName string) *http.Client {
+			return &http.Client{Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					ServerName:         serverName,
+					InsecureSkipVerify: true, //nolint:gosec
+				},
+			}}
+		}
+
+		body, err := get(t, client("healthy.test"), "https://"+proxy.BindAddr())
+		require.NoError(t, err)
+		require.Equal(t, "healthy", body)
+
+		body, err = get(t, client("other.test"), "https://"+proxy.BindAddr())
+		require.NoError(t, err)
+		require.Equal(t, "other", body)
+	})
+}
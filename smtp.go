@@ -0,0 +1,57 @@
+package badnet
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// SMTPBannerDelay holds back the greeting an SMTP server sends clients through a
+// Proxy, such as "220 mail.example.com ESMTP", by delay, like a server doing slow
+// reverse DNS or deliberately delaying to catch spammers. It works for any protocol
+// where the server speaks first, such as FTP or MySQL. Use it with Config.Toxics.
+func SMTPBannerDelay(delay time.Duration) Toxic {
+	return ToxicFunc(func(c net.Conn, info ToxicInfo) net.Conn {
+		ctx := info.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		return &bannerDelayConn{Conn: c, ctx: ctx, clock: info.clock(), delay: delay, closed: make(chan struct{})}
+	})
+}
+
+// bannerDelayConn waits before its first write, until ctx is done or the
+// connection is closed.
+type bannerDelayConn struct {
+	net.Conn
+
+	ctx   context.Context
+	clock Clock
+	delay time.Duration
+	once  sync.Once
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NetConn returns the underlying connection.
+func (c *bannerDelayConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *bannerDelayConn) Write(b []byte) (int, error) {
+	var err error
+	c.once.Do(func() {
+		err = sleep(c.ctx, c.clock, c.delay, c.closed)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *bannerDelayConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
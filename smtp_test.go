@@ -0,0 +1,66 @@
+package badnet
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSMTPBannerDelay(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("220 mail.example.com ESMTP\r\n"))
+			conn.Close()
+		}
+	}()
+
+	proxy := ForTest(t, Config{
+		Listen: "127.0.0.1:0",
+		Target: ln.Addr().String(),
+		Toxics: []Toxic{SMTPBannerDelay(100 * time.Millisecond)},
+	})
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	start := time.Now()
+	banner, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "220 mail.example.com ESMTP\r\n", banner)
+	require.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestSMTPBannerDelayClose(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	client, _ := net.Pipe()
+	conn := SMTPBannerDelay(time.Hour).Wrap(client, ToxicInfo{Clock: clock})
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := conn.Write([]byte("220 mail.example.com ESMTP\r\n"))
+		errs <- err
+	}()
+	require.Eventually(t, func() bool {
+		return clock.Waiters() == 1
+	}, time.Second, time.Millisecond)
+
+	// Closing ends the wait, even without a context to cancel
+	require.NoError(t, conn.Close())
+	select {
+	case err := <-errs:
+		require.ErrorIs(t, err, net.ErrClosed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write didn't return after Close")
+	}
+	require.Zero(t, clock.Waiters())
+}
@@ -0,0 +1,123 @@
+package badnet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"syscall"
+)
+
+// SOCKS5 protocol values, see RFC 1928
+const (
+	socks5Version = 0x05
+
+	socks5NoAuth       = 0x00
+	socks5NoAcceptable = 0xff
+
+	socks5Connect = 0x01
+
+	socks5IPv4   = 0x01
+	socks5Domain = 0x03
+	socks5IPv6   = 0x04
+
+	socks5Succeeded          = 0x00
+	socks5GeneralFailure     = 0x01
+	socks5ConnectionRefused  = 0x05
+	socks5CommandUnsupported = 0x07
+	socks5AddressUnsupported = 0x08
+)
+
+// readSOCKS5Request negotiates a SOCKS5 session without authentication and returns
+// the destination of the client's CONNECT request.
+func readSOCKS5Request(c net.Conn) (string, error) {
+	// Greeting: VER NMETHODS METHODS...
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c, header); err != nil {
+		return "", fmt.Errorf("reading SOCKS5 greeting: %w", err)
+	}
+	if header[0] != socks5Version {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(c, methods); err != nil {
+		return "", fmt.Errorf("reading SOCKS5 methods: %w", err)
+	}
+	method := byte(socks5NoAcceptable)
+	for _, m := range methods {
+		if m == socks5NoAuth {
+			method = socks5NoAuth
+		}
+	}
+	if _, err := c.Write([]byte{socks5Version, method}); err != nil {
+		return "", fmt.Errorf("writing SOCKS5 method: %w", err)
+	}
+	if method == socks5NoAcceptable {
+		return "", errors.New("SOCKS5 client does not support unauthenticated sessions")
+	}
+
+	// Request: VER CMD RSV ATYP DST.ADDR DST.PORT
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(c, request); err != nil {
+		return "", fmt.Errorf("reading SOCKS5 request: %w", err)
+	}
+	if request[1] != socks5Connect {
+		writeSOCKS5Reply(c, socks5CommandUnsupported)
+		return "", fmt.Errorf("unsupported SOCKS5 command %d", request[1])
+	}
+
+	var host string
+	switch request[3] {
+	case socks5IPv4, socks5IPv6:
+		size := net.IPv4len
+		if request[3] == socks5IPv6 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(c, ip); err != nil {
+			return "", fmt.Errorf("reading SOCKS5 address: %w", err)
+		}
+		host = net.IP(ip).String()
+
+	case socks5Domain:
+		size := make([]byte, 1)
+		if _, err := io.ReadFull(c, size); err != nil {
+			return "", fmt.Errorf("reading SOCKS5 domain: %w", err)
+		}
+		domain := make([]byte, size[0])
+		if _, err := io.ReadFull(c, domain); err != nil {
+			return "", fmt.Errorf("reading SOCKS5 domain: %w", err)
+		}
+		host = string(domain)
+
+	default:
+		writeSOCKS5Reply(c, socks5AddressUnsupported)
+		return "", fmt.Errorf("unsupported SOCKS5 address type %d", request[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(c, port); err != nil {
+		return "", fmt.Errorf("reading SOCKS5 port: %w", err)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// writeSOCKS5Dial replies to the client's CONNECT request with the result of dialing.
+func writeSOCKS5Dial(c net.Conn, dialErr error) error {
+	switch {
+	case dialErr == nil:
+		return writeSOCKS5Reply(c, socks5Succeeded)
+	case errors.Is(dialErr, syscall.ECONNREFUSED):
+		return writeSOCKS5Reply(c, socks5ConnectionRefused)
+	default:
+		return writeSOCKS5Reply(c, socks5GeneralFailure)
+	}
+}
+
+func writeSOCKS5Reply(c net.Conn, code byte) error {
+	// The bound address isn't meaningful to clients of a proxy, so report 0.0.0.0:0
+	_, err := c.Write([]byte{socks5Version, code, 0x00, socks5IPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
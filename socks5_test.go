@@ -0,0 +1,57 @@
+package badnet
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSOCKS5(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("PONG"))
+	}))
+	t.Cleanup(server.Close)
+
+	get := func(proxy *Proxy) (string, error) {
+		client := &http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyURL(&url.URL{Scheme: "socks5", Host: proxy.BindAddr()}),
+			},
+		}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		bs, err := io.ReadAll(resp.Body)
+		return string(bs), err
+	}
+
+	t.Run("healthy", func(t *testing.T) {
+		proxy := ForTest(t, Config{
+			Listen: "127.0.0.1:0",
+			SOCKS5: true,
+		})
+
+		body, err := get(proxy)
+		require.NoError(t, err)
+		require.Equal(t, "PONG", body)
+	})
+
+	t.Run("failing", func(t *testing.T) {
+		proxy := ForTest(t, Config{
+			Listen: "127.0.0.1:0",
+			SOCKS5: true,
+			Write:  Direction{FailureRatio: 100},
+		})
+
+		_, err := get(proxy)
+		require.Error(t, err)
+		require.Equal(t, uint32(1), proxy.writeFailures.Load())
+	})
+}
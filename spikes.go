@@ -0,0 +1,43 @@
+package badnet
+
+import (
+	"time"
+)
+
+// Spikes add occasional large delays on top of a Direction's Latency, like a garbage
+// collection pause or a route flapping, to exercise tail latency handling such as
+// hedged requests and speculative retries.
+type Spikes struct {
+	// Latency is how long each spike holds operations up, such as 2 seconds.
+	Latency time.Duration
+
+	// Interval pauses the direction for Latency once every Interval, starting an
+	// Interval after the proxy or Dialer starts. Operations during a pause wait
+	// for it to end, across every connection sharing the Direction.
+	Interval time.Duration
+
+	// Ratio is the percentage of operations which are delayed by Latency at random.
+	Ratio int
+}
+
+func (s Spikes) enabled() bool {
+	return s.Latency > 0 && (s.Interval > 0 || s.Ratio > 0)
+}
+
+// delay returns how long an operation is held up once elapsed has passed since
+// the spikes started.
+func (s Spikes) delay(elapsed time.Duration) time.Duration {
+	if !s.enabled() {
+		return 0
+	}
+	var d time.Duration
+	if s.Interval > 0 && elapsed >= s.Interval {
+		if into := elapsed % s.Interval; into < s.Latency {
+			d = s.Latency - into
+		}
+	}
+	if shouldFail(s.Ratio) && d < s.Latency {
+		d = s.Latency
+	}
+	return d
+}
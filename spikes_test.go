@@ -0,0 +1,63 @@
+package badnet
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpikesDelay(t *testing.T) {
+	spikes := Spikes{Latency: 2 * time.Second, Interval: time.Minute}
+	require.True(t, spikes.enabled())
+	require.Zero(t, spikes.delay(0))
+	require.Zero(t, spikes.delay(59*time.Second))
+	require.Equal(t, 2*time.Second, spikes.delay(time.Minute))
+	require.Equal(t, 500*time.Millisecond, spikes.delay(2*time.Minute+1500*time.Millisecond))
+	require.Zero(t, spikes.delay(2*time.Minute+2*time.Second))
+
+	spikes = Spikes{Latency: time.Second, Ratio: 100}
+	require.Equal(t, time.Second, spikes.delay(0))
+
+	require.False(t, Spikes{Latency: time.Second}.enabled())
+	require.False(t, Spikes{Interval: time.Second, Ratio: 50}.enabled())
+}
+
+func TestSpikes(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	proxy := ForTest(t, Config{
+		Target: echoServer(t),
+		Write: Direction{
+			Spikes: Spikes{Latency: 10 * time.Second, Interval: time.Minute},
+		},
+		Clock: clock,
+	})
+
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ping := func() error {
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err := io.ReadFull(conn, make([]byte, 4))
+		return err
+	}
+	require.NoError(t, ping())
+
+	// Two seconds into a pause the echo waits for the remaining eight
+	clock.Advance(time.Minute + 2*time.Second)
+	done := make(chan error, 1)
+	go func() { done <- ping() }()
+
+	require.Eventually(t, func() bool { return clock.Waiters() == 1 }, 5*time.Second, 5*time.Millisecond)
+	clock.Advance(8 * time.Second)
+	require.NoError(t, <-done)
+
+	require.Equal(t, uint64(1), proxy.Stats().InjectedDelay.Count)
+	require.Equal(t, 8*time.Second, proxy.Stats().InjectedDelay.Max)
+}
@@ -0,0 +1,77 @@
+package badnet
+
+import (
+	"io"
+	"net"
+)
+
+// passive reports if the direction leaves the data it carries untouched, so its
+// connections can be forwarded without inspecting each byte.
+func (d Direction) passive() bool {
+	return d.FailureRatio == 0 && d.FailureProbability == 0 && d.FailureBasisPoints == 0 && d.FailEveryNth == 0 &&
+		d.TruncateRatio == 0 && d.Garbage.Ratio == 0 && d.DuplicateRatio == 0 &&
+		!d.Trickle.enabled() && !d.Squeeze.enabled() && !d.Coalesce.enabled() && d.MTU == 0 && len(d.Toxics) == 0
+}
+
+// unthrottled reports if the link currently has no bandwidth limit or latency.
+func (l *link) unthrottled() bool {
+	rate, _, latency := l.settings()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return rate == 0 && latency == 0 && l.perKB == 0 && !l.ramping && !l.spikes.enabled()
+}
+
+// fastPath reports if a connection following rt can be forwarded as-is, which lets
+// the kernel move data directly between sockets (splice on Linux) instead of badnet
+// copying it. Only faults which act on whole connections, like outages, connection
+// limits and MaxConnectionAge, can be configured, and Config.PreserveHost must be
+// set since rewriting Host headers reads each request. Later calls to SetBandwidth or
+// SetLatency don't affect connections which were forwarded this way.
+func (p *Proxy) fastPath(rt route) bool {
+	if p.tlsConfig != nil || len(p.conf.SNIRoutes) > 0 || p.conf.TLSHandshake.enabled() {
+		return false
+	}
+	if rt.hostHeader() != "" || p.conf.IdleTimeout > 0 || p.conf.MaxBytesPerConnection > 0 || len(p.conf.Toxics) > 0 {
+		return false
+	}
+	if p.trace.replaying() || p.offline != nil || p.requests.pending() ||
+		p.conf.ConnectionIDHeader != "" || p.conf.ReorderResponses.enabled() {
+		return false
+	}
+	for _, burst := range p.conf.Bursts {
+		if burst.FailureRatio > 0 {
+			return false
+		}
+	}
+	return rt.read.passive() && rt.write.passive() &&
+		rt.readLink.unthrottled() && rt.writeLink.unthrottled()
+}
+
+// splice copies between two unwrapped connections, letting io.Copy use ReadFrom
+// and WriteTo to avoid copying through userspace where the platform allows.
+func splice(errCh chan error, dst net.Conn, src io.Reader, count func(n int)) {
+	n, err := io.Copy(dst, src)
+	count(int(n))
+	if err == nil {
+		closeWrite(dst)
+	}
+	errCh <- err
+}
+
+// closeWrite shuts down the writing side of c so the peer sees EOF while data can
+// still flow the other way. Wrapped connections are unwrapped to find a connection
+// which supports half-closing, and c is closed entirely if none does.
+func closeWrite(c net.Conn) error {
+	for {
+		if cw, ok := c.(interface{ CloseWrite() error }); ok {
+			return cw.CloseWrite()
+		}
+		u, ok := c.(interface{ NetConn() net.Conn })
+		if !ok {
+			return c.Close()
+		}
+		c = u.NetConn()
+	}
+}
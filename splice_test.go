@@ -0,0 +1,96 @@
+package badnet
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFastPath(t *testing.T) {
+	target := echoServer(t)
+
+	cases := []struct {
+		conf     Config
+		expected bool
+	}{
+		{Config{Target: target, PreserveHost: true}, true},
+		{Config{Target: target, PreserveHost: true, MaxConnectionAge: time.Minute, FailFirstConnections: 1}, true},
+		// Host headers are rewritten by default, which reads each request
+		{Config{Target: target}, false},
+		{Config{Target: target, PreserveHost: true, Read: Direction{FailureRatio: 1}}, false},
+		{Config{Target: target, PreserveHost: true, Write: Direction{Latency: time.Millisecond}}, false},
+		{Config{Target: target, PreserveHost: true, Bursts: []Burst{{FailureRatio: 10}}}, false},
+		{Config{Target: target, PreserveHost: true, IdleTimeout: time.Minute}, false},
+	}
+	for i, tc := range cases {
+		tc.conf.Listen = "127.0.0.1:0"
+		proxy := ForTest(t, tc.conf)
+		require.Equal(t, tc.expected, proxy.fastPath(proxy.route("", nil)), "case %d", i)
+	}
+
+	// Data is forwarded over the fast path
+	proxy := ForTest(t, Config{
+		Listen:       "127.0.0.1:0",
+		Target:       target,
+		PreserveHost: true,
+	})
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	payload := make([]byte, 1<<20)
+	go conn.Write(payload)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadFull(conn, make([]byte, len(payload)))
+	require.NoError(t, err)
+
+	// Later changes to the bandwidth disable it for new connections
+	proxy.SetBandwidth(10, 10)
+	require.False(t, proxy.fastPath(proxy.route("", nil)))
+}
+
+func TestHalfClose(t *testing.T) {
+	// The server reads until EOF and only then responds
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+
+				bs, _ := io.ReadAll(conn)
+				conn.Write(append([]byte("got "), bs...))
+			}()
+		}
+	}()
+
+	// Check both the regular and fast paths
+	for _, preserveHost := range []bool{false, true} {
+		proxy := ForTest(t, Config{
+			Listen:       "127.0.0.1:0",
+			Target:       ln.Addr().String(),
+			PreserveHost: preserveHost,
+		})
+		conn, err := net.Dial("tcp", proxy.BindAddr())
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+		require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+		bs, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		require.Equal(t, "got ping", string(bs))
+	}
+}
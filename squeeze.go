@@ -0,0 +1,109 @@
+package badnet
+
+import (
+	"net"
+	"time"
+)
+
+// Squeeze drains a connection slower and slower, from From down to To over Duration,
+// so the sender's buffers fill and TCP flow control pushes back on it, rather than
+// data arriving late as it does with latency. Each connection starts at From when
+// it's opened and stays at To once Duration has passed.
+//
+// On the Read direction the client's writes back up, and on the Write direction
+// the target's do as the proxy stops reading from it.
+type Squeeze struct {
+	From, To Rate
+	Duration time.Duration
+}
+
+func (s Squeeze) enabled() bool {
+	return s.From > 0 && s.To > 0 && s.Duration > 0
+}
+
+// at returns the rate in bytes per second once elapsed has passed.
+func (s Squeeze) at(elapsed time.Duration) float64 {
+	progress := min(float64(elapsed)/float64(s.Duration), 1)
+	from, to := s.From.bytesPerSecond(), s.To.bytesPerSecond()
+	return from + (to-from)*progress
+}
+
+// squeezeSlice is how much of a second's data is moved at once, keeping the pace
+// smooth instead of moving whole buffers and pausing for seconds.
+const squeezeSlice = 10
+
+// squeezeConn paces reads and writes according to a Squeeze in each direction.
+type squeezeConn struct {
+	net.Conn
+
+	read, write Squeeze
+	opened      time.Time
+	clock       Clock
+	observe     func(time.Duration)
+}
+
+// NetConn returns the underlying connection.
+func (c *squeezeConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func squeeze(c net.Conn, read, write Direction, clock Clock, observe func(time.Duration)) net.Conn {
+	if !read.Squeeze.enabled() && !write.Squeeze.enabled() {
+		return c
+	}
+	return &squeezeConn{
+		Conn:    c,
+		read:    read.Squeeze,
+		write:   write.Squeeze,
+		opened:  clock.Now(),
+		clock:   clock,
+		observe: observe,
+	}
+}
+
+func (c *squeezeConn) Read(b []byte) (int, error) {
+	if !c.read.enabled() {
+		return c.Conn.Read(b)
+	}
+	rate := c.read.at(c.clock.Now().Sub(c.opened))
+	if size := max(int(rate/squeezeSlice), 1); len(b) > size {
+		b = b[:size]
+	}
+	n, err := c.Conn.Read(b)
+	c.pause(n, rate)
+	return n, err
+}
+
+func (c *squeezeConn) Write(b []byte) (int, error) {
+	if !c.write.enabled() {
+		return c.Conn.Write(b)
+	}
+	var written int
+	for len(b) > 0 {
+		rate := c.write.at(c.clock.Now().Sub(c.opened))
+		chunk := b
+		if size := max(int(rate/squeezeSlice), 1); len(chunk) > size {
+			chunk = chunk[:size]
+		}
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		c.pause(n, rate)
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// pause waits as long as moving n bytes takes at rate bytes per second.
+func (c *squeezeConn) pause(n int, rate float64) {
+	if n <= 0 {
+		return
+	}
+	d := time.Duration(float64(n) / rate * float64(time.Second))
+	c.clock.Sleep(d)
+	if c.observe != nil {
+		c.observe(d)
+	}
+}
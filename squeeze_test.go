@@ -0,0 +1,85 @@
+package badnet
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSqueezeAt(t *testing.T) {
+	s := Squeeze{From: 80 * Kbit, To: 8 * Kbit, Duration: time.Second}
+	require.Equal(t, 10000.0, s.at(0))
+	require.Equal(t, 5500.0, s.at(500*time.Millisecond))
+	require.Equal(t, 1000.0, s.at(time.Second))
+	require.Equal(t, 1000.0, s.at(time.Minute))
+}
+
+// sleepClock moves forward by however long it's asked to sleep.
+type sleepClock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *sleepClock) Now() time.Time                         { return c.now }
+func (c *sleepClock) After(d time.Duration) <-chan time.Time { return time.After(0) }
+func (c *sleepClock) Sleep(d time.Duration) {
+	c.now = c.now.Add(d)
+	c.sleeps = append(c.sleeps, d)
+}
+
+func TestSqueezeConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	clock := &sleepClock{now: time.Now()}
+	read := Direction{Squeeze: Squeeze{From: 80 * Kbit, To: 8 * Kbit, Duration: time.Second}}
+	c := squeeze(server, read, Direction{}, clock, nil)
+	defer c.Close()
+
+	go client.Write([]byte(strings.Repeat("x", 3000)))
+
+	// Each read is smaller and slower than the one before
+	buf := make([]byte, 4096)
+	var sizes []int
+	var total int
+	for total < 3000 {
+		n, err := c.Read(buf)
+		require.NoError(t, err)
+		sizes = append(sizes, n)
+		total += n
+	}
+	require.Equal(t, 1000, sizes[0])
+	require.Equal(t, 100*time.Millisecond, clock.sleeps[0])
+	for i := 1; i < len(sizes); i++ {
+		require.LessOrEqual(t, sizes[i], sizes[i-1])
+		perByte, before := clock.sleeps[i]/time.Duration(sizes[i]), clock.sleeps[i-1]/time.Duration(sizes[i-1])
+		require.Greater(t, perByte, before)
+	}
+}
+
+func TestSqueeze(t *testing.T) {
+	proxy := ForTest(t, Config{
+		Listen: "127.0.0.1:0",
+		Target: echoServer(t),
+		Write:  Direction{Squeeze: Squeeze{From: 800 * Kbit, To: 80 * Kbit, Duration: 100 * time.Millisecond}},
+	})
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	msg := strings.Repeat("squeeze", 500)
+	go conn.Write([]byte(msg))
+
+	buf := make([]byte, len(msg))
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, msg, string(buf))
+	// Pauses are counted once they've finished
+	require.Eventually(t, func() bool {
+		return proxy.Stats().InjectedDelay.Count > 0
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,160 @@
+package badnet
+
+// Stats is a snapshot of the counters kept by a Proxy or Dialer.
+type Stats struct {
+	Connections uint32
+
+	// ReadBytes is how much data a Proxy forwarded from clients to targets and
+	// WriteBytes is how much it forwarded from targets back to clients. Both are
+	// zero for a Dialer.
+	ReadBytes  uint64
+	WriteBytes uint64
+
+	ReadFailures   uint32
+	WriteFailures  uint32
+	TargetFailures uint32
+	Outages        uint32
+
+	HandshakeFailures         uint32
+	BadCertificates           uint32
+	ClientCertificateFailures uint32
+
+	Failovers uint32
+	Refused   uint32
+
+	Truncations  uint32
+	Garbage      uint32
+	Duplications uint32
+
+	IdleTimeouts uint32
+	Expirations  uint32
+	EarlyCloses  uint32
+
+	// Tarpits are connections held open by Config.Tarpit.
+	Tarpits uint32
+
+	// ByteLimits are connections closed by Config.MaxBytesPerConnection.
+	ByteLimits uint32
+
+	// FailedRequests are HTTP requests failed by Proxy.FailNextRequests.
+	FailedRequests uint32
+
+	// ReorderedResponses are windows of responses Config.ReorderResponses sent
+	// out of order.
+	ReorderedResponses uint32
+
+	// PoolDials are connections Config.TargetPool opened to targets and
+	// PoolReuses are requests sent over a connection which was already open.
+	PoolDials  uint32
+	PoolReuses uint32
+
+	// StormConnections are the extra connections Config.ConnectionStorm opened to
+	// targets. They aren't counted as failures or in Connections.
+	StormConnections uint32
+
+	DroppedDatagrams    uint32
+	DuplicatedDatagrams uint32
+	ReorderedDatagrams  uint32
+
+	// Hijacks are connections sent to Config.HijackTarget instead of their target.
+	Hijacks uint32
+
+	// OfflineResponses are recorded responses served by Config.OfflineFallback
+	// while the target was unreachable.
+	OfflineResponses uint32
+
+	// InjectedDelay holds how long each Read, Write or new connection was held up
+	// by latency, bandwidth limits, trickling and ConnectDelay. Operations which
+	// weren't delayed aren't included.
+	InjectedDelay Histogram
+
+	// TargetDial holds how long each successful connection to a target took.
+	TargetDial Histogram
+}
+
+// Failures is the total of every injected failure and failure to connect with the target.
+// Failovers are not included since the connection is still served.
+func (s Stats) Failures() uint32 {
+	return s.ReadFailures + s.WriteFailures + s.TargetFailures + s.Outages +
+		s.HandshakeFailures + s.BadCertificates + s.ClientCertificateFailures +
+		s.Refused + s.Truncations + s.Garbage + s.Duplications +
+		s.IdleTimeouts + s.Expirations + s.EarlyCloses + s.Tarpits + s.ByteLimits + s.FailedRequests + s.ReorderedResponses +
+		s.DroppedDatagrams + s.DuplicatedDatagrams + s.ReorderedDatagrams +
+		s.Hijacks
+}
+
+// Stats returns a snapshot of the current counters.
+func (s *stats) Stats() Stats {
+	return Stats{
+		Connections:               s.connectionCount.Load(),
+		ReadBytes:                 s.readBytes.Load(),
+		WriteBytes:                s.writeBytes.Load(),
+		ReadFailures:              s.readFailures.Load(),
+		WriteFailures:             s.writeFailures.Load(),
+		TargetFailures:            s.targetFailures.Load(),
+		Outages:                   s.outages.Load(),
+		HandshakeFailures:         s.handshakeFails.Load(),
+		BadCertificates:           s.badCertificates.Load(),
+		ClientCertificateFailures: s.clientCertFails.Load(),
+		Failovers:                 s.failovers.Load(),
+		Refused:                   s.refused.Load(),
+		Truncations:               s.truncations.Load(),
+		Garbage:                   s.garbage.Load(),
+		Duplications:              s.duplications.Load(),
+		IdleTimeouts:              s.idleTimeouts.Load(),
+		Expirations:               s.expirations.Load(),
+		EarlyCloses:               s.earlyCloses.Load(),
+		Tarpits:                   s.tarpits.Load(),
+		ByteLimits:                s.byteLimits.Load(),
+		FailedRequests:            s.failedRequests.Load(),
+		ReorderedResponses:        s.reorderedResponses.Load(),
+		PoolDials:                 s.poolDials.Load(),
+		PoolReuses:                s.poolReuses.Load(),
+		StormConnections:          s.stormConnections.Load(),
+		DroppedDatagrams:          s.droppedDatagrams.Load(),
+		DuplicatedDatagrams:       s.duplicatedDatagrams.Load(),
+		ReorderedDatagrams:        s.reorderedDatagrams.Load(),
+		Hijacks:                   s.hijacks.Load(),
+		OfflineResponses:          s.offlineResponses.Load(),
+		InjectedDelay:             s.injectedDelay.snapshot(),
+		TargetDial:                s.targetDial.snapshot(),
+	}
+}
+
+// add returns the sum of s and o, such as to total the stats of several proxies.
+func (s Stats) add(o Stats) Stats {
+	return Stats{
+		Connections:               s.Connections + o.Connections,
+		ReadBytes:                 s.ReadBytes + o.ReadBytes,
+		WriteBytes:                s.WriteBytes + o.WriteBytes,
+		ReadFailures:              s.ReadFailures + o.ReadFailures,
+		WriteFailures:             s.WriteFailures + o.WriteFailures,
+		TargetFailures:            s.TargetFailures + o.TargetFailures,
+		Outages:                   s.Outages + o.Outages,
+		HandshakeFailures:         s.HandshakeFailures + o.HandshakeFailures,
+		BadCertificates:           s.BadCertificates + o.BadCertificates,
+		ClientCertificateFailures: s.ClientCertificateFailures + o.ClientCertificateFailures,
+		Failovers:                 s.Failovers + o.Failovers,
+		Refused:                   s.Refused + o.Refused,
+		Truncations:               s.Truncations + o.Truncations,
+		Garbage:                   s.Garbage + o.Garbage,
+		Duplications:              s.Duplications + o.Duplications,
+		IdleTimeouts:              s.IdleTimeouts + o.IdleTimeouts,
+		Expirations:               s.Expirations + o.Expirations,
+		EarlyCloses:               s.EarlyCloses + o.EarlyCloses,
+		Tarpits:                   s.Tarpits + o.Tarpits,
+		ByteLimits:                s.ByteLimits + o.ByteLimits,
+		FailedRequests:            s.FailedRequests + o.FailedRequests,
+		ReorderedResponses:        s.ReorderedResponses + o.ReorderedResponses,
+		PoolDials:                 s.PoolDials + o.PoolDials,
+		PoolReuses:                s.PoolReuses + o.PoolReuses,
+		StormConnections:          s.StormConnections + o.StormConnections,
+		DroppedDatagrams:          s.DroppedDatagrams + o.DroppedDatagrams,
+		DuplicatedDatagrams:       s.DuplicatedDatagrams + o.DuplicatedDatagrams,
+		ReorderedDatagrams:        s.ReorderedDatagrams + o.ReorderedDatagrams,
+		Hijacks:                   s.Hijacks + o.Hijacks,
+		OfflineResponses:          s.OfflineResponses + o.OfflineResponses,
+		InjectedDelay:             s.InjectedDelay.add(o.InjectedDelay),
+		TargetDial:                s.TargetDial.add(o.TargetDial),
+	}
+}
@@ -0,0 +1,108 @@
+package badnet
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+)
+
+// ConnectionStorm opens extra connections to the target alongside some client
+// connections, like buggy clients or middleboxes which amplify connection load,
+// to test a server's accept limits and rate limiters through the proxy.
+type ConnectionStorm struct {
+	// Ratio is the percentage of client connections which are duplicated.
+	Ratio int
+
+	// Count is how many extra connections are opened for each one, defaulting
+	// to 1. Everything the client sends is also sent over the extras, while their
+	// responses are discarded.
+	Count int
+}
+
+const defaultStormCount = 1
+
+func (s ConnectionStorm) extras() int {
+	if s.Ratio <= 0 || !shouldFail(s.Ratio) {
+		return 0
+	}
+	return max(s.Count, defaultStormCount)
+}
+
+// storm dials the extra connections for a client connection and returns target
+// wrapped to copy its writes to them. Extras which can't be dialed are skipped.
+func (p *Proxy) storm(ctx context.Context, target net.Conn, rt route) net.Conn {
+	n := p.conf.ConnectionStorm.extras()
+	if n == 0 {
+		return target
+	}
+
+	mc := &mirrorConn{Conn: target}
+	for i := 0; i < n; i++ {
+		extra, err := p.dialer.dial(ctx, rt.target, rt.targetTLS)
+		if err != nil {
+			continue
+		}
+		if !p.teardown.track(extra) {
+			break
+		}
+		p.stormConnected()
+		mc.extras = append(mc.extras, extra)
+
+		p.teardown.goroutine(func() {
+			defer p.teardown.untrack(extra)
+			io.Copy(io.Discard, extra)
+		})
+	}
+	return mc
+}
+
+// mirrorConn writes to each of its extras as well as the connection.
+type mirrorConn struct {
+	net.Conn
+
+	mu     sync.Mutex
+	extras []net.Conn
+}
+
+// NetConn returns the underlying connection.
+func (c *mirrorConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *mirrorConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	live := c.extras[:0]
+	for _, extra := range c.extras {
+		if _, err := extra.Write(b); err == nil {
+			live = append(live, extra)
+		} else {
+			extra.Close()
+		}
+	}
+	c.extras = live
+	c.mu.Unlock()
+
+	return c.Conn.Write(b)
+}
+
+// CloseWrite half-closes the connection and every extra.
+func (c *mirrorConn) CloseWrite() error {
+	c.mu.Lock()
+	for _, extra := range c.extras {
+		closeWrite(extra)
+	}
+	c.mu.Unlock()
+
+	return closeWrite(c.Conn)
+}
+
+func (c *mirrorConn) Close() error {
+	c.mu.Lock()
+	for _, extra := range c.extras {
+		extra.Close()
+	}
+	c.mu.Unlock()
+
+	return c.Conn.Close()
+}
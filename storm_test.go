@@ -0,0 +1,65 @@
+package badnet
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectionStorm(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	// Record what each connection to the target sends, echoing it back
+	var mu sync.Mutex
+	var received []string
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				data, _ := io.ReadAll(io.TeeReader(conn, conn))
+				mu.Lock()
+				received = append(received, string(data))
+				mu.Unlock()
+			}()
+		}
+	}()
+
+	proxy := ForTest(t, Config{
+		Listen:          "127.0.0.1:0",
+		Target:          ln.Addr().String(),
+		ConnectionStorm: ConnectionStorm{Ratio: 100, Count: 3},
+	})
+
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+	// Only the real connection's response comes back
+	got, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(got))
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 4
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"ping", "ping", "ping", "ping"}, received)
+	require.Equal(t, uint32(3), proxy.Stats().StormConnections)
+	require.Equal(t, uint32(1), proxy.Stats().Connections)
+}
@@ -0,0 +1,22 @@
+package badnet
+
+// SwapTarget sends new connections to addr instead of Target or Targets, while
+// connections which are already open stay with the backend they were made to, like
+// a blue/green deployment or rolling restart. Clients holding on to those connections
+// keep talking to the old backend until they close them.
+//
+// addr is written like Target and TargetOptions for the old targets no longer
+// apply. SNIRoutes with their own Target, SOCKS5 and Transparent connections aren't
+// affected.
+func (p *Proxy) SwapTarget(addr string) {
+	p.swapped.Store(&addr)
+}
+
+// swappedTarget returns the address given to SwapTarget, or empty if it hasn't been
+// called.
+func (p *Proxy) swappedTarget() string {
+	if addr := p.swapped.Load(); addr != nil {
+		return targetAddress(*addr, p.conf.TargetTLS != nil)
+	}
+	return ""
+}
@@ -0,0 +1,37 @@
+package badnet
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwapTarget(t *testing.T) {
+	blue, green := echoServer(t), echoServer(t)
+	proxy := ForTest(t, Config{
+		Listen: "127.0.0.1:0",
+		Target: blue,
+	})
+
+	before, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer before.Close()
+	echoOnce(t, before, "one")
+
+	proxy.SwapTarget(green)
+	require.Equal(t, green, proxy.EffectiveConfig().Target)
+
+	after, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer after.Close()
+	echoOnce(t, after, "two")
+
+	// The connection made before the swap still works
+	echoOnce(t, before, "three")
+
+	conns := proxy.Connections()
+	require.Len(t, conns, 2)
+	require.Equal(t, blue, conns[0].Target)
+	require.Equal(t, green, conns[1].Target)
+}
@@ -0,0 +1,112 @@
+package badnet
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Sweep is a matrix of faults to run a test under with SweepForTest, made from
+// ladders of latency, loss and bandwidth. Every combination of steps is run, and
+// an empty ladder keeps the base Config's setting.
+type Sweep struct {
+	// Latency is added to each direction.
+	Latency []time.Duration
+
+	// Loss is the FailureProbability of reads and writes in each direction.
+	Loss []float64
+
+	// Bandwidth limits each direction.
+	Bandwidth []Rate
+}
+
+// SweepResult is how a test fared under one combination of a Sweep.
+type SweepResult struct {
+	Latency   time.Duration
+	Loss      float64
+	Bandwidth Rate
+
+	Failed bool
+	Stats  Stats
+}
+
+func (r SweepResult) String() string {
+	return fmt.Sprintf("latency=%v,loss=%s,bandwidth=%v", r.Latency, strconv.FormatFloat(r.Loss, 'f', -1, 64), r.Bandwidth)
+}
+
+// combinations returns every combination of the sweep's ladders, using zero for
+// empty ones.
+func (s Sweep) combinations() []SweepResult {
+	latencies := s.Latency
+	if len(latencies) == 0 {
+		latencies = []time.Duration{0}
+	}
+	losses := s.Loss
+	if len(losses) == 0 {
+		losses = []float64{0}
+	}
+	bandwidths := s.Bandwidth
+	if len(bandwidths) == 0 {
+		bandwidths = []Rate{0}
+	}
+
+	var out []SweepResult
+	for _, latency := range latencies {
+		for _, loss := range losses {
+			for _, bandwidth := range bandwidths {
+				out = append(out, SweepResult{Latency: latency, Loss: loss, Bandwidth: bandwidth})
+			}
+		}
+	}
+	return out
+}
+
+// apply sets the combination's faults on both of conf's directions, leaving those
+// the sweep doesn't vary alone.
+func (s Sweep) apply(conf Config, r SweepResult) Config {
+	for _, d := range []*Direction{&conf.Read, &conf.Write} {
+		if len(s.Latency) > 0 {
+			d.Latency = r.Latency
+		}
+		if len(s.Loss) > 0 {
+			d.FailureProbability = r.Loss
+		}
+		if len(s.Bandwidth) > 0 {
+			d.Bandwidth = r.Bandwidth
+		}
+	}
+	return conf
+}
+
+// SweepForTest runs test as a subtest for each combination of the sweep, with a
+// proxy started from base and that combination's faults, to find where a client
+// stops coping rather than guessing at a single configuration. The combinations
+// which failed are logged and every result is returned, in the order run.
+func SweepForTest(t *testing.T, base Config, sweep Sweep, test func(t *testing.T, proxy *Proxy)) []SweepResult {
+	t.Helper()
+
+	results := sweep.combinations()
+	for i := range results {
+		r := &results[i]
+		passed := t.Run(r.String(), func(t *testing.T) {
+			proxy := ForTest(t, sweep.apply(base, *r))
+			defer func() { r.Stats = proxy.Stats() }()
+
+			test(t, proxy)
+		})
+		r.Failed = !passed
+	}
+
+	var failed []string
+	for _, r := range results {
+		if r.Failed {
+			failed = append(failed, "  "+r.String())
+		}
+	}
+	if len(failed) > 0 {
+		t.Logf("badnet: %d of %d sweep combinations failed:\n%s", len(failed), len(results), strings.Join(failed, "\n"))
+	}
+	return results
+}
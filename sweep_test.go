@@ -0,0 +1,55 @@
+package badnet
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSweepCombinations(t *testing.T) {
+	sweep := Sweep{
+		Latency: []time.Duration{0, 50 * time.Millisecond},
+		Loss:    []float64{0, 0.01, 0.1},
+	}
+	combos := sweep.combinations()
+	require.Len(t, combos, 6)
+	require.Equal(t, "latency=0s,loss=0,bandwidth=0bit", combos[0].String())
+	require.Equal(t, "latency=50ms,loss=0.1,bandwidth=0bit", combos[5].String())
+
+	// Settings the sweep doesn't vary come from the base config
+	base := Config{Read: Direction{Bandwidth: Mbit}}
+	conf := sweep.apply(base, combos[5])
+	require.Equal(t, 50*time.Millisecond, conf.Write.Latency)
+	require.Equal(t, 0.1, conf.Read.FailureProbability)
+	require.Equal(t, Mbit, conf.Read.Bandwidth)
+}
+
+func TestSweepForTest(t *testing.T) {
+	base := Config{Listen: "127.0.0.1:0", Target: echoServer(t)}
+	sweep := Sweep{
+		Latency:   []time.Duration{0, 20 * time.Millisecond},
+		Bandwidth: []Rate{0, 10 * Mbit},
+	}
+
+	var ran []string
+	results := SweepForTest(t, base, sweep, func(t *testing.T, proxy *Proxy) {
+		ran = append(ran, t.Name())
+
+		conn, err := net.Dial("tcp", proxy.BindAddr())
+		require.NoError(t, err)
+		defer conn.Close()
+		echoOnce(t, conn, "ping")
+	})
+
+	require.Len(t, ran, 4)
+	require.Len(t, results, 4)
+	for _, r := range results {
+		require.False(t, r.Failed)
+		require.Equal(t, uint32(1), r.Stats.Connections)
+		if r.Latency > 0 {
+			require.Positive(t, r.Stats.InjectedDelay.Count)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package badnet
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+)
+
+// Tarpit holds some connections open without forwarding them, answering with a
+// few bytes at a time which never amount to a response. Reads keep succeeding, so
+// idle or per-read timeouts don't fire, and only a client's overall deadline for
+// the request ends the connection.
+type Tarpit struct {
+	// Ratio is the percentage of connections which are tarpitted.
+	Ratio int
+
+	// Bytes are written every Interval, defaulting to 1 byte every second.
+	Bytes    int
+	Interval time.Duration
+
+	// Data is repeated to the client, defaulting to spaces so line based protocols,
+	// including HTTP, never see the end of a response.
+	Data []byte
+}
+
+const (
+	defaultTarpitBytes    = 1
+	defaultTarpitInterval = time.Second
+	defaultTarpitData     = " "
+)
+
+func (t Tarpit) chosen() bool {
+	return t.Ratio > 0 && shouldFail(t.Ratio)
+}
+
+// dribbles returns the data written each Interval, cycling through Data.
+func (t Tarpit) dribbles() func() []byte {
+	data := t.Data
+	if len(data) == 0 {
+		data = []byte(defaultTarpitData)
+	}
+	size := max(t.Bytes, defaultTarpitBytes)
+
+	var offset int
+	return func() []byte {
+		out := make([]byte, size)
+		for i := range out {
+			out[i] = data[offset]
+			offset = (offset + 1) % len(data)
+		}
+		return out
+	}
+}
+
+// tarpit dribbles data to conn until it's closed by either side, the connection's
+// context is canceled or the proxy closes. Anything the client sends is discarded.
+func (p *Proxy) tarpit(ctx context.Context, conn net.Conn) {
+	p.tarpitted()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	p.teardown.goroutine(func() {
+		defer cancel()
+		io.Copy(io.Discard, conn)
+	})
+
+	interval := p.conf.Tarpit.Interval
+	if interval <= 0 {
+		interval = defaultTarpitInterval
+	}
+	next := p.conf.Tarpit.dribbles()
+	for {
+		select {
+		case <-p.conf.clock().After(interval):
+			if _, err := conn.Write(next()); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		case <-p.teardown.closing():
+			return
+		}
+	}
+}
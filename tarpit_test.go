@@ -0,0 +1,58 @@
+package badnet
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTarpitDribbles(t *testing.T) {
+	next := Tarpit{Bytes: 3, Data: []byte("ab")}.dribbles()
+	require.Equal(t, "aba", string(next()))
+	require.Equal(t, "bab", string(next()))
+
+	require.Equal(t, " ", string(Tarpit{}.dribbles()()))
+}
+
+func TestTarpit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("tarpitted request reached the server")
+	}))
+	t.Cleanup(server.Close)
+
+	proxy := ForHTTPTest(t, server, Config{
+		Tarpit: Tarpit{Ratio: 100, Interval: 10 * time.Millisecond},
+	})
+
+	t.Run("reads", func(t *testing.T) {
+		conn, err := net.Dial("tcp", proxy.BindAddr())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		// Each read succeeds well within its own deadline
+		buf := make([]byte, 1)
+		for i := 0; i < 5; i++ {
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, err := conn.Read(buf)
+			require.NoError(t, err)
+			require.Equal(t, " ", string(buf))
+		}
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, "GET", proxy.URL, nil)
+		require.NoError(t, err)
+		_, err = http.DefaultClient.Do(req)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	require.Equal(t, uint32(2), proxy.Stats().Tarpits)
+}
@@ -0,0 +1,107 @@
+package badnet
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// teardownTimeout is how long a closing Proxy waits for its goroutines to finish.
+var teardownTimeout = 5 * time.Second
+
+// teardown tracks the goroutines and connections of a Proxy so they can all be
+// stopped once the test completes.
+type teardown struct {
+	wg      sync.WaitGroup
+	running atomic.Int32
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	done  chan struct{}
+}
+
+func newTeardown() *teardown {
+	return &teardown{
+		conns: make(map[net.Conn]struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// goroutine runs fn in a new goroutine which close waits on.
+func (td *teardown) goroutine(fn func()) {
+	td.wg.Add(1)
+	td.running.Add(1)
+	go func() {
+		defer td.wg.Done()
+		defer td.running.Add(-1)
+		fn()
+	}()
+}
+
+// track records c as open so it's closed during teardown. When teardown has already
+// started c is closed immediately and false is returned.
+func (td *teardown) track(c net.Conn) bool {
+	td.mu.Lock()
+	defer td.mu.Unlock()
+
+	select {
+	case <-td.done:
+		c.Close()
+		return false
+	default:
+	}
+	td.conns[c] = struct{}{}
+	return true
+}
+
+func (td *teardown) untrack(c net.Conn) {
+	td.mu.Lock()
+	defer td.mu.Unlock()
+
+	delete(td.conns, c)
+}
+
+// open returns the connections currently tracked.
+func (td *teardown) open() []net.Conn {
+	td.mu.Lock()
+	defer td.mu.Unlock()
+
+	conns := make([]net.Conn, 0, len(td.conns))
+	for c := range td.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// closing is done once teardown has started.
+func (td *teardown) closing() <-chan struct{} {
+	return td.done
+}
+
+// close closes every open connection and waits up to timeout for the goroutines to
+// finish. It returns how many goroutines are still running.
+func (td *teardown) close(timeout time.Duration) int32 {
+	td.mu.Lock()
+	close(td.done)
+	for c := range td.conns {
+		c.Close()
+	}
+	td.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		td.wg.Wait()
+		close(finished)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-finished:
+		return 0
+	case <-timer.C:
+		return td.running.Load()
+	}
+}
@@ -0,0 +1,226 @@
+package badnet
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// cleanupT runs cleanups when asked and records errors instead of failing the test
+type cleanupT struct {
+	testing.TB
+	cleanups []func()
+	errors   []string
+}
+
+func (t *cleanupT) Helper() {}
+
+func (t *cleanupT) Cleanup(fn func()) {
+	t.cleanups = append(t.cleanups, fn)
+}
+
+func (t *cleanupT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func (t *cleanupT) finish() {
+	for i := len(t.cleanups) - 1; i >= 0; i-- {
+		t.cleanups[i]()
+	}
+}
+
+func TestTeardown(t *testing.T) {
+	rec := &cleanupT{TB: t}
+	proxy := ForTest(rec, Config{
+		Listen:        "127.0.0.1:0",
+		Target:        echoServer(t),
+		VerifyNoLeaks: true,
+	})
+
+	// Leave a connection open with data flowing in both directions
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadFull(conn, make([]byte, 5))
+	require.NoError(t, err)
+
+	rec.finish()
+	require.Empty(t, rec.errors)
+	require.Equal(t, int32(0), proxy.teardown.running.Load())
+
+	// The client sees the connection close
+	_, err = conn.Read(make([]byte, 1))
+	require.Error(t, err)
+}
+
+func TestTeardownDuringLatency(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	rec := &cleanupT{TB: t}
+	proxy := ForTest(rec, Config{
+		Listen:        "127.0.0.1:0",
+		Target:        echoServer(t),
+		Clock:         clock,
+		Read:          Direction{Latency: 20 * time.Second},
+		VerifyNoLeaks: true,
+	})
+
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Wait for the proxy to be holding the data back
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return clock.Waiters() > 0 }, 5*time.Second, time.Millisecond)
+
+	start := time.Now()
+	rec.finish()
+	require.Less(t, time.Since(start), time.Second)
+	require.Empty(t, rec.errors)
+	require.Equal(t, int32(0), proxy.teardown.running.Load())
+}
+
+type stuckConn struct {
+	net.Conn
+	reading chan struct{}
+	block   chan struct{}
+}
+
+func (c *stuckConn) Read(b []byte) (int, error) {
+	close(c.reading)
+	<-c.block
+	return 0, io.EOF
+}
+
+func TestVerifyNoLeaks(t *testing.T) {
+	timeout := teardownTimeout
+	teardownTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { teardownTimeout = timeout })
+
+	reading, block := make(chan struct{}), make(chan struct{})
+	defer close(block)
+
+	rec := &cleanupT{TB: t}
+	proxy := ForTest(rec, Config{
+		Listen:        "127.0.0.1:0",
+		Target:        echoServer(t),
+		VerifyNoLeaks: true,
+		Toxics: []Toxic{
+			ToxicFunc(func(c net.Conn, _ ToxicInfo) net.Conn {
+				return &stuckConn{Conn: c, reading: reading, block: block}
+			}),
+		},
+	})
+
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	select {
+	case <-reading:
+	case <-time.After(5 * time.Second):
+		t.Fatal("proxy never read from the client")
+	}
+
+	rec.finish()
+	require.Len(t, rec.errors, 1)
+	require.Contains(t, rec.errors[0], "goroutines still running")
+}
+
+// echoOnce sends msg through conn and checks it comes back.
+func echoOnce(t *testing.T, conn net.Conn, msg string) {
+	t.Helper()
+
+	_, err := conn.Write([]byte(msg))
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, len(msg))
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, msg, string(buf))
+}
+
+func TestContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	toxicContexts := make(chan context.Context, 1)
+	proxy := ForTest(t, Config{
+		Listen:  "127.0.0.1:0",
+		Target:  echoServer(t),
+		Context: ctx,
+		Toxics: []Toxic{
+			ToxicFunc(func(c net.Conn, info ToxicInfo) net.Conn {
+				toxicContexts <- info.Context
+				return c
+			}),
+		},
+	})
+
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+	echoOnce(t, conn, "hello")
+
+	// Canceling the proxy's context closes open connections and stops new ones
+	cancel()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+
+	select {
+	case toxicCtx := <-toxicContexts:
+		require.ErrorIs(t, toxicCtx.Err(), context.Canceled)
+	default:
+		t.Fatal("toxic wasn't applied")
+	}
+
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", proxy.BindAddr())
+		if err == nil {
+			conn.Close()
+		}
+		return err != nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestConnContext(t *testing.T) {
+	cancels := make(chan context.CancelFunc, 2)
+	proxy := ForTest(t, Config{
+		Listen: "127.0.0.1:0",
+		Target: echoServer(t),
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			ctx, cancel := context.WithCancel(ctx)
+			cancels <- cancel
+			return ctx
+		},
+	})
+
+	first, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer first.Close()
+	echoOnce(t, first, "one")
+	cancelFirst := <-cancels
+
+	second, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer second.Close()
+	echoOnce(t, second, "two")
+
+	// Only the canceled connection is closed
+	cancelFirst()
+	first.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = first.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+
+	echoOnce(t, second, "still open")
+}
@@ -0,0 +1,317 @@
+package badnet
+
+import (
+	"context"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// link holds the bandwidth and latency settings for one direction of traffic. Its
+// settings can be changed while connections are using it.
+type link struct {
+	mu      sync.Mutex
+	rate    float64 // bytes per second
+	burst   int
+	latency time.Duration
+	perKB   time.Duration
+
+	// ramp replaces rate while ramping and spikes add to latency, both timed
+	// from when the link started
+	ramp    Ramp
+	ramping bool
+	spikes  Spikes
+	start   time.Time
+	clock   Clock
+
+	// shared is the bucket used by every connection when ShareBandwidth is set
+	shared *tokenBucket
+}
+
+func newLink(d Direction, clock Clock) *link {
+	l := &link{
+		rate:    d.rate(),
+		burst:   d.BandwidthBurst,
+		latency: d.Latency,
+		perKB:   d.LatencyPerKB,
+		spikes:  d.Spikes,
+		start:   clock.Now(),
+		clock:   clock,
+	}
+	if d.Ramp.enabled() {
+		l.ramp, l.ramping = d.Ramp, true
+	}
+	if d.ShareBandwidth {
+		l.shared = &tokenBucket{}
+	}
+	return l
+}
+
+// rate returns the Direction's bandwidth limit in bytes per second, preferring
+// Bandwidth over MaxKBps.
+func (d Direction) rate() float64 {
+	if d.Bandwidth > 0 {
+		return d.Bandwidth.bytesPerSecond()
+	}
+	return float64(d.MaxKBps) * 1024
+}
+
+// settings returns the link's rate and burst in bytes along with its latency.
+func (l *link) settings() (rate, burst float64, latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rate = l.rate
+	if l.ramping {
+		rate = l.ramp.at(l.clock.Now().Sub(l.start))
+	}
+	burst = float64(l.burst)
+	if burst <= 0 {
+		burst = defaultBurst(rate)
+	}
+	if rate > 0 && burst < 1 {
+		burst = 1
+	}
+	return rate, burst, l.latency
+}
+
+// defaultBurst is the BandwidthBurst used at rate, 100ms worth of data.
+func defaultBurst(rate float64) float64 {
+	return rate / 10
+}
+
+func (l *link) setRate(bytesPerSecond float64) {
+	l.mu.Lock()
+	l.rate = bytesPerSecond
+	l.ramping = false
+	l.mu.Unlock()
+}
+
+func (l *link) setLatency(latency time.Duration) {
+	l.mu.Lock()
+	l.latency = latency
+	l.mu.Unlock()
+}
+
+// current returns d with the bandwidth and latency the link is using now, which
+// may have been changed since d configured it.
+func (l *link) current(d Direction) Direction {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	d.Latency = l.latency
+	if !l.ramping {
+		d.Ramp = Ramp{}
+		if l.rate != d.rate() {
+			// Whole kilobytes are reported as SetBandwidth sets them, and anything
+			// else in bits per second as SetRate does
+			d.MaxKBps, d.Bandwidth = 0, 0
+			if kb := l.rate / 1024; kb == math.Trunc(kb) {
+				d.MaxKBps = int(kb)
+			} else {
+				d.Bandwidth = Rate(l.rate * 8)
+			}
+		}
+	}
+	return d
+}
+
+// throttles are the links used by connections following the Config's Read and
+// Write directions.
+type throttles struct {
+	read, write *link
+}
+
+func newThrottles(conf Config) throttles {
+	return throttles{
+		read:  newLink(conf.Read, conf.clock()),
+		write: newLink(conf.Write, conf.clock()),
+	}
+}
+
+// SetBandwidth changes MaxKBps for the Config's Read and Write directions, including
+// on connections which are already open, and ends any Ramp. Zero removes the limit.
+func (t *throttles) SetBandwidth(readKBps, writeKBps int) {
+	t.read.setRate(float64(readKBps) * 1024)
+	t.write.setRate(float64(writeKBps) * 1024)
+}
+
+// SetRate changes the bandwidth for the Config's Read and Write directions in bits
+// per second, including on connections which are already open, and ends any Ramp.
+// Zero removes the limit.
+func (t *throttles) SetRate(read, write Rate) {
+	t.read.setRate(read.bytesPerSecond())
+	t.write.setRate(write.bytesPerSecond())
+}
+
+// SetLatency changes Latency for the Config's Read and Write directions, including
+// on connections which are already open.
+func (t *throttles) SetLatency(read, write time.Duration) {
+	t.read.setLatency(read)
+	t.write.setLatency(write)
+}
+
+// tokenBucket paces data to a rate in bytes per second. Callers may go into debt,
+// which later callers wait out, so the rate holds across every user of the bucket.
+type tokenBucket struct {
+	mu      sync.Mutex
+	started bool
+	tokens  float64
+	last    time.Time
+}
+
+// reserve takes n bytes from the bucket at the given rate, returning how long the
+// caller has to wait before they're allowed through.
+func (b *tokenBucket) reserve(clock Clock, n int, rate, burst float64) time.Duration {
+	if rate <= 0 {
+		return 0
+	}
+
+	b.mu.Lock()
+	now := clock.Now()
+	if b.started {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+	} else {
+		b.tokens, b.started = burst, true
+	}
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	deficit := -b.tokens
+	b.mu.Unlock()
+
+	if deficit <= 0 {
+		return 0
+	}
+	return time.Duration(deficit / rate * float64(time.Second))
+}
+
+// pacer applies a link's settings to one direction of a connection. Its waits end
+// early once ctx is done or closing is closed.
+type pacer struct {
+	link   *link
+	bucket *tokenBucket
+	clock  Clock
+
+	ctx     context.Context
+	closing <-chan struct{}
+}
+
+func newPacer(ctx context.Context, l *link, clock Clock, closing <-chan struct{}) pacer {
+	bucket := l.shared
+	if bucket == nil {
+		bucket = &tokenBucket{}
+	}
+	return pacer{link: l, bucket: bucket, clock: clock, ctx: ctx, closing: closing}
+}
+
+// maxChunk bounds how much data is moved at once when bandwidth is unlimited.
+const maxChunk = 32 * 1024
+
+// chunk returns the most data which should be moved before waiting.
+func (p pacer) chunk() int {
+	rate, burst, _ := p.link.settings()
+	if rate <= 0 || burst > maxChunk {
+		return maxChunk
+	}
+	return int(burst)
+}
+
+// wait blocks until n bytes are allowed through, returning how long that was.
+func (p pacer) wait(n int) (time.Duration, error) {
+	rate, burst, _ := p.link.settings()
+	d := p.bucket.reserve(p.clock, n, rate, burst)
+	return d, sleep(p.ctx, p.clock, d, p.closing)
+}
+
+// delay waits out the link's latency and any spike for moving n bytes, returning
+// how long that was.
+func (p pacer) delay(n int) (time.Duration, error) {
+	p.link.mu.Lock()
+	latency := p.link.latency + time.Duration(float64(p.link.perKB)*float64(n)/1024)
+	latency += p.link.spikes.delay(p.clock.Now().Sub(p.link.start))
+	p.link.mu.Unlock()
+
+	return latency, sleep(p.ctx, p.clock, latency, p.closing)
+}
+
+// throttledConn applies bandwidth limits and latency to an established connection.
+// The time each Read or Write is held up for is reported to observe.
+type throttledConn struct {
+	net.Conn
+
+	read, write pacer
+	observe     func(time.Duration)
+}
+
+// NetConn returns the underlying connection.
+func (c *throttledConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func throttleConn(ctx context.Context, c net.Conn, read, write *link, clock Clock, closing <-chan struct{}, observe func(time.Duration)) net.Conn {
+	return &throttledConn{
+		Conn:    c,
+		read:    newPacer(ctx, read, clock, closing),
+		write:   newPacer(ctx, write, clock, closing),
+		observe: observe,
+	}
+}
+
+func (c *throttledConn) Read(b []byte) (int, error) {
+	if max := c.read.chunk(); len(b) > max {
+		b = b[:max]
+	}
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		latency, werr := c.read.delay(n)
+		if werr == nil {
+			var paced time.Duration
+			paced, werr = c.read.wait(n)
+			latency += paced
+		}
+		c.delayed(latency)
+		if werr != nil {
+			return 0, werr
+		}
+	}
+	return n, err
+}
+
+func (c *throttledConn) Write(b []byte) (int, error) {
+	waited, err := c.write.delay(len(b))
+	defer func() { c.delayed(waited) }()
+	if err != nil {
+		return 0, err
+	}
+
+	var written int
+	for len(b) > 0 {
+		chunk := b
+		if max := c.write.chunk(); len(chunk) > max {
+			chunk = chunk[:max]
+		}
+		paced, err := c.write.wait(len(chunk))
+		waited += paced
+		if err != nil {
+			return written, err
+		}
+
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+func (c *throttledConn) delayed(d time.Duration) {
+	if c.observe != nil && d > 0 {
+		c.observe(d)
+	}
+}
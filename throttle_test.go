@@ -0,0 +1,123 @@
+package badnet
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSharedBandwidth(t *testing.T) {
+	dialer := DialerForTest(t, Config{
+		Target: echoServer(t),
+		Write:  Direction{MaxKBps: 10, ShareBandwidth: true},
+	})
+
+	// Two connections sending 4KB each at a shared 10KB/s take twice as long as one
+	start := time.Now()
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		conn, err := dialer.Dial(context.Background())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := conn.Write(make([]byte, 4096))
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	require.GreaterOrEqual(t, time.Since(start), 600*time.Millisecond)
+}
+
+func TestTokenBucket(t *testing.T) {
+	var bucket tokenBucket
+
+	clock := NewFakeClock(time.Now())
+	require.Zero(t, bucket.reserve(clock, 100, 1000, 100)) // the initial burst
+	require.Equal(t, 500*time.Millisecond, bucket.reserve(clock, 500, 1000, 100))
+
+	// Waiting pays off the debt
+	clock.Advance(500 * time.Millisecond)
+	require.Equal(t, 100*time.Millisecond, bucket.reserve(clock, 100, 1000, 100))
+
+	// Unlimited rates never wait
+	require.Zero(t, bucket.reserve(clock, 1<<20, 0, 0))
+}
+
+func TestThrottleChanges(t *testing.T) {
+	dialer := DialerForTest(t, Config{
+		Target: echoServer(t),
+		Write:  Direction{MaxKBps: 1},
+	})
+	conn, err := dialer.Dial(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// 2KB at 1KB/s takes a couple seconds, but lifting the limit speeds it up
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		dialer.SetBandwidth(0, 0)
+	}()
+	start := time.Now()
+	_, err = conn.Write(make([]byte, 2048))
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), time.Second)
+
+	dialer.SetLatency(0, 100*time.Millisecond)
+	start = time.Now()
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestLatency(t *testing.T) {
+	proxy := ForTest(t, Config{
+		Listen: "127.0.0.1:0",
+		Target: echoServer(t),
+		Read:   Direction{Latency: 50 * time.Millisecond},
+		Write:  Direction{Latency: 50 * time.Millisecond, BandwidthBurst: 512},
+	})
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	start := time.Now()
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestLatencyPerKB(t *testing.T) {
+	dialer := DialerForTest(t, Config{
+		Target: echoServer(t),
+		Write:  Direction{LatencyPerKB: 10 * time.Millisecond},
+	})
+	conn, err := dialer.Dial(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	start := time.Now()
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), 50*time.Millisecond)
+
+	start = time.Now()
+	_, err = conn.Write(make([]byte, 10*1024))
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+}
@@ -0,0 +1,132 @@
+package badnet
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// sampleResolution is the smallest window throughput is recorded over.
+const sampleResolution = 100 * time.Millisecond
+
+// Sample is the throughput of a proxy over one window of time.
+type Sample struct {
+	// Start is when the window began and Duration is how long it covers. The
+	// latest window may be shorter than the others if it's still in progress.
+	Start    time.Time
+	Duration time.Duration
+
+	// Read is the bytes per second forwarded from clients to targets, and Write is
+	// the bytes per second forwarded from targets back to clients.
+	Read  float64
+	Write float64
+}
+
+// Throughput returns the bytes per second forwarded in each direction since the
+// proxy started, split into windows of the given length. Windows are rounded up to
+// a multiple of 100ms.
+//
+// Data is recorded as it's written, except for spliced connections, those without
+// any faults or throttling and with Config.PreserveHost set, which record each
+// direction once it finishes.
+func (p *Proxy) Throughput(window time.Duration) []Sample {
+	return p.throughput.samples(window, time.Now())
+}
+
+// forwardedRead records n bytes forwarded from a client to a target.
+func (p *Proxy) forwardedRead(n int) {
+	p.readBytes.Add(uint64(n))
+	p.throughput.recordRead(n)
+}
+
+// forwardedWrite records n bytes forwarded from a target back to a client.
+func (p *Proxy) forwardedWrite(n int) {
+	p.writeBytes.Add(uint64(n))
+	p.throughput.recordWrite(n)
+}
+
+// throughput records bytes forwarded in each direction within buckets of sampleResolution.
+type throughput struct {
+	mu      sync.Mutex
+	start   time.Time
+	buckets []bucketBytes
+}
+
+type bucketBytes struct {
+	read, write int64
+}
+
+func newThroughput() *throughput {
+	return &throughput{start: time.Now()}
+}
+
+func (tp *throughput) recordRead(n int) {
+	tp.record(n, func(b *bucketBytes) { b.read += int64(n) })
+}
+
+func (tp *throughput) recordWrite(n int) {
+	tp.record(n, func(b *bucketBytes) { b.write += int64(n) })
+}
+
+func (tp *throughput) record(n int, add func(*bucketBytes)) {
+	if n <= 0 {
+		return
+	}
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	idx := int(time.Since(tp.start) / sampleResolution)
+	for len(tp.buckets) <= idx {
+		tp.buckets = append(tp.buckets, bucketBytes{})
+	}
+	add(&tp.buckets[idx])
+}
+
+func (tp *throughput) samples(window time.Duration, now time.Time) []Sample {
+	per := int((window + sampleResolution - 1) / sampleResolution)
+	if per < 1 {
+		per = 1
+	}
+	window = time.Duration(per) * sampleResolution
+
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	elapsed := now.Sub(tp.start)
+	var out []Sample
+	for offset := time.Duration(0); offset < elapsed; offset += window {
+		sample := Sample{
+			Start:    tp.start.Add(offset),
+			Duration: window,
+		}
+		if remaining := elapsed - offset; remaining < window {
+			sample.Duration = remaining
+		}
+
+		first := int(offset / sampleResolution)
+		var total bucketBytes
+		for i := first; i < first+per && i < len(tp.buckets); i++ {
+			total.read += tp.buckets[i].read
+			total.write += tp.buckets[i].write
+		}
+		secs := sample.Duration.Seconds()
+		sample.Read = float64(total.read) / secs
+		sample.Write = float64(total.write) / secs
+
+		out = append(out, sample)
+	}
+	return out
+}
+
+// countingWriter reports how many bytes are written through it. It also hides any
+// ReadFrom method of the underlying Writer.
+type countingWriter struct {
+	w     io.Writer
+	count func(n int)
+}
+
+func (cw countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.count(n)
+	return n, err
+}
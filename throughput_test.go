@@ -0,0 +1,101 @@
+package badnet
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestThroughputSamples(t *testing.T) {
+	start := time.Now()
+	tp := &throughput{
+		start: start,
+		buckets: []bucketBytes{
+			{read: 100, write: 10},
+			{read: 100},
+			{},
+			{read: 50, write: 20},
+		},
+	}
+
+	samples := tp.samples(200*time.Millisecond, start.Add(450*time.Millisecond))
+	require.Len(t, samples, 3)
+
+	require.Equal(t, start, samples[0].Start)
+	require.Equal(t, 200*time.Millisecond, samples[0].Duration)
+	require.InDelta(t, 1000.0, samples[0].Read, 0.01)
+	require.InDelta(t, 50.0, samples[0].Write, 0.01)
+
+	require.InDelta(t, 250.0, samples[1].Read, 0.01)
+	require.InDelta(t, 100.0, samples[1].Write, 0.01)
+
+	// The latest window is partial
+	require.Equal(t, 50*time.Millisecond, samples[2].Duration)
+	require.Zero(t, samples[2].Read)
+
+	// Windows are rounded up to the resolution
+	samples = tp.samples(time.Millisecond, start.Add(450*time.Millisecond))
+	require.Len(t, samples, 5)
+	require.Equal(t, sampleResolution, samples[0].Duration)
+}
+
+func TestThroughput(t *testing.T) {
+	proxy := ForTest(t, Config{
+		Listen: "127.0.0.1:0",
+		Target: echoServer(t),
+	})
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	payload := make([]byte, 100*1024)
+	go conn.Write(payload)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadFull(conn, make([]byte, len(payload)))
+	require.NoError(t, err)
+
+	// The last write back to the client is recorded once it returns
+	require.Eventually(t, func() bool {
+		var read, write float64
+		for _, sample := range proxy.Throughput(time.Second) {
+			read += sample.Read * sample.Duration.Seconds()
+			write += sample.Write * sample.Duration.Seconds()
+		}
+		expected := float64(len(payload))
+		return read > expected-1 && read < expected+1 && write > expected-1 && write < expected+1
+	}, time.Second, 10*time.Millisecond)
+
+	stats := proxy.Stats()
+	require.Equal(t, uint64(len(payload)), stats.ReadBytes)
+	require.Equal(t, uint64(len(payload)), stats.WriteBytes)
+}
+
+func TestBytesForwardedTruncated(t *testing.T) {
+	proxy := ForTest(t, Config{
+		Listen: "127.0.0.1:0",
+		Target: echoServer(t),
+		Read: Direction{
+			TruncateRatio: 100,
+		},
+	})
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+
+	_, err = conn.Write(make([]byte, 100))
+	require.NoError(t, err)
+	conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, proxy.WaitIdle(ctx))
+
+	// Some of the data was silently dropped
+	stats := proxy.Stats()
+	require.Greater(t, stats.ReadBytes, uint64(0))
+	require.Less(t, stats.ReadBytes, uint64(100))
+}
@@ -0,0 +1,316 @@
+package badnet
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+)
+
+// TLSHandshakeFaults stall or abort TLS handshakes. They apply when the proxy
+// terminates TLS (Config.ListenTLS) and when clients negotiate TLS with the target
+// through the proxy.
+type TLSHandshakeFaults struct {
+	// FailureRatio is the percentage of TLS handshakes which are aborted by closing
+	// the connection after the ClientHello is received.
+	FailureRatio int
+
+	// StallRatio is the percentage of TLS handshakes delayed by StallDuration before
+	// the ClientHello is processed.
+	StallRatio    int
+	StallDuration time.Duration
+}
+
+func (f TLSHandshakeFaults) enabled() bool {
+	return f.FailureRatio > 0 || f.StallRatio > 0
+}
+
+// BadCertificates deliberately presents invalid certificates on a percentage of
+// connections when Config.ListenTLS is enabled.
+type BadCertificates struct {
+	// ExpiredRatio presents a certificate which is no longer valid.
+	ExpiredRatio int
+
+	// WrongHostRatio presents a certificate issued for another hostname.
+	WrongHostRatio int
+
+	// UntrustedRatio presents a self-signed certificate not issued by the proxy's CA.
+	UntrustedRatio int
+}
+
+func (b BadCertificates) enabled() bool {
+	return b.ExpiredRatio > 0 || b.WrongHostRatio > 0 || b.UntrustedRatio > 0
+}
+
+// ClientCertificates enables mutual TLS when Config.ListenTLS is enabled.
+type ClientCertificates struct {
+	// Require makes the proxy demand and verify a certificate from every client.
+	// Proxy.ClientTLSConfig() includes a certificate signed by the proxy's CA.
+	Require bool
+
+	// ClientCAs verifies client certificates. The proxy's CA is used when nil.
+	ClientCAs *x509.CertPool
+
+	// Verify is called after a client certificate chain has been verified and can
+	// reject it by returning an error.
+	Verify func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
+
+	// RejectRatio is the percentage of valid client certificates rejected anyway.
+	RejectRatio int
+}
+
+var (
+	errHandshakeAborted      = errors.New("badnet: TLS handshake aborted")
+	errClientCertificateFail = errors.New("badnet: client certificate rejected")
+)
+
+// handshakeConn applies TLSHandshakeFaults to the first read of a connection if it
+// contains a TLS handshake record.
+type handshakeConn struct {
+	net.Conn
+
+	ctx      context.Context
+	clock    Clock
+	faults   TLSHandshakeFaults
+	onFailed func()
+
+	once sync.Once
+}
+
+// NetConn returns the underlying connection.
+func (c *handshakeConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *handshakeConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+
+	var failed bool
+	c.once.Do(func() {
+		// TLS records begin with the content type (22 for handshakes) and major version 3
+		if n < 2 || b[0] != 0x16 || b[1] != 0x03 {
+			return
+		}
+		if shouldFail(c.faults.StallRatio) {
+			if serr := sleep(c.ctx, c.clock, c.faults.StallDuration, nil); serr != nil {
+				n, err = 0, serr
+				return
+			}
+		}
+		if shouldFail(c.faults.FailureRatio) {
+			failed = true
+			if c.onFailed != nil {
+				c.onFailed()
+			}
+		}
+	})
+	if failed {
+		return 0, errHandshakeAborted
+	}
+	return n, err
+}
+
+// testCertificates is a throwaway certificate authority and the leaf certificate
+// it signed for the proxy's listener.
+type testCertificates struct {
+	ca     *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	caPool *x509.CertPool
+
+	leaf tls.Certificate
+
+	// invalid certificates for BadCertificates
+	expired   tls.Certificate
+	wrongHost tls.Certificate
+	untrusted tls.Certificate
+}
+
+func newTestCertificates(hosts ...string) (*testCertificates, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating CA key: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: "badnet test CA"},
+		NotBefore:             now.Add(-1 * time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("creating CA certificate: %w", err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("parsing CA certificate: %w", err)
+	}
+
+	certs := &testCertificates{
+		ca:     ca,
+		caKey:  caKey,
+		caPool: x509.NewCertPool(),
+	}
+	certs.caPool.AddCert(ca)
+
+	certs.leaf, err = certs.issue(hosts, now.Add(-1*time.Hour), now.Add(24*time.Hour))
+	if err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
+
+// issue signs a leaf certificate for the hosts, which can be hostnames or IP addresses.
+func (c *testCertificates) issue(hosts []string, notBefore, notAfter time.Time) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("generating leaf key: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: "badnet"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if h != "" {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, c.ca, &key.PublicKey, c.caKey)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("creating leaf certificate: %w", err)
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}, nil
+}
+
+// addBadCertificates generates the invalid certificates presented for BadCertificates.
+func (c *testCertificates) addBadCertificates(hosts []string) error {
+	now := time.Now()
+
+	var err error
+	c.expired, err = c.issue(hosts, now.Add(-48*time.Hour), now.Add(-24*time.Hour))
+	if err != nil {
+		return fmt.Errorf("expired certificate: %w", err)
+	}
+	c.wrongHost, err = c.issue([]string{"wrong-host.badnet.invalid"}, now.Add(-1*time.Hour), now.Add(24*time.Hour))
+	if err != nil {
+		return fmt.Errorf("wrong host certificate: %w", err)
+	}
+
+	// An unrelated CA signs the untrusted certificate
+	other, err := newTestCertificates(hosts...)
+	if err != nil {
+		return fmt.Errorf("untrusted certificate: %w", err)
+	}
+	c.untrusted = other.leaf
+
+	return nil
+}
+
+func (c *testCertificates) serverConfig(conf Config, s *stats) *tls.Config {
+	out := &tls.Config{
+		Certificates: []tls.Certificate{c.leaf},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	bad := conf.BadCertificates
+	if bad.enabled() {
+		// GetCertificate is only called without SNI when Certificates is empty
+		out.Certificates = nil
+		out.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			var cert *tls.Certificate
+			switch {
+			case shouldFail(bad.ExpiredRatio):
+				cert = &c.expired
+			case shouldFail(bad.WrongHostRatio):
+				cert = &c.wrongHost
+			case shouldFail(bad.UntrustedRatio):
+				cert = &c.untrusted
+			default:
+				return &c.leaf, nil
+			}
+			s.badCertificatePresented()
+			return cert, nil
+		}
+	}
+
+	clients := conf.ClientCertificates
+	if clients.Require {
+		out.ClientAuth = tls.RequireAndVerifyClientCert
+		out.ClientCAs = clients.ClientCAs
+		if out.ClientCAs == nil {
+			out.ClientCAs = c.caPool
+		}
+		out.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			if clients.Verify != nil {
+				if err := clients.Verify(rawCerts, verifiedChains); err != nil {
+					return err
+				}
+			}
+			if shouldFail(clients.RejectRatio) {
+				s.clientCertificateRejected()
+				return errClientCertificateFail
+			}
+			return nil
+		}
+	}
+
+	return out
+}
+
+func randomSerial() *big.Int {
+	limit := new(big.Int).Lsh(big.NewInt(1), 128)
+	n, _ := rand.Int(rand.Reader, limit)
+	return n
+}
+
+// listenHosts returns the names a leaf certificate should cover for a listen address.
+func listenHosts(address string) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	host, _, _ := net.SplitHostPort(address)
+	if host != "" && host != "localhost" && host != "127.0.0.1" && host != "::1" {
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// ClientTLSConfig returns a tls.Config which trusts the certificate presented by the
+// proxy when Config.ListenTLS is enabled. It returns nil otherwise.
+//
+// When client certificates are required the config presents one signed by the proxy's CA.
+func (p *Proxy) ClientTLSConfig() *tls.Config {
+	if p.certs == nil {
+		return nil
+	}
+	conf := &tls.Config{
+		RootCAs:    p.certs.caPool,
+		MinVersion: tls.VersionTLS12,
+	}
+	if p.conf.ClientCertificates.Require {
+		conf.Certificates = []tls.Certificate{p.certs.leaf}
+	}
+	return conf
+}
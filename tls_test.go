@@ -0,0 +1,199 @@
+package badnet
+
+import (
+	"context"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenTLS(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("PONG"))
+	}))
+	t.Cleanup(server.Close)
+
+	proxy := ForTest(t, Config{
+		Listen:    "127.0.0.1:0",
+		Target:    server.URL,
+		ListenTLS: true,
+	})
+
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: proxy.ClientTLSConfig()},
+	}
+	resp, err := client.Get("https://" + proxy.BindAddr())
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	bs, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "PONG", string(bs))
+
+	// Clients without the CA reject the certificate
+	_, err = http.Get("https://" + proxy.BindAddr())
+	require.ErrorContains(t, err, "certificate")
+}
+
+func TestTLSHandshakeFaults(t *testing.T) {
+	t.Run("terminating", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Write([]byte("PONG"))
+		}))
+		t.Cleanup(server.Close)
+
+		proxy := ForTest(t, Config{
+			Listen:    "127.0.0.1:0",
+			Target:    server.URL,
+			ListenTLS: true,
+			TLSHandshake: TLSHandshakeFaults{
+				FailureRatio: 100,
+			},
+		})
+
+		client := &http.Client{
+			Transport: &http.Transport{TLSClientConfig: proxy.ClientTLSConfig()},
+		}
+		_, err := client.Get("https://" + proxy.BindAddr())
+		require.Error(t, err)
+		require.Equal(t, uint32(1), proxy.handshakeFails.Load())
+	})
+
+	t.Run("passthrough stall", func(t *testing.T) {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Write([]byte("PONG"))
+		}))
+		t.Cleanup(server.Close)
+
+		proxy := ForTest(t, Config{
+			Listen: "127.0.0.1:0",
+			Target: server.URL,
+			TLSHandshake: TLSHandshakeFaults{
+				StallRatio:    100,
+				StallDuration: 100 * time.Millisecond,
+			},
+		})
+
+		start := time.Now()
+		resp, err := server.Client().Get("https://" + proxy.BindAddr())
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	})
+
+	t.Run("stall canceled", func(t *testing.T) {
+		client, server := net.Pipe()
+		defer client.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		c := &handshakeConn{
+			Conn:   server,
+			ctx:    ctx,
+			clock:  NewFakeClock(time.Now()),
+			faults: TLSHandshakeFaults{StallRatio: 100, StallDuration: 20 * time.Second},
+		}
+		go client.Write([]byte{0x16, 0x03, 0x01})
+		cancel()
+
+		_, err := c.Read(make([]byte, 16))
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestBadCertificates(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("PONG"))
+	}))
+	t.Cleanup(server.Close)
+
+	cases := map[string]struct {
+		bad      BadCertificates
+		contains string
+	}{
+		"expired":    {BadCertificates{ExpiredRatio: 100}, "expired"},
+		"wrong host": {BadCertificates{WrongHostRatio: 100}, "cannot validate certificate"},
+		"untrusted":  {BadCertificates{UntrustedRatio: 100}, "unknown authority"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			proxy := ForTest(t, Config{
+				Listen:          "127.0.0.1:0",
+				Target:          server.URL,
+				ListenTLS:       true,
+				BadCertificates: tc.bad,
+			})
+
+			client := &http.Client{
+				Transport: &http.Transport{TLSClientConfig: proxy.ClientTLSConfig()},
+			}
+			_, err := client.Get("https://" + proxy.BindAddr())
+			require.ErrorContains(t, err, tc.contains)
+			require.Equal(t, uint32(1), proxy.badCertificates.Load())
+		})
+	}
+}
+
+func TestClientCertificates(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("PONG"))
+	}))
+	t.Cleanup(server.Close)
+
+	t.Run("required", func(t *testing.T) {
+		proxy := ForTest(t, Config{
+			Listen:    "127.0.0.1:0",
+			Target:    server.URL,
+			ListenTLS: true,
+			ClientCertificates: ClientCertificates{
+				Require: true,
+			},
+		})
+
+		client := &http.Client{
+			Transport: &http.Transport{TLSClientConfig: proxy.ClientTLSConfig()},
+		}
+		resp, err := client.Get("https://" + proxy.BindAddr())
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		// Clients without a certificate are rejected
+		conf := proxy.ClientTLSConfig()
+		conf.Certificates = nil
+		client = &http.Client{
+			Transport: &http.Transport{TLSClientConfig: conf},
+		}
+		_, err = client.Get("https://" + proxy.BindAddr())
+		require.Error(t, err)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		var verified bool
+		proxy := ForTest(t, Config{
+			Listen:    "127.0.0.1:0",
+			Target:    server.URL,
+			ListenTLS: true,
+			ClientCertificates: ClientCertificates{
+				Require: true,
+				Verify: func(_ [][]byte, _ [][]*x509.Certificate) error {
+					verified = true
+					return nil
+				},
+				RejectRatio: 100,
+			},
+		})
+
+		client := &http.Client{
+			Transport: &http.Transport{TLSClientConfig: proxy.ClientTLSConfig()},
+		}
+		_, err := client.Get("https://" + proxy.BindAddr())
+		require.Error(t, err)
+		require.True(t, verified)
+		require.Equal(t, uint32(1), proxy.clientCertFails.Load())
+	})
+}
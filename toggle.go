@@ -0,0 +1,126 @@
+package badnet
+
+import (
+	"net"
+	"sync"
+)
+
+// Names of badnet's own faults, for Proxy.DisableToxic and Proxy.EnableToxic.
+const (
+	// ToxicThrottle covers bandwidth limits, latency, spikes and ramps.
+	ToxicThrottle = "throttle"
+	ToxicTrickle  = "trickle"
+	ToxicSqueeze  = "squeeze"
+	ToxicMTU      = "mtu"
+	ToxicCoalesce = "coalesce"
+
+	// ToxicFailures covers FailureRatio, FailureProbability, FailureBasisPoints
+	// and FailEveryNth.
+	ToxicFailures  = "failures"
+	ToxicGarbage   = "garbage"
+	ToxicTruncate  = "truncate"
+	ToxicDuplicate = "duplicate"
+	ToxicReorder   = "reorder"
+)
+
+// Named gives a custom toxic a name so it can be turned off and on with
+// Proxy.DisableToxic and Proxy.EnableToxic.
+func Named(name string, toxic Toxic) Toxic {
+	return namedToxic{Toxic: toxic, name: name}
+}
+
+type namedToxic struct {
+	Toxic
+
+	name string
+}
+
+// DisableToxic turns off the fault or toxic with the given name on open connections
+// as well as new ones, such as ToxicGarbage to stop corrupting data while keeping
+// latency. Custom toxics are named with Named. Names which aren't in use are
+// ignored.
+func (p *Proxy) DisableToxic(name string) {
+	p.toggles.set(name, false)
+}
+
+// EnableToxic turns a toxic switched off with DisableToxic back on.
+func (p *Proxy) EnableToxic(name string) {
+	p.toggles.set(name, true)
+}
+
+// toxicToggles holds which toxics have been disabled. The zero value has every
+// toxic enabled and a nil *toxicToggles can't disable any.
+type toxicToggles struct {
+	mu       sync.RWMutex
+	disabled map[string]bool
+}
+
+func (t *toxicToggles) set(name string, enabled bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.disabled == nil {
+		t.disabled = make(map[string]bool)
+	}
+	t.disabled[name] = !enabled
+}
+
+func (t *toxicToggles) enabled(name string) bool {
+	if t == nil {
+		return true
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return !t.disabled[name]
+}
+
+// toggle lets toxic be switched off by name. Toxics without a name are returned as-is.
+func (t *toxicToggles) toggle(name string, toxic Toxic) Toxic {
+	if named, ok := toxic.(namedToxic); ok {
+		name = named.name
+	}
+	if t == nil || name == "" || toxic == nil {
+		return toxic
+	}
+	return ToxicFunc(func(c net.Conn, info ToxicInfo) net.Conn {
+		return &toggledConn{
+			Conn:    toxic.Wrap(c, info),
+			direct:  c,
+			enabled: func() bool { return t.enabled(name) },
+		}
+	})
+}
+
+// toggledConn reads and writes through a toxic's connection while it's enabled
+// and straight to the connection the toxic wrapped while it's disabled. Closing
+// and deadlines always go through the toxic.
+type toggledConn struct {
+	net.Conn
+
+	direct  net.Conn
+	enabled func() bool
+}
+
+// NetConn returns the connection the toxic wrapped.
+func (c *toggledConn) NetConn() net.Conn {
+	return c.direct
+}
+
+func (c *toggledConn) Read(b []byte) (int, error) {
+	if c.enabled() {
+		return c.Conn.Read(b)
+	}
+	return c.direct.Read(b)
+}
+
+func (c *toggledConn) Write(b []byte) (int, error) {
+	if c.enabled() {
+		return c.Conn.Write(b)
+	}
+	return c.direct.Write(b)
+}
+
+func (c *toggledConn) CloseWrite() error {
+	return closeWrite(c.Conn)
+}
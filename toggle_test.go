@@ -0,0 +1,65 @@
+package badnet
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestToxicToggles(t *testing.T) {
+	var toggles *toxicToggles
+	require.True(t, toggles.enabled(ToxicGarbage))
+
+	toggles = &toxicToggles{}
+	require.True(t, toggles.enabled(ToxicGarbage))
+	toggles.set(ToxicGarbage, false)
+	require.False(t, toggles.enabled(ToxicGarbage))
+	require.True(t, toggles.enabled(ToxicFailures))
+	toggles.set(ToxicGarbage, true)
+	require.True(t, toggles.enabled(ToxicGarbage))
+}
+
+func TestDisableToxic(t *testing.T) {
+	proxy := ForTest(t, Config{
+		Listen: "127.0.0.1:0",
+		Target: echoServer(t),
+		Write: Direction{
+			Garbage: Garbage{Ratio: 100, Bytes: []byte("!"), Position: GarbageAfter},
+		},
+		Toxics: []Toxic{
+			Named("upper", ToxicFunc(func(c net.Conn, _ ToxicInfo) net.Conn {
+				return &upperConn{Conn: c}
+			})),
+		},
+	})
+
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	echo := func(msg string, n int) string {
+		_, err := conn.Write([]byte(msg))
+		require.NoError(t, err)
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, n)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		return string(buf)
+	}
+	require.Equal(t, "HELLO!", echo("hello", 6))
+
+	// Toxics are switched off on open connections
+	proxy.DisableToxic(ToxicGarbage)
+	require.Equal(t, "HELLO", echo("hello", 5))
+
+	proxy.DisableToxic("upper")
+	require.Equal(t, "hello", echo("hello", 5))
+
+	proxy.EnableToxic(ToxicGarbage)
+	proxy.EnableToxic("upper")
+	require.Equal(t, "HELLO!", echo("hello", 6))
+}
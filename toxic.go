@@ -0,0 +1,170 @@
+package badnet
+
+import (
+	"context"
+	"net"
+)
+
+// Toxic changes how data moves through a connection. Wrap returns a net.Conn which
+// applies the toxic to c, usually by overriding Read, Write or Close.
+//
+// For a Proxy, Read carries data from the client toward the target and Write carries
+// data back to the client. For a Dialer, Read is data from the server and Write is
+// data sent to it.
+//
+// badnet's own faults are toxics too. The MTU is applied closest to the network,
+// followed by coalescing, bandwidth and latency, trickling and then the ratio based
+// faults.
+// Each Direction's Toxics come next, then Config.Toxics wrap the result in order.
+type Toxic interface {
+	Wrap(c net.Conn, info ToxicInfo) net.Conn
+}
+
+// ToxicFunc adapts a function into a Toxic.
+type ToxicFunc func(c net.Conn, info ToxicInfo) net.Conn
+
+func (f ToxicFunc) Wrap(c net.Conn, info ToxicInfo) net.Conn {
+	return f(c, info)
+}
+
+// ToxicInfo describes the connection a Toxic is applied to.
+type ToxicInfo struct {
+	// Context is canceled once a Proxy's connection is closed, and is the ctx
+	// passed to Dial or DialContext for a Dialer.
+	Context context.Context
+
+	// ID is the connection's ID, as returned by ConnectionID, and empty for a Dialer.
+	ID string
+
+	// Number is the connection's position among those accepted or dialed, from 1.
+	Number uint32
+
+	// RemoteAddr is the client's address for a Proxy and the server's for a Dialer.
+	RemoteAddr net.Addr
+
+	// Target is where the connection is being forwarded, empty for a Dialer.
+	Target string
+
+	// Clock is the Config's Clock, for toxics which wait.
+	Clock Clock
+}
+
+// clock returns the Clock toxics should wait on, defaulting to the system clock.
+func (info ToxicInfo) clock() Clock {
+	if info.Clock != nil {
+		return info.Clock
+	}
+	return realClock{}
+}
+
+// applyToxics wraps c with each toxic in order.
+func applyToxics(c net.Conn, info ToxicInfo, toxics ...Toxic) net.Conn {
+	for _, toxic := range toxics {
+		if toxic != nil {
+			c = toxic.Wrap(c, info)
+		}
+	}
+	return c
+}
+
+// directionToxics returns each Direction's Toxics, limited to that direction.
+// Named toxics can be switched off with toggles, which can be nil.
+func directionToxics(read, write Direction, toggles *toxicToggles) []Toxic {
+	var out []Toxic
+	for _, toxic := range read.Toxics {
+		out = append(out, oneWayToxic(toggles.toggle("", toxic), true))
+	}
+	for _, toxic := range write.Toxics {
+		out = append(out, oneWayToxic(toggles.toggle("", toxic), false))
+	}
+	return out
+}
+
+// oneWayToxic applies toxic to reads, or to writes, but not both.
+func oneWayToxic(toxic Toxic, reads bool) Toxic {
+	return ToxicFunc(func(c net.Conn, info ToxicInfo) net.Conn {
+		return &oneWayConn{
+			Conn:   toxic.Wrap(c, info),
+			direct: c,
+			reads:  reads,
+		}
+	})
+}
+
+// oneWayConn sends one direction through a toxic's connection and the other straight
+// to the connection the toxic wrapped. Closing and deadlines go through the toxic.
+type oneWayConn struct {
+	net.Conn
+
+	direct net.Conn
+	reads  bool
+}
+
+// NetConn returns the connection the toxic wrapped.
+func (c *oneWayConn) NetConn() net.Conn {
+	return c.direct
+}
+
+func (c *oneWayConn) Read(b []byte) (int, error) {
+	if c.reads {
+		return c.Conn.Read(b)
+	}
+	return c.direct.Read(b)
+}
+
+func (c *oneWayConn) Write(b []byte) (int, error) {
+	if c.reads {
+		return c.direct.Write(b)
+	}
+	return c.Conn.Write(b)
+}
+
+// bandwidthToxic applies bandwidth limits and latency from a pair of links. Waits
+// end once the connection's context is done or closing is closed.
+func (s *stats) bandwidthToxic(read, write *link, clock Clock, closing <-chan struct{}) Toxic {
+	return ToxicFunc(func(c net.Conn, info ToxicInfo) net.Conn {
+		ctx := info.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		return throttleConn(ctx, c, read, write, clock, closing, s.injectedDelay.observe)
+	})
+}
+
+// trickleToxic applies each Direction's Trickle.
+func (s *stats) trickleToxic(read, write Direction, clock Clock) Toxic {
+	return ToxicFunc(func(c net.Conn, _ ToxicInfo) net.Conn {
+		return trickle(c, read, write, clock, s.injectedDelay.observe)
+	})
+}
+
+// squeezeToxic applies each Direction's Squeeze.
+func (s *stats) squeezeToxic(read, write Direction, clock Clock) Toxic {
+	return ToxicFunc(func(c net.Conn, _ ToxicInfo) net.Conn {
+		return squeeze(c, read, write, clock, s.injectedDelay.observe)
+	})
+}
+
+// faultToxic applies the ratio based faults of each Direction, recording them in s.
+// burstFailureRatio optionally
+// raises the failure ratio, trace optionally records or replays the faults and
+// toggles optionally switches them off.
+func (s *stats) faultToxic(read, write Direction, burstFailureRatio func() int, trace *tracer, toggles *toxicToggles) Toxic {
+	return ToxicFunc(func(c net.Conn, info ToxicInfo) net.Conn {
+		fc := &conn{
+			Conn:              c,
+			read:              read,
+			write:             write,
+			onReadFailure:     s.readFailed,
+			onWriteFailure:    s.writeFailed,
+			onTruncate:        s.truncated,
+			onGarbage:         s.garbageInjected,
+			onDuplicate:       s.duplicated,
+			burstFailureRatio: burstFailureRatio,
+			number:            info.Number,
+			trace:             trace,
+			toggles:           toggles,
+		}
+		return fc.chooseFailures()
+	})
+}
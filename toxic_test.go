@@ -0,0 +1,144 @@
+package badnet
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type upperConn struct {
+	net.Conn
+}
+
+func (c *upperConn) Write(b []byte) (int, error) {
+	return c.Conn.Write(bytes.ToUpper(b))
+}
+
+func TestToxics(t *testing.T) {
+	target := echoServer(t)
+
+	var mu sync.Mutex
+	var seen []ToxicInfo
+
+	proxy := ForTest(t, Config{
+		Listen:       "127.0.0.1:0",
+		Target:       target,
+		PreserveHost: true,
+		Toxics: []Toxic{
+			ToxicFunc(func(c net.Conn, info ToxicInfo) net.Conn {
+				mu.Lock()
+				seen = append(seen, info)
+				mu.Unlock()
+				return &upperConn{Conn: c}
+			}),
+		},
+	})
+	require.False(t, proxy.fastPath(proxy.route("", nil)))
+
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	buf := make([]byte, 5)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "HELLO", string(buf))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, seen, 1)
+	require.Equal(t, uint32(1), seen[0].Number)
+	require.Equal(t, target, seen[0].Target)
+	require.Equal(t, conn.LocalAddr().String(), seen[0].RemoteAddr.String())
+}
+
+func TestDialerToxics(t *testing.T) {
+	target := echoServer(t)
+
+	dialer := DialerForTest(t, Config{
+		Toxics: []Toxic{
+			ToxicFunc(func(c net.Conn, info ToxicInfo) net.Conn {
+				return &upperConn{Conn: c}
+			}),
+		},
+	})
+	conn, err := dialer.DialContext(context.Background(), "tcp", target)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	buf := make([]byte, 5)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "HELLO", string(buf))
+}
+
+func TestDirectionToxics(t *testing.T) {
+	upper := ToxicFunc(func(c net.Conn, _ ToxicInfo) net.Conn {
+		return &upperConn{Conn: c}
+	})
+
+	// Each direction only affects its own data
+	rec := &recordingConn{}
+	c := applyToxics(rec, ToxicInfo{}, directionToxics(Direction{Toxics: []Toxic{upper}}, Direction{}, nil)...)
+	_, err := c.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.Equal(t, "hello", rec.buf.String())
+
+	rec = &recordingConn{}
+	c = applyToxics(rec, ToxicInfo{}, directionToxics(Direction{}, Direction{Toxics: []Toxic{upper}}, nil)...)
+	_, err = c.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.Equal(t, "HELLO", rec.buf.String())
+
+	// Through a proxy, upper-casing the Write direction only changes what's sent back
+	received := make(chan string, 1)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 5)
+		io.ReadFull(conn, buf)
+		received <- string(buf)
+		conn.Write([]byte("world"))
+	}()
+
+	proxy := ForTest(t, Config{
+		Listen: "127.0.0.1:0",
+		Target: ln.Addr().String(),
+		Write:  Direction{Toxics: []Toxic{upper}},
+	})
+	require.False(t, proxy.fastPath(proxy.route("", nil)))
+
+	conn, err := net.Dial("tcp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	buf := make([]byte, 5)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "WORLD", string(buf))
+	require.Equal(t, "hello", <-received)
+}
@@ -0,0 +1,239 @@
+package badnet
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceEvent is one fault injected by a Proxy, as written by Config.RecordTrace.
+type TraceEvent struct {
+	// Connection is the connection's number, starting at 1 for the first accepted.
+	Connection uint32 `json:"conn"`
+
+	// Direction is "read" or "write", or empty for faults applied to the whole
+	// connection. Op is the number of the read or write within that direction
+	// which the fault was applied to.
+	Direction string `json:"dir,omitempty"`
+	Op        int    `json:"op,omitempty"`
+
+	// Fault is one of "failure", "garbage", "duplicate", "truncate" or "close".
+	Fault string `json:"fault"`
+
+	Time time.Time `json:"time"`
+
+	// Garbage and Offset are the bytes injected by a "garbage" fault and where
+	// they were placed.
+	Garbage []byte `json:"garbage,omitempty"`
+	Offset  int    `json:"offset,omitempty"`
+}
+
+const (
+	faultFailure   = "failure"
+	faultGarbage   = "garbage"
+	faultDuplicate = "duplicate"
+	faultTruncate  = "truncate"
+	faultClose     = "close"
+)
+
+// ReadTrace parses the events written by Config.RecordTrace.
+func ReadTrace(r io.Reader) ([]TraceEvent, error) {
+	var events []TraceEvent
+	dec := json.NewDecoder(r)
+	for {
+		var event TraceEvent
+		err := dec.Decode(&event)
+		if errors.Is(err, io.EOF) {
+			return events, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading trace: %w", err)
+		}
+		events = append(events, event)
+	}
+}
+
+type traceKey struct {
+	connection uint32
+	direction  string
+	op         int
+	fault      string
+}
+
+// tracer records fault decisions to a file, or replays the decisions from one.
+// A nil tracer does neither.
+type tracer struct {
+	mu   sync.Mutex
+	file *os.File
+	out  *bufio.Writer
+	enc  *json.Encoder
+
+	// replay holds the faults to inject when replaying, and is nil when recording.
+	replay map[traceKey]TraceEvent
+
+	clock Clock
+}
+
+func newTracer(conf Config) (*tracer, error) {
+	switch {
+	case conf.RecordTrace != "" && conf.ReplayTrace != "":
+		return nil, errors.New("RecordTrace and ReplayTrace can't be used together")
+
+	case conf.RecordTrace != "":
+		file, err := os.Create(conf.RecordTrace)
+		if err != nil {
+			return nil, fmt.Errorf("creating trace: %w", err)
+		}
+		out := bufio.NewWriter(file)
+		return &tracer{file: file, out: out, enc: json.NewEncoder(out), clock: conf.clock()}, nil
+
+	case conf.ReplayTrace != "":
+		if faults := unrecordedFaults(conf); len(faults) > 0 {
+			return nil, fmt.Errorf("ReplayTrace can't reproduce %s, which aren't recorded", strings.Join(faults, ", "))
+		}
+		file, err := os.Open(conf.ReplayTrace)
+		if err != nil {
+			return nil, fmt.Errorf("opening trace: %w", err)
+		}
+		defer file.Close()
+
+		events, err := ReadTrace(file)
+		if err != nil {
+			return nil, err
+		}
+		t := &tracer{replay: make(map[traceKey]TraceEvent, len(events))}
+		for _, event := range events {
+			t.replay[event.key()] = event
+		}
+		return t, nil
+	}
+	return nil, nil
+}
+
+// unrecordedFaults returns the fields of conf which inject faults at random
+// without recording them, so a replay would choose them afresh.
+func unrecordedFaults(conf Config) []string {
+	var out []string
+	add := func(random bool, name string) {
+		if random {
+			out = append(out, name)
+		}
+	}
+	add(conf.DialFailureRatio > 0, "DialFailureRatio")
+	add(conf.HijackTarget != "" && conf.HijackRatio > 0, "HijackRatio")
+	add(conf.TLSHandshake.StallRatio > 0 || conf.TLSHandshake.FailureRatio > 0, "TLSHandshake")
+	add(conf.BadCertificates.enabled(), "BadCertificates")
+	add(conf.ClientCertificates.RejectRatio > 0, "ClientCertificates.RejectRatio")
+	add(conf.Tarpit.Ratio > 0, "Tarpit")
+	add(conf.ConnectionStorm.Ratio > 0, "ConnectionStorm")
+	add(conf.ReorderResponses.Ratio > 0, "ReorderResponses")
+	if r, ok := conf.TargetResolver.(*Resolver); ok {
+		add(r.conf.random(), "TargetResolver")
+	}
+	add(unrecordedToxics(conf.Toxics), "Toxics")
+	for _, dir := range conf.directions() {
+		add(dir.d.Spikes.Ratio > 0, dir.name+".Spikes.Ratio")
+		add(dir.d.Datagrams.enabled(), dir.name+".Datagrams")
+		add(unrecordedToxics(dir.d.Toxics), dir.name+".Toxics")
+	}
+	return out
+}
+
+type namedDirection struct {
+	name string
+	d    Direction
+}
+
+// directions returns every Direction in conf named by its field, including those
+// of Clients, SNIRoutes and TargetOptions, sorted after Read and Write.
+func (c Config) directions() []namedDirection {
+	var out []namedDirection
+	add := func(name string, read, write *Direction) {
+		if read != nil {
+			out = append(out, namedDirection{name + ".Read", *read})
+		}
+		if write != nil {
+			out = append(out, namedDirection{name + ".Write", *write})
+		}
+	}
+	for key, opts := range c.Clients {
+		add(fmt.Sprintf("Clients[%q]", key), opts.Read, opts.Write)
+	}
+	for key, opts := range c.TargetOptions {
+		add(fmt.Sprintf("TargetOptions[%q]", key), opts.Read, opts.Write)
+	}
+	for key, route := range c.SNIRoutes {
+		route := route
+		add(fmt.Sprintf("SNIRoutes[%q]", key), &route.Read, &route.Write)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].name < out[j].name })
+
+	return append([]namedDirection{{"Read", c.Read}, {"Write", c.Write}}, out...)
+}
+
+// unrecordedToxic marks badnet's own toxics which make random choices, like
+// RedisError, so ReplayTrace can refuse them. Other custom toxics are the
+// caller's to keep deterministic.
+type unrecordedToxic struct {
+	ToxicFunc
+}
+
+func unrecordedToxics(toxics []Toxic) bool {
+	for _, toxic := range toxics {
+		if _, ok := toxic.(unrecordedToxic); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (e TraceEvent) key() traceKey {
+	return traceKey{connection: e.Connection, direction: e.Direction, op: e.Op, fault: e.Fault}
+}
+
+func (t *tracer) replaying() bool {
+	return t != nil && t.replay != nil
+}
+
+// replayed returns the recorded fault matching event when replaying.
+func (t *tracer) replayed(event TraceEvent) (TraceEvent, bool) {
+	if !t.replaying() {
+		return TraceEvent{}, false
+	}
+	recorded, ok := t.replay[event.key()]
+	return recorded, ok
+}
+
+// record writes an injected fault when recording.
+func (t *tracer) record(event TraceEvent) {
+	if t == nil || t.enc == nil {
+		return
+	}
+	event.Time = t.clock.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.enc.Encode(event)
+}
+
+func (t *tracer) close() error {
+	if t == nil || t.file == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	err := t.out.Flush()
+	if cerr := t.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
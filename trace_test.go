@@ -0,0 +1,137 @@
+package badnet
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceReplay(t *testing.T) {
+	target := echoServer(t)
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+
+	// exchange sends a message over each of a series of connections and returns
+	// what came back through the proxy.
+	exchange := func(t *testing.T, proxy *Proxy) []string {
+		var responses []string
+		for i := 0; i < 20; i++ {
+			conn, err := net.Dial("tcp", proxy.BindAddr())
+			require.NoError(t, err)
+
+			// Connections closed after they're accepted fail here
+			fmt.Fprintf(conn, "message %d", i)
+			conn.(*net.TCPConn).CloseWrite()
+
+			response, _ := io.ReadAll(conn)
+			conn.Close()
+			responses = append(responses, string(response))
+		}
+		return responses
+	}
+
+	var recorded []string
+	t.Run("record", func(t *testing.T) {
+		proxy := ForTest(t, Config{
+			Target: target,
+			Read: Direction{
+				Garbage: Garbage{Ratio: 30, Position: GarbageInterleaved},
+			},
+			Write: Direction{
+				TruncateRatio:  30,
+				DuplicateRatio: 30,
+			},
+			CloseAfterAcceptRatio: 20,
+			RecordTrace:           path,
+		})
+		recorded = exchange(t, proxy)
+	})
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	events, err := ReadTrace(file)
+	require.NoError(t, err)
+	require.NotEmpty(t, events)
+	for _, event := range events {
+		require.Contains(t, []string{faultGarbage, faultDuplicate, faultTruncate, faultClose}, event.Fault)
+		if event.Fault == faultGarbage {
+			require.Len(t, event.Garbage, 16)
+		}
+	}
+
+	t.Run("replay", func(t *testing.T) {
+		proxy := ForTest(t, Config{
+			Target:      target,
+			ReplayTrace: path,
+		})
+		require.Equal(t, recorded, exchange(t, proxy))
+	})
+}
+
+func TestTraceConfig(t *testing.T) {
+	_, err := newTracer(Config{RecordTrace: "a", ReplayTrace: "b"})
+	require.Error(t, err)
+
+	_, err = newTracer(Config{ReplayTrace: filepath.Join(t.TempDir(), "missing")})
+	require.Error(t, err)
+
+	// Faults which aren't recorded can't be replayed
+	_, err = newTracer(Config{
+		ReplayTrace:      filepath.Join(t.TempDir(), "missing"),
+		DialFailureRatio: 5,
+		Write:            Direction{Spikes: Spikes{Ratio: 5}},
+		Toxics:           []Toxic{RedisError(10, RedisBusy)},
+	})
+	require.EqualError(t, err, "ReplayTrace can't reproduce DialFailureRatio, Toxics, Write.Spikes.Ratio, which aren't recorded")
+
+	// Including those of per-client, per-target and per-route faults
+	spiky := &Direction{Spikes: Spikes{Ratio: 5}}
+	require.Equal(t, []string{
+		`Clients["10.0.0.0/8"].Write.Spikes.Ratio`,
+		`SNIRoutes["api.example.com"].Read.Datagrams`,
+		`TargetOptions["127.0.0.1:80"].Read.Spikes.Ratio`,
+	}, unrecordedFaults(Config{
+		Clients:       map[string]ClientOptions{"10.0.0.0/8": {Write: spiky}},
+		TargetOptions: map[string]TargetOptions{"127.0.0.1:80": {Read: spiky}},
+		SNIRoutes: map[string]SNIRoute{
+			"api.example.com": {Read: Direction{Datagrams: Datagrams{DropRatio: 5}}},
+		},
+	}))
+
+	require.Empty(t, unrecordedFaults(Config{
+		Read:   Direction{FailureRatio: 10, TruncateRatio: 10, Spikes: Spikes{Interval: time.Second}},
+		Toxics: []Toxic{ToxicFunc(func(c net.Conn, _ ToxicInfo) net.Conn { return c })},
+	}))
+
+	trace, err := newTracer(Config{})
+	require.NoError(t, err)
+	require.Nil(t, trace)
+	require.False(t, trace.replaying())
+	require.NoError(t, trace.close())
+}
+
+func TestTraceClock(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+
+	trace, err := newTracer(Config{RecordTrace: path, Clock: clock})
+	require.NoError(t, err)
+	trace.record(TraceEvent{Connection: 1, Direction: "read", Fault: "failure"})
+	require.NoError(t, trace.close())
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	events, err := ReadTrace(file)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.True(t, clock.Now().Equal(events[0].Time))
+}
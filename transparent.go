@@ -0,0 +1,53 @@
+package badnet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// originalDestination recovers where a connection sent to a transparent proxy was
+// headed. iptables REDIRECT rules record it with conntrack, while TPROXY rules leave
+// it as the connection's local address.
+func (p *Proxy) originalDestination(c net.Conn) (string, error) {
+	dst, err := originalDst(c)
+	if err != nil {
+		local, ok := c.LocalAddr().(*net.TCPAddr)
+		if !ok || p.listensOn(local) {
+			return "", fmt.Errorf("finding original destination: %w", err)
+		}
+		dst = local.String()
+	}
+
+	// Forwarding to ourselves would loop forever
+	if addr, err := net.ResolveTCPAddr("tcp", dst); err == nil && p.listensOn(addr) {
+		return "", errors.New("original destination is the proxy itself")
+	}
+	return dst, nil
+}
+
+// listensOn reports if addr is one of the proxy's listeners, including those for
+// Config.ListenAddrs.
+func (p *Proxy) listensOn(addr *net.TCPAddr) bool {
+	for _, bound := range p.bindAddrs {
+		b, err := net.ResolveTCPAddr("tcp", bound)
+		if err == nil && b.Port == addr.Port && (b.IP.IsUnspecified() || b.IP.Equal(addr.IP)) {
+			return true
+		}
+	}
+	return false
+}
+
+// tcpConn finds the *net.TCPConn underneath c.
+func tcpConn(c net.Conn) (*net.TCPConn, bool) {
+	for {
+		if tcp, ok := c.(*net.TCPConn); ok {
+			return tcp, true
+		}
+		u, ok := c.(interface{ NetConn() net.Conn })
+		if !ok {
+			return nil, false
+		}
+		c = u.NetConn()
+	}
+}
@@ -0,0 +1,100 @@
+//go:build linux
+
+package badnet
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"syscall"
+)
+
+// soOriginalDst is SO_ORIGINAL_DST and IP6T_SO_ORIGINAL_DST from linux/netfilter_ipv4.h
+const soOriginalDst = 80
+
+// ipv6Transparent is IPV6_TRANSPARENT from linux/in6.h
+const ipv6Transparent = 75
+
+func transparentSupported() error {
+	return nil
+}
+
+// setTransparent sets IP_TRANSPARENT on a listener so it accepts connections
+// TPROXY rules send it, which are addressed to somewhere else. Without
+// CAP_NET_ADMIN a warning is logged and only REDIRECTed connections arrive.
+func setTransparent(network string, c syscall.RawConn, logf func(format string, args ...interface{})) error {
+	level, opt := syscall.SOL_IP, syscall.IP_TRANSPARENT
+	if network == "tcp6" {
+		level, opt = syscall.SOL_IPV6, ipv6Transparent
+	}
+	var serr error
+	err := c.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), level, opt, 1)
+	})
+	if err != nil {
+		return err
+	}
+	if errors.Is(serr, syscall.EPERM) {
+		logf("badnet: setting IP_TRANSPARENT requires CAP_NET_ADMIN, TPROXY rules won't reach the proxy")
+		return nil
+	}
+	return serr
+}
+
+// originalDst asks conntrack where a REDIRECTed connection was originally headed.
+func originalDst(c net.Conn) (string, error) {
+	tcp, ok := tcpConn(c)
+	if !ok {
+		return "", errors.New("not a TCP connection")
+	}
+	raw, err := tcp.SyscallConn()
+	if err != nil {
+		return "", err
+	}
+
+	ipv4 := true
+	if local, ok := c.LocalAddr().(*net.TCPAddr); ok && local.IP.To4() == nil {
+		ipv4 = false
+	}
+
+	var addr string
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		if ipv4 {
+			// sockaddr_in fits in the 20 bytes of an ipv6_mreq
+			mreq, err := syscall.GetsockoptIPv6Mreq(int(fd), syscall.IPPROTO_IP, soOriginalDst)
+			if err != nil {
+				serr = err
+				return
+			}
+			addr = sockaddrInet4(mreq.Multiaddr)
+			return
+		}
+		// sockaddr_in6 fits in the 32 bytes of an ip6_mtuinfo
+		info, err := syscall.GetsockoptIPv6MTUInfo(int(fd), syscall.IPPROTO_IPV6, soOriginalDst)
+		if err != nil {
+			serr = err
+			return
+		}
+		addr = sockaddrInet6(info.Addr)
+	})
+	if err != nil {
+		return "", err
+	}
+	return addr, serr
+}
+
+// sockaddrInet4 reads a raw sockaddr_in: family, port in network order, then the address.
+func sockaddrInet4(b [16]byte) string {
+	port := int(b[2])<<8 | int(b[3])
+	return net.JoinHostPort(net.IP(b[4:8]).String(), strconv.Itoa(port))
+}
+
+// sockaddrInet6 formats a raw sockaddr_in6.
+func sockaddrInet6(sa syscall.RawSockaddrInet6) string {
+	// Port holds the bytes in network order, whatever the host's order is
+	var port [2]byte
+	binary.NativeEndian.PutUint16(port[:], sa.Port)
+	return net.JoinHostPort(net.IP(sa.Addr[:]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(port[:]))))
+}
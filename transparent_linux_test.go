@@ -0,0 +1,91 @@
+//go:build linux
+
+package badnet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSockaddr(t *testing.T) {
+	require.Equal(t, "10.1.2.3:8443", sockaddrInet4([16]byte{2, 0, 0x20, 0xfb, 10, 1, 2, 3}))
+
+	var sa syscall.RawSockaddrInet6
+	sa.Port = binary.NativeEndian.Uint16([]byte{0x20, 0xfb})
+	sa.Addr[15] = 1
+	require.Equal(t, "[::1]:8443", sockaddrInet6(sa))
+}
+
+func TestTransparent(t *testing.T) {
+	p := ForTest(t, Config{Transparent: true})
+
+	// Connections that weren't redirected have nowhere to go
+	conn, err := net.Dial("tcp", p.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	require.Error(t, err)
+	require.Equal(t, uint32(1), p.Stats().TargetFailures)
+}
+
+func TestTransparentListener(t *testing.T) {
+	var warnings []string
+	listeners, err := newListeners(Config{Listen: "127.0.0.1:0", Transparent: true}, func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	})
+	require.NoError(t, err)
+	defer listeners[0].Close()
+
+	raw, err := listeners[0].(*net.TCPListener).SyscallConn()
+	require.NoError(t, err)
+	var on int
+	var serr error
+	require.NoError(t, raw.Control(func(fd uintptr) {
+		on, serr = syscall.GetsockoptInt(int(fd), syscall.SOL_IP, syscall.IP_TRANSPARENT)
+	}))
+	require.NoError(t, serr)
+	if len(warnings) > 0 {
+		// Unprivileged listeners still accept REDIRECTed connections
+		require.Zero(t, on)
+		return
+	}
+	require.Equal(t, 1, on)
+}
+
+// addrConn reports local as its local address.
+type addrConn struct {
+	net.Conn
+	local net.Addr
+}
+
+func (c addrConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func TestOriginalDestination(t *testing.T) {
+	p := &Proxy{bindAddrs: []string{"127.0.0.1:8080", "0.0.0.0:9090"}}
+	conn := func(addr string) net.Conn {
+		local, err := net.ResolveTCPAddr("tcp", addr)
+		require.NoError(t, err)
+		return addrConn{local: local}
+	}
+
+	// TPROXY connections arrive addressed to their original destination
+	dst, err := p.originalDestination(conn("10.1.2.3:443"))
+	require.NoError(t, err)
+	require.Equal(t, "10.1.2.3:443", dst)
+
+	// but connections made to any of the listeners weren't redirected
+	for _, addr := range []string{"127.0.0.1:8080", "127.0.0.1:9090", "10.1.2.3:9090"} {
+		_, err = p.originalDestination(conn(addr))
+		require.Error(t, err, addr)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package badnet
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+var errTransparentUnsupported = errors.New("Transparent requires linux")
+
+// transparentSupported fails proxies with Config.Transparent before they listen,
+// since connections can't be redirected to them here.
+func transparentSupported() error {
+	return errTransparentUnsupported
+}
+
+func originalDst(c net.Conn) (string, error) {
+	return "", errors.New("SO_ORIGINAL_DST requires linux")
+}
+
+func setTransparent(network string, c syscall.RawConn, logf func(format string, args ...interface{})) error {
+	return errTransparentUnsupported
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package badnet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransparentUnsupported(t *testing.T) {
+	require.ErrorIs(t, transparentSupported(), errTransparentUnsupported)
+
+	_, err := listen("tcp", "127.0.0.1:0", BindRetry{}, true, t.Logf)
+	require.ErrorIs(t, err, errTransparentUnsupported)
+}
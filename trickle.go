@@ -0,0 +1,93 @@
+package badnet
+
+import (
+	"net"
+	"time"
+)
+
+// Trickle sends data in fixed-size pieces with a pause between each, such as one byte
+// every second, to reproduce slow-loris clients and servers.
+type Trickle struct {
+	Bytes    int
+	Interval time.Duration
+}
+
+func (t Trickle) enabled() bool {
+	return t.Bytes > 0 && t.Interval > 0
+}
+
+// trickleConn paces reads and writes according to a Trickle in each direction.
+type trickleConn struct {
+	net.Conn
+
+	read, write Trickle
+	lastRead    time.Time
+	clock       Clock
+	observe     func(time.Duration)
+}
+
+// NetConn returns the underlying connection.
+func (c *trickleConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func trickle(c net.Conn, read, write Direction, clock Clock, observe func(time.Duration)) net.Conn {
+	if !read.Trickle.enabled() && !write.Trickle.enabled() {
+		return c
+	}
+	return &trickleConn{
+		Conn:    c,
+		read:    read.Trickle,
+		write:   write.Trickle,
+		clock:   clock,
+		observe: observe,
+	}
+}
+
+func (c *trickleConn) Read(b []byte) (int, error) {
+	if !c.read.enabled() {
+		return c.Conn.Read(b)
+	}
+	if len(b) > c.read.Bytes {
+		b = b[:c.read.Bytes]
+	}
+	if !c.lastRead.IsZero() {
+		c.pause(c.lastRead.Add(c.read.Interval).Sub(c.clock.Now()))
+	}
+	n, err := c.Conn.Read(b)
+	c.lastRead = c.clock.Now()
+	return n, err
+}
+
+func (c *trickleConn) Write(b []byte) (int, error) {
+	if !c.write.enabled() {
+		return c.Conn.Write(b)
+	}
+	var written int
+	for len(b) > 0 {
+		if written > 0 {
+			c.pause(c.write.Interval)
+		}
+		chunk := b
+		if len(chunk) > c.write.Bytes {
+			chunk = chunk[:c.write.Bytes]
+		}
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+func (c *trickleConn) pause(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	c.clock.Sleep(d)
+	if c.observe != nil {
+		c.observe(d)
+	}
+}
@@ -0,0 +1,55 @@
+package badnet
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrickle(t *testing.T) {
+	t.Run("Dialer", func(t *testing.T) {
+		dialer := DialerForTest(t, Config{
+			Target: echoServer(t),
+			Write:  Direction{Trickle: Trickle{Bytes: 1, Interval: 20 * time.Millisecond}},
+		})
+		conn, err := dialer.Dial(context.Background())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		start := time.Now()
+		n, err := conn.Write([]byte("ping"))
+		require.NoError(t, err)
+		require.Equal(t, 4, n)
+		require.GreaterOrEqual(t, time.Since(start), 60*time.Millisecond)
+
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		require.Equal(t, "ping", string(buf))
+	})
+
+	t.Run("Proxy", func(t *testing.T) {
+		proxy := ForTest(t, Config{
+			Listen: "127.0.0.1:0",
+			Target: echoServer(t),
+			Read:   Direction{Trickle: Trickle{Bytes: 2, Interval: 30 * time.Millisecond}},
+		})
+		conn, err := net.Dial("tcp", proxy.BindAddr())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		start := time.Now()
+		_, err = conn.Write([]byte("pingpong"))
+		require.NoError(t, err)
+
+		buf := make([]byte, 8)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		require.Equal(t, "pingpong", string(buf))
+		require.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+	})
+}
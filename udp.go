@@ -0,0 +1,250 @@
+package badnet
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// UDPProxy relays datagrams between clients and a target, applying the Config's Read
+// faults to datagrams from clients and its Write faults to replies. See PacketConn for
+// the faults which apply to datagrams.
+//
+// Each client address gets its own socket to the target so replies are returned to
+// the right client. Sessions which haven't forwarded a datagram in either direction
+// for Config.IdleTimeout, or two minutes when it's unset, are closed like an idle
+// NAT mapping. Datagrams are forwarded unchanged, which makes the relay suitable
+// for QUIC and HTTP/3 clients as well as plain UDP protocols. Set Config.QUIC to
+// keep QUIC clients on their session when their address changes.
+type UDPProxy struct {
+	conf Config
+
+	conn   *PacketConn
+	target *net.UDPAddr
+
+	mu       sync.Mutex
+	sessions map[string]*udpSession
+	quic     *quicRoutes // nil unless Config.QUIC is set
+	closed   bool
+	done     chan struct{}
+	wg       sync.WaitGroup
+
+	stats
+}
+
+// defaultUDPSessionTimeout is how long idle sessions are kept without an IdleTimeout,
+// the minimum RFC 4787 asks of NATs.
+const defaultUDPSessionTimeout = 2 * time.Minute
+
+type udpSession struct {
+	client   net.Addr // guarded by UDPProxy.mu
+	upstream *net.UDPConn
+	idle     *idleTimer
+}
+
+// udpRetryDelay returns how long to wait after a read error when the previous
+// wait was d, backing off like net/http's Server does for Accept so persistent
+// errors don't spin.
+func udpRetryDelay(d time.Duration) time.Duration {
+	if d == 0 {
+		return 5 * time.Millisecond
+	}
+	return min(2*d, time.Second)
+}
+
+// UDPForTest starts a UDPProxy on conf.Listen which forwards to conf.Target and is
+// closed once t completes.
+func UDPForTest(t testing.TB, conf Config) *UDPProxy {
+	t.Helper()
+
+	conf = conf.Network.apply(conf, false)
+	if !conf.IgnoreEnv {
+		var err error
+		conf, err = ApplyEnv(conf)
+		if err != nil {
+			t.Fatalf("badnet: %v", err)
+		}
+	}
+
+	if conf.RecordTrace != "" || conf.ReplayTrace != "" {
+		t.Fatalf("badnet: UDPForTest doesn't record or replay traces")
+	}
+
+	target, err := net.ResolveUDPAddr("udp", conf.Target)
+	if err != nil {
+		t.Fatalf("badnet: resolving UDP target: %v", err)
+	}
+	laddr, err := net.ResolveUDPAddr("udp", conf.Listen)
+	if err != nil {
+		t.Fatalf("badnet: resolving UDP listen address: %v", err)
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		t.Fatalf("badnet listen failed: %v", err)
+	}
+
+	p := &UDPProxy{
+		conf:     conf,
+		target:   target,
+		sessions: make(map[string]*udpSession),
+		done:     make(chan struct{}),
+	}
+	if conf.QUIC {
+		p.quic = newQUICRoutes()
+	}
+	p.metrics = conf.Metrics
+	p.conn = wrapPacketConn(conn, conf, &p.stats)
+
+	t.Cleanup(p.close)
+
+	p.wg.Add(1)
+	go p.serve()
+
+	return p
+}
+
+// BindAddr returns the address the proxy is receiving datagrams on.
+func (p *UDPProxy) BindAddr() string {
+	return p.conn.LocalAddr().String()
+}
+
+func (p *UDPProxy) serve() {
+	defer p.wg.Done()
+
+	buf := make([]byte, 64*1024)
+	var retry time.Duration
+	for {
+		n, client, err := p.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || !p.retry(&retry) {
+				return
+			}
+			continue
+		}
+		retry = 0
+		if s, ok := p.session(client, buf[:n]); ok {
+			s.idle.active()
+			s.upstream.Write(buf[:n])
+		}
+	}
+}
+
+// retry waits before reading again after an error, returning false if the proxy
+// closes in the meantime.
+func (p *UDPProxy) retry(delay *time.Duration) bool {
+	*delay = udpRetryDelay(*delay)
+	return sleep(context.Background(), p.conf.clock(), *delay, p.done) == nil
+}
+
+// session returns the client's session, starting one for new clients. With QUIC
+// a packet from a new address joins the session its connection ID belongs to.
+func (p *UDPProxy) session(client net.Addr, packet []byte) (*udpSession, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, false
+	}
+	if s, ok := p.sessions[client.String()]; ok {
+		return s, true
+	}
+	if p.quic != nil {
+		if s, ok := p.quic.find(packet); ok {
+			delete(p.sessions, s.client.String())
+			s.client = client
+			p.sessions[client.String()] = s
+			return s, true
+		}
+	}
+
+	upstream, err := net.DialUDP("udp", nil, p.target)
+	if err != nil {
+		p.targetFailures.Add(1)
+		p.incCounter(MetricTargetFailures)
+		return nil, false
+	}
+	s := &udpSession{client: client, upstream: upstream}
+	s.idle = newIdleTimer(p.conf.clock(), p.sessionTimeout(), func() { p.expire(s) })
+	p.sessions[client.String()] = s
+
+	p.connectionCount.Add(1)
+	p.incCounter(MetricConnections)
+
+	p.wg.Add(1)
+	go p.relayReplies(s)
+
+	return s, true
+}
+
+// relayReplies forwards datagrams from the target back to a session's client.
+func (p *UDPProxy) relayReplies(s *udpSession) {
+	defer p.wg.Done()
+
+	buf := make([]byte, 64*1024)
+	var retry time.Duration
+	for {
+		n, err := s.upstream.Read(buf)
+		if err != nil {
+			// e.g. ICMP port unreachable reported on a later read
+			if errors.Is(err, net.ErrClosed) || !p.retry(&retry) {
+				return
+			}
+			continue
+		}
+		retry = 0
+		s.idle.active()
+
+		p.mu.Lock()
+		client := s.client
+		if p.quic != nil {
+			p.quic.learn(buf[:n], s)
+		}
+		p.mu.Unlock()
+
+		p.conn.WriteTo(buf[:n], client)
+	}
+}
+
+func (p *UDPProxy) sessionTimeout() time.Duration {
+	if p.conf.IdleTimeout > 0 {
+		return p.conf.IdleTimeout
+	}
+	return defaultUDPSessionTimeout
+}
+
+// expire closes an idle session. The client's next datagram starts a new one.
+func (p *UDPProxy) expire(s *udpSession) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := s.client.String()
+	if p.closed || p.sessions[key] != s {
+		return
+	}
+	delete(p.sessions, key)
+	if p.quic != nil {
+		p.quic.forget(s)
+	}
+	s.upstream.Close()
+
+	if p.conf.IdleTimeout > 0 {
+		p.idleTimedOut()
+	}
+}
+
+func (p *UDPProxy) close() {
+	p.mu.Lock()
+	p.closed = true
+	close(p.done)
+	p.conn.Close()
+	for _, s := range p.sessions {
+		s.idle.stop()
+		s.upstream.Close()
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
@@ -0,0 +1,187 @@
+package badnet
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func udpEchoServer(t *testing.T) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestUDPProxy(t *testing.T) {
+	proxy := UDPForTest(t, Config{
+		Listen: "127.0.0.1:0",
+		Target: udpEchoServer(t),
+		Write: Direction{
+			Latency: 50 * time.Millisecond,
+		},
+	})
+
+	// Separate clients each get their own replies
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("udp", proxy.BindAddr())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		start := time.Now()
+		_, err = conn.Write([]byte("hello"))
+		require.NoError(t, err)
+
+		buf := make([]byte, 100)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(buf[:n]))
+		require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	}
+
+	// Replies are counted once they're sent, which can be after the client reads them
+	require.Eventually(t, func() bool {
+		return proxy.Stats().WriteBytes == 10
+	}, time.Second, 10*time.Millisecond)
+	stats := proxy.Stats()
+	require.Equal(t, uint32(2), stats.Connections)
+	require.Equal(t, uint64(10), stats.ReadBytes)
+}
+
+func TestUDPProxyNetwork(t *testing.T) {
+	proxy := UDPForTest(t, Config{
+		Listen:  "127.0.0.1:0",
+		Target:  udpEchoServer(t),
+		Network: Network{Latency: 100 * time.Millisecond},
+	})
+	require.Equal(t, 50*time.Millisecond, proxy.conf.Read.Latency)
+
+	conn, err := net.Dial("udp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	start := time.Now()
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 100))
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestUDPProxyIdleSessions(t *testing.T) {
+	proxy := UDPForTest(t, Config{
+		Listen:      "127.0.0.1:0",
+		Target:      udpEchoServer(t),
+		IdleTimeout: 100 * time.Millisecond,
+	})
+
+	conn, err := net.Dial("udp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ping := func() {
+		t.Helper()
+
+		_, err := conn.Write([]byte("hello"))
+		require.NoError(t, err)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Read(make([]byte, 100))
+		require.NoError(t, err)
+	}
+	sessions := func() int {
+		proxy.mu.Lock()
+		defer proxy.mu.Unlock()
+		return len(proxy.sessions)
+	}
+
+	ping()
+	require.Equal(t, 1, sessions())
+
+	// The session is closed once it's idle and the next datagram starts another
+	require.Eventually(t, func() bool {
+		return sessions() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, uint32(1), proxy.Stats().IdleTimeouts)
+
+	ping()
+	require.Equal(t, uint32(2), proxy.Stats().Connections)
+}
+
+func TestUDPRetryDelay(t *testing.T) {
+	var delay time.Duration
+	for i := 0; i < 20; i++ {
+		next := udpRetryDelay(delay)
+		require.Greater(t, next, time.Duration(0))
+		require.LessOrEqual(t, next, time.Second)
+		delay = next
+	}
+	require.Equal(t, time.Second, delay)
+}
+
+func TestUDPProxyDrops(t *testing.T) {
+	proxy := UDPForTest(t, Config{
+		Listen: "127.0.0.1:0",
+		Target: udpEchoServer(t),
+		Read: Direction{
+			FailureRatio: 100,
+		},
+	})
+
+	conn, err := net.Dial("udp", proxy.BindAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err = conn.Write([]byte("hello"))
+		require.NoError(t, err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = conn.Read(make([]byte, 100))
+	require.Error(t, err)
+
+	require.Eventually(t, func() bool {
+		return proxy.readFailures.Load() == 5
+	}, time.Second, 10*time.Millisecond)
+	require.Zero(t, proxy.Stats().ReadBytes)
+}
+
+func TestDatagramQueue(t *testing.T) {
+	sent := make(chan string, 10)
+	q := newDatagramQueue(func(b []byte, _ net.Addr) {
+		sent <- string(b)
+	}, realClock{})
+	defer q.close()
+
+	q.push([]byte("later"), nil, 40*time.Millisecond)
+	q.push([]byte("sooner"), nil, 20*time.Millisecond)
+	q.push([]byte("queued"), nil, 0) // queued behind the others, but due first
+
+	for _, expected := range []string{"queued", "sooner", "later"} {
+		select {
+		case got := <-sent:
+			require.Equal(t, expected, got)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %s", expected)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package badnet
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+)
+
+// validateTimeout bounds how long ValidateTarget waits on each target.
+const validateTimeout = 5 * time.Second
+
+// validateTargets checks each of the proxy's targets is reachable with
+// Config.TargetProbe, or a dial without any faults by default.
+func validateTargets(conf Config) error {
+	if conf.SOCKS5 || conf.Transparent {
+		return nil
+	}
+
+	targets := conf.Targets
+	if len(targets) == 0 && conf.Target != "" {
+		targets = []string{conf.Target}
+	}
+	var routes []string
+	for _, route := range conf.SNIRoutes {
+		if route.Target != "" {
+			routes = append(routes, targetAddress(route.Target, route.TargetTLS != nil))
+		}
+	}
+	sort.Strings(routes)
+
+	probe := conf.TargetProbe
+	if probe == nil {
+		probe = dialProbe
+	}
+	addresses := make([]string, 0, len(targets)+len(routes))
+	for _, target := range targets {
+		addresses = append(addresses, targetAddress(target, conf.TargetTLS != nil))
+	}
+	for _, address := range append(addresses, routes...) {
+		ctx, cancel := context.WithTimeout(context.Background(), validateTimeout)
+		err := probe(ctx, address)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("target %s is unreachable: %w", address, err)
+		}
+	}
+	return nil
+}
+
+// dialProbe connects to address and hangs up.
+func dialProbe(ctx context.Context, address string) error {
+	network := "tcp"
+	if path, ok := unixSocketPath(address); ok {
+		network, address = "unix", path
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
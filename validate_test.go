@@ -0,0 +1,51 @@
+package badnet
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTarget(t *testing.T) {
+	target := echoServer(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closed := ln.Addr().String()
+	ln.Close()
+
+	require.NoError(t, validateTargets(Config{Target: target}))
+	require.NoError(t, validateTargets(Config{Target: closed, SOCKS5: true}))
+
+	err = validateTargets(Config{Target: closed})
+	require.ErrorIs(t, err, syscall.ECONNREFUSED)
+	require.ErrorContains(t, err, "target "+closed+" is unreachable")
+
+	err = validateTargets(Config{Targets: []string{target, closed}})
+	require.ErrorContains(t, err, closed)
+
+	err = validateTargets(Config{
+		Target:    target,
+		SNIRoutes: map[string]SNIRoute{"example.test": {Target: closed}},
+	})
+	require.ErrorContains(t, err, closed)
+
+	// Probes replace the dial
+	var probed []string
+	err = validateTargets(Config{
+		Target: "http://" + target + "/healthz",
+		TargetProbe: func(ctx context.Context, address string) error {
+			probed = append(probed, address)
+			return errors.New("not ready")
+		},
+	})
+	require.ErrorContains(t, err, "not ready")
+	require.Equal(t, []string{target}, probed)
+
+	proxy := ForTest(t, Config{Target: target, ValidateTarget: true})
+	require.Greater(t, proxy.Port(), 0)
+}
@@ -0,0 +1,48 @@
+package badnet
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const waitInterval = 5 * time.Millisecond
+
+// WaitForConnections blocks until the proxy has accepted at least n connections,
+// including those it refused or closed, or ctx is done.
+func (p *Proxy) WaitForConnections(ctx context.Context, n uint32) error {
+	err := waitFor(ctx, func() bool {
+		return p.connectionCount.Load() >= n
+	})
+	if err != nil {
+		return fmt.Errorf("badnet: waiting for %d connections, saw %d: %w", n, p.connectionCount.Load(), err)
+	}
+	return nil
+}
+
+// WaitIdle blocks until every connection accepted by the proxy has been closed,
+// so nothing is in flight, or ctx is done.
+func (p *Proxy) WaitIdle(ctx context.Context) error {
+	err := waitFor(ctx, func() bool {
+		return p.active.Load() == 0
+	})
+	if err != nil {
+		return fmt.Errorf("badnet: waiting for idle, %d connections open: %w", p.active.Load(), err)
+	}
+	return nil
+}
+
+// waitFor polls ready until it's true or ctx is done.
+func waitFor(ctx context.Context, ready func() bool) error {
+	ticker := time.NewTicker(waitInterval)
+	defer ticker.Stop()
+
+	for !ready() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}